@@ -0,0 +1,74 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+)
+
+// AuditLog writes every recorded run as one JSON line (parser.ResultRecord).
+// It is safe for concurrent use
+type AuditLog struct {
+	mu        sync.Mutex
+	enc       *json.Encoder
+	redactors []parser.Redactor
+}
+
+// NewAuditLog creates an AuditLog writing to w. Redactors are applied to every
+// command line, error message and stderr before it is written
+func NewAuditLog(w io.Writer, redactors ...parser.Redactor) *AuditLog {
+	return &AuditLog{enc: json.NewEncoder(w), redactors: redactors}
+}
+
+// Record writes rr as a JSON line
+func (a *AuditLog) Record(rr *parser.RawResult) error {
+	rec := parser.NewResultRecord(rr, a.redactors...)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(rec); err != nil {
+		return fmt.Errorf("write audit record: %w", err)
+	}
+	return nil
+}
+
+// Audit records every run passing through it, including failed ones, to log.
+// An audit write failure is returned only if the run itself succeeded
+func Audit[O any](log *AuditLog) Middleware[O] {
+	return func(next RunFunc[O]) RunFunc[O] {
+		return func(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+			rr, err := next(ctx, cmd, dst, opts...)
+
+			var rec *parser.RawResult
+			switch {
+			case rr == nil:
+				rec = parser.NewRawResult(cmd)
+				rec.ExitCode = -1
+				rec.Err = err
+			case rr.Err == nil && err != nil:
+				cp := *rr
+				cp.Err = err
+				rec = &cp
+			default:
+				rec = rr
+			}
+			if logErr := log.Record(rec); logErr != nil && err == nil {
+				return rr, logErr
+			}
+			return rr, err
+		}
+	}
+}
+
+// WithAudit returns a Client that runs commands on client and records
+// each result to log. It is shorthand for Wrap(client, Audit[O](log))
+func WithAudit[O any](client Client[O], log *AuditLog) Client[O] {
+	return Wrap(client, Audit[O](log))
+}
@@ -0,0 +1,69 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+)
+
+// stubClient returns a fixed result and error for every Run
+type stubClient struct {
+	rr  *parser.RawResult
+	err error
+}
+
+func (s *stubClient) Run(_ context.Context, cmd *command.Command, _ any, _ ...string) (*parser.RawResult, error) {
+	if s.rr != nil {
+		s.rr.CmdPtr = cmd
+	}
+	return s.rr, s.err
+}
+
+func (s *stubClient) Close() error { return nil }
+
+func TestWithAudit(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   *stubClient
+		wantCode int
+		wantErr  string
+	}{
+		{"success", &stubClient{rr: &parser.RawResult{Stdout: "ok"}}, 0, ""},
+		{"failure", &stubClient{rr: &parser.RawResult{ExitCode: 3}, err: errors.New("exit 3")}, 3, "exit 3"},
+		{"no_result", &stubClient{err: errors.New("dial failed")}, -1, "dial failed"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			cl := WithAudit[string](tc.client, NewAuditLog(&buf, parser.RedactValues("hunter2")))
+
+			_, err := cl.Run(context.Background(), command.New("login -p %s", command.WithArgs("hunter2")), nil)
+			if (err != nil) != (tc.wantErr != "") {
+				t.Fatalf("err = %v; want %q", err, tc.wantErr)
+			}
+
+			var rec parser.ResultRecord
+			if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+				t.Fatalf("audit line %q: %v", buf.String(), err)
+			}
+			if rec.Command != "login -p ***" {
+				t.Errorf("Command = %q; want redacted", rec.Command)
+			}
+			if rec.ExitCode != tc.wantCode {
+				t.Errorf("ExitCode = %d; want %d", rec.ExitCode, tc.wantCode)
+			}
+			if tc.wantErr != "" && (rec.Error == nil || !strings.Contains(rec.Error.Message, tc.wantErr)) {
+				t.Errorf("Error = %+v; want message %q", rec.Error, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+// CheckedTransfer defines an interface for copies that report whether the target changed.
+// It honours FileSpec.SkipUnchanged and FileSpec.Verify
+type CheckedTransfer[O any] interface {
+	// CopyChecked transfers the file described by spec and reports the outcome
+	CopyChecked(ctx context.Context, spec *FileSpec, opts ...O) (*CopyResult, error)
+}
+
+// CopyResult is the outcome of a CopyChecked call
+type CopyResult struct {
+	Changed  bool   // the content was uploaded; false when SkipUnchanged found an identical target
+	Verified bool   // the target checksum was compared after upload (FileSpec.Verify)
+	Checksum string // hex SHA-256 of the content ("" unless SkipUnchanged or Verify is set)
+	Bytes    int64  // bytes uploaded
+	Resumed  int64  // bytes kept from a partial upload instead of being sent again
+}
+
+// NeedsChecksum reports whether the spec asks for a content checksum
+func (t *FileSpec) NeedsChecksum() bool {
+	return t.SkipUnchanged || t.Verify
+}
+
+// SHA256 returns the hex SHA-256 of the content. The content must be readable twice:
+// Data, SourcePath, FS or a seekable Reader, which is rewound to where it was
+func (t *FileContent) SHA256() (string, error) {
+	return t.PrefixSHA256(-1)
+}
+
+// PrefixSHA256 is SHA256 of the first n bytes of the content (all of it if n < 0).
+// A content shorter than n fails with an error wrapping io.ErrUnexpectedEOF
+func (t *FileContent) PrefixSHA256(n int64) (string, error) {
+	if t == nil {
+		return "", fmt.Errorf("no file content provided")
+	}
+	var seeker io.Seeker
+	if len(t.Data) == 0 && t.SourcePath == "" && t.FS == nil && t.Reader != nil {
+		s, ok := t.Reader.(io.Seeker)
+		if !ok {
+			return "", fmt.Errorf("checksum content: a non-seekable reader can only be read once " +
+				"(use Data, SourcePath, FS or a seekable Reader)")
+		}
+		seeker = s
+	}
+
+	var start int64
+	if seeker != nil {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return "", fmt.Errorf("checksum content: %w", err)
+		}
+	}
+	reader, _, err := t.ReaderAndSize()
+	if err != nil {
+		return "", fmt.Errorf("checksum content: %w", err)
+	}
+	defer reader.Close()
+
+	var src io.Reader = reader
+	if n >= 0 {
+		src = io.LimitReader(reader, n)
+	}
+	counter := &countingReader{r: src}
+	sum, err := SumSHA256(counter)
+	if err != nil {
+		return "", fmt.Errorf("checksum content: %w", err)
+	}
+	if seeker != nil {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return "", fmt.Errorf("checksum content: rewind reader: %w", err)
+		}
+	}
+	if n >= 0 && counter.n < n {
+		return "", fmt.Errorf("checksum content: content has %d of %d bytes: %w", counter.n, n, io.ErrUnexpectedEOF)
+	}
+	return sum, nil
+}
+
+// SumSHA256 reads r to the end and returns its hex SHA-256
+func SumSHA256(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyChecksum returns an error wrapping utils.ErrChecksumMismatch unless remote equals want
+func VerifyChecksum(filePath, want, remote string) error {
+	if remote != want {
+		return fmt.Errorf("%s: %w: target %s, content %s", filePath, utils.ErrChecksumMismatch, remote, want)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,106 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+func TestFileContent_SHA256(t *testing.T) {
+	// sha256("hello")
+	const want = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.WriteFile(src, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	seekable := strings.NewReader("xxhello")
+	if _, err := seekable.Seek(2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content *FileContent
+		wantErr string
+	}{
+		{"data", &FileContent{Data: []byte("hello")}, ""},
+		{"source_path", &FileContent{SourcePath: src}, ""},
+		{"fs", &FileContent{FS: fstest.MapFS{"f": {Data: []byte("hello")}}, FSPath: "f"}, ""},
+		{"seekable_reader", &FileContent{Reader: seekable}, ""},
+		{"stream", &FileContent{Reader: bufio.NewReader(strings.NewReader("hello")), Size: 5}, "can only be read once"},
+		{"nil", nil, "no file content"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sum, err := tc.content.SHA256()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("err = %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || sum != want {
+				t.Fatalf("SHA256 = %q, %v; want %q", sum, err, want)
+			}
+			// the content must still be readable in full for the upload
+			r, _, err := tc.content.ReaderAndSize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if data, _ := io.ReadAll(r); string(data) != "hello" {
+				t.Errorf("content after checksum = %q; want hello", data)
+			}
+		})
+	}
+
+	if err := VerifyChecksum("/etc/app.conf", want, "00"); !errors.Is(err, utils.ErrChecksumMismatch) {
+		t.Errorf("VerifyChecksum err = %v; want %v", err, utils.ErrChecksumMismatch)
+	}
+	if err := VerifyChecksum("/etc/app.conf", want, want); err != nil {
+		t.Errorf("VerifyChecksum equal: %v", err)
+	}
+}
+
+func TestFileContent_PrefixSHA256(t *testing.T) {
+	const (
+		helSum   = "d6a81f224bbf2f7c22baddbd5d40730eb20cfb0b3d74e10cab61788214caceb1" // sha256("hel")
+		emptySum = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" // sha256("")
+	)
+	reader := strings.NewReader("hello")
+
+	tests := []struct {
+		name    string
+		content *FileContent
+		n       int64
+		want    string
+		wantErr error
+	}{
+		{"prefix", &FileContent{Data: []byte("hello")}, 3, helSum, nil},
+		{"seekable_reader", &FileContent{Reader: reader}, 3, helSum, nil},
+		{"empty_prefix", &FileContent{Data: []byte("hello")}, 0, emptySum, nil},
+		{"too_short", &FileContent{Data: []byte("hello")}, 6, "", io.ErrUnexpectedEOF},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sum, err := tc.content.PrefixSHA256(tc.n)
+			if !errors.Is(err, tc.wantErr) || sum != tc.want {
+				t.Errorf("PrefixSHA256(%d) = %q, %v; want %q, %v", tc.n, sum, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+	if pos, _ := reader.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("reader left at %d; want 0", pos)
+	}
+}
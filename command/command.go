@@ -4,6 +4,7 @@ package command
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/ngrsoftlab/rexec/parser"
 )
@@ -47,3 +48,9 @@ func WithParser(p parser.Parser) CmdOption {
 func (c *Command) String() string {
 	return fmt.Sprintf(c.Template, c.Args...)
 }
+
+// Quote returns s as a single POSIX shell word. Everything except a single quote
+// is literal inside single quotes; each quote closes the word, is escaped and reopens it
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
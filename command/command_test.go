@@ -75,3 +75,23 @@ func TestString(t *testing.T) {
 		})
 	}
 }
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "''"},
+		{"plain", "'plain'"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME `id` \"x\" \\n", "'$HOME `id` \"x\" \\n'"},
+		{"line1\nline2", "'line1\nline2'"},
+	}
+
+	for _, tc := range tests {
+		if got := Quote(tc.in); got != tc.want {
+			t.Errorf("Quote(%q) = %s; want %s", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// defaultTreeDirMode is the mode of TargetDir when DirSpec.FolderMode is not set
+const defaultTreeDirMode = 0o755
+
+// maxLinkDepth bounds the directory symlinks followed in one path, which stops symlink loops
+const maxLinkDepth = 16
+
+// DirTransfer defines an interface for copying directory trees according to a DirSpec
+type DirTransfer[O any] interface {
+	// CopyDir uploads the tree described by spec, applying any transfer options
+	CopyDir(ctx context.Context, spec *DirSpec, opts ...O) error
+}
+
+// SymlinkPolicy selects how symbolic links in the source tree are transferred
+type SymlinkPolicy int
+
+const (
+	SymlinkFollow   SymlinkPolicy = iota // upload what the link points to (like scp -r)
+	SymlinkRecreate                      // create a link with the same target on the remote side
+	SymlinkSkip                          // ignore links
+)
+
+// DirSpec describes a local tree to upload into TargetDir.
+// Exactly one of Source and SourceDir should be set. Directories keep their
+// source mode plus owner rwx, so that read-only trees such as embed.FS can be filled
+type DirSpec struct {
+	Source     fs.FS         // tree to upload
+	SourceDir  string        // local directory to upload (used when Source is nil)
+	TargetDir  string        // destination directory receiving the tree contents
+	FolderMode os.FileMode   // permission bits for TargetDir and its parents if created (0755 by default)
+	FileMode   os.FileMode   // permission bits for every file (0 keeps source modes; embed.FS reports 0444)
+	Symlinks   SymlinkPolicy // how symbolic links are handled
+	Include    []string      // globs; when set, only matching files and links are uploaded
+	Exclude    []string      // globs; matching entries are skipped, a matching directory with its contents
+}
+
+// TreeEntry is one item of a walked DirSpec
+type TreeEntry struct {
+	Path       string      // slash-separated path relative to the tree root
+	Mode       os.FileMode // type and permission bits (fs.ModeDir, fs.ModeSymlink or regular)
+	Size       int64       // file size in bytes
+	LinkTarget string      // symlink target (SymlinkRecreate only)
+
+	fsys   fs.FS  // tree the entry belongs to
+	source string // path inside fsys
+}
+
+// IsDir reports whether the entry is a directory
+func (e *TreeEntry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// IsSymlink reports whether the entry is a symbolic link to recreate
+func (e *TreeEntry) IsSymlink() bool {
+	return e.Mode&fs.ModeSymlink != 0
+}
+
+// Open opens the content of a regular file entry
+func (e *TreeEntry) Open() (io.ReadCloser, error) {
+	if !e.Mode.IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", e.Path)
+	}
+	f, err := e.fsys.Open(e.source)
+	if err != nil {
+		return nil, fmt.Errorf("open source file: %w", err)
+	}
+	return f, nil
+}
+
+// Validate checks that the spec has a source, a target and well-formed globs
+func (d *DirSpec) Validate() error {
+	if d == nil {
+		return fmt.Errorf("directory specification empty")
+	}
+	if d.Source == nil && d.SourceDir == "" {
+		return fmt.Errorf("source required")
+	}
+	if d.TargetDir == "" {
+		return fmt.Errorf("target directory required")
+	}
+	if d.Symlinks < SymlinkFollow || d.Symlinks > SymlinkSkip {
+		return fmt.Errorf("unknown symlink policy %d", d.Symlinks)
+	}
+	for _, pattern := range append(append([]string(nil), d.Include...), d.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Walk lists the entries to upload in transfer order: lexical, each directory
+// before its contents. With Include set, directories left without any included
+// entry are dropped
+func (d *DirSpec) Walk() ([]*TreeEntry, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	fsys := d.Source
+	if fsys == nil {
+		fsys = os.DirFS(d.SourceDir)
+	}
+
+	var entries []*TreeEntry
+	if err := d.walk(fsys, ".", "", 0, &entries); err != nil {
+		return nil, err
+	}
+	if len(d.Include) > 0 {
+		entries = pruneEmptyDirs(entries)
+	}
+	return entries, nil
+}
+
+// walk appends the entries of directory dir (rel is its path in the uploaded tree);
+// links counts the directory symlinks followed to reach it
+func (d *DirSpec) walk(fsys fs.FS, dir, rel string, links int, out *[]*TreeEntry) error {
+	items, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("read dir %s: %w", dir, err)
+	}
+
+	for _, item := range items {
+		source := path.Join(dir, item.Name())
+		name := path.Join(rel, item.Name())
+		if matchGlob(d.Exclude, name) {
+			continue
+		}
+
+		mode := item.Type()
+		if mode&fs.ModeSymlink != 0 {
+			switch d.Symlinks {
+			case SymlinkSkip:
+				continue
+			case SymlinkRecreate:
+				if len(d.Include) > 0 && !matchGlob(d.Include, name) {
+					continue
+				}
+				target, err := d.readLink(fsys, source)
+				if err != nil {
+					return err
+				}
+				*out = append(*out, &TreeEntry{Path: name, Mode: fs.ModeSymlink | 0o777, LinkTarget: target, fsys: fsys, source: source})
+				continue
+			}
+		}
+
+		info, err := fs.Stat(fsys, source) // follows links
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", source, err)
+		}
+		switch {
+		case info.IsDir():
+			depth := links
+			if mode&fs.ModeSymlink != 0 {
+				if depth++; depth > maxLinkDepth {
+					return fmt.Errorf("%s: more than %d nested directory symlinks (symlink loop?)", name, maxLinkDepth)
+				}
+			}
+			*out = append(*out, &TreeEntry{Path: name, Mode: fs.ModeDir | info.Mode().Perm() | 0o700, fsys: fsys, source: source})
+			if err := d.walk(fsys, source, name, depth, out); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if len(d.Include) > 0 && !matchGlob(d.Include, name) {
+				continue
+			}
+			mode := info.Mode().Perm()
+			if d.FileMode != 0 {
+				mode = d.FileMode.Perm()
+			}
+			*out = append(*out, &TreeEntry{Path: name, Mode: mode, Size: info.Size(), fsys: fsys, source: source})
+		default:
+			// devices, sockets and pipes cannot be uploaded
+		}
+	}
+	return nil
+}
+
+// FileSpecs walks the tree and returns one FileSpec per file, reading its
+// content from the source on upload, for transfers that only implement Copy.
+// Directories come from FolderMode; symlinks are only supported with SymlinkFollow
+// or SymlinkSkip
+func (d *DirSpec) FileSpecs() ([]*FileSpec, error) {
+	entries, err := d.Walk()
+	if err != nil {
+		return nil, err
+	}
+
+	folderMode := d.FolderMode
+	if folderMode == 0 {
+		folderMode = defaultTreeDirMode
+	}
+	var specs []*FileSpec
+	for _, e := range entries {
+		switch {
+		case e.IsSymlink():
+			return nil, fmt.Errorf("%s: symlinks cannot be uploaded as files", e.Path)
+		case e.IsDir():
+			continue
+		}
+		specs = append(specs, &FileSpec{
+			TargetDir:  path.Join(d.TargetDir, path.Dir(e.Path)),
+			Filename:   path.Base(e.Path),
+			Mode:       e.Mode.Perm(),
+			FolderMode: folderMode,
+			Content:    &FileContent{FS: e.fsys, FSPath: e.source},
+		})
+	}
+	return specs, nil
+}
+
+// FileSpecsFromFS returns a FileSpec for every regular file in fsys, placed under
+// targetDir with the same relative path. Use fs.Sub to upload a subtree
+func FileSpecsFromFS(fsys fs.FS, targetDir string) ([]*FileSpec, error) {
+	return (&DirSpec{Source: fsys, TargetDir: targetDir}).FileSpecs()
+}
+
+// readLink returns the target of the symlink at name. fs.FS has no portable
+// readlink before Go 1.25, so trees other than SourceDir must implement ReadLink
+func (d *DirSpec) readLink(fsys fs.FS, name string) (string, error) {
+	if rl, ok := fsys.(interface{ ReadLink(string) (string, error) }); ok {
+		return rl.ReadLink(name)
+	}
+	if d.Source == nil {
+		target, err := os.Readlink(filepath.Join(d.SourceDir, filepath.FromSlash(name)))
+		if err != nil {
+			return "", fmt.Errorf("read link %s: %w", name, err)
+		}
+		return target, nil
+	}
+	return "", fmt.Errorf("read link %s: source fs.FS does not support ReadLink", name)
+}
+
+// matchGlob reports whether any glob matches name or its base name
+func matchGlob(patterns []string, name string) bool {
+	base := path.Base(name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneEmptyDirs drops directories that contain no file or link entry
+func pruneEmptyDirs(entries []*TreeEntry) []*TreeEntry {
+	keep := make(map[string]bool)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		for dir := path.Dir(e.Path); dir != "."; dir = path.Dir(dir) {
+			keep[dir] = true
+		}
+	}
+	kept := entries[:0]
+	for _, e := range entries {
+		if !e.IsDir() || keep[e.Path] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
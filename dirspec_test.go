@@ -0,0 +1,234 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDirSpec_Validate(t *testing.T) {
+	src := fstest.MapFS{}
+	tests := []struct {
+		name    string
+		spec    *DirSpec
+		wantErr string
+	}{
+		{"nil_spec", nil, "directory specification empty"},
+		{"no_source", &DirSpec{TargetDir: "/t"}, "source required"},
+		{"no_target", &DirSpec{Source: src}, "target directory required"},
+		{"bad_policy", &DirSpec{Source: src, TargetDir: "/t", Symlinks: 7}, "unknown symlink policy"},
+		{"bad_glob", &DirSpec{Source: src, TargetDir: "/t", Exclude: []string{"[a-"}}, "invalid glob"},
+		{"valid", &DirSpec{SourceDir: ".", TargetDir: "/t", Include: []string{"*.conf"}}, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.spec.Validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v; want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDirSpec_Walk(t *testing.T) {
+	// MapFS synthesizes parent directories with mode 0555; owner rwx is added
+	src := fstest.MapFS{
+		"app.conf":             {Data: []byte("a"), Mode: 0o640},
+		"bin/run.sh":           {Data: []byte("#!/bin/sh"), Mode: 0o755},
+		"conf.d/x.conf":        {Data: []byte("x"), Mode: 0o644},
+		"conf.d/x.conf.bak":    {Data: []byte("old"), Mode: 0o644},
+		"cache/tmp/blob":       {Data: []byte("blob"), Mode: 0o600},
+		"empty":                {Mode: fs.ModeDir | 0o700},
+		"conf.d/nested/y.conf": {Data: []byte("y"), Mode: 0o644},
+	}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{"all", nil, nil, []string{
+			"app.conf 640", "bin/ d755", "bin/run.sh 755", "cache/ d755", "cache/tmp/ d755", "cache/tmp/blob 600",
+			"conf.d/ d755", "conf.d/nested/ d755", "conf.d/nested/y.conf 644", "conf.d/x.conf 644", "conf.d/x.conf.bak 644", "empty/ d700",
+		}},
+		{"exclude_prunes_dir", nil, []string{"cache", "*.bak"}, []string{
+			"app.conf 640", "bin/ d755", "bin/run.sh 755",
+			"conf.d/ d755", "conf.d/nested/ d755", "conf.d/nested/y.conf 644", "conf.d/x.conf 644", "empty/ d700",
+		}},
+		{"include_drops_empty_dirs", []string{"*.conf"}, nil, []string{
+			"app.conf 640", "conf.d/ d755", "conf.d/nested/ d755", "conf.d/nested/y.conf 644", "conf.d/x.conf 644",
+		}},
+		{"include_full_path", []string{"conf.d/*.conf"}, nil, []string{
+			"conf.d/ d755", "conf.d/x.conf 644",
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := &DirSpec{Source: src, TargetDir: "/t", Include: tc.include, Exclude: tc.exclude}
+			entries, err := spec.Walk()
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			if got := describeEntries(entries); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("entries =\n%q\nwant\n%q", got, tc.want)
+			}
+		})
+	}
+
+	entries, err := (&DirSpec{Source: src, TargetDir: "/t", Include: []string{"app.conf"}}).Walk()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Walk = %v, %v", entries, err)
+	}
+	r, err := entries[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); string(data) != "a" {
+		t.Errorf("content = %q; want %q", data, "a")
+	}
+}
+
+func TestDirSpec_WalkSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite := func(name, data string) {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite("real/file", "data")
+	if err := os.Symlink("real/file", filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if err := os.Symlink("real", filepath.Join(dir, "dirlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(".", filepath.Join(dir, "real", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		policy  SymlinkPolicy
+		exclude []string
+		want    []string
+		wantErr string
+	}{
+		{"follow", SymlinkFollow, []string{"loop"}, []string{
+			"dirlink/ d755", "dirlink/file 644", "link 644", "real/ d755", "real/file 644",
+		}, ""},
+		{"follow_loop", SymlinkFollow, nil, nil, "symlink loop"},
+		{"recreate", SymlinkRecreate, nil, []string{
+			"dirlink -> real", "link -> real/file", "real/ d755", "real/file 644", "real/loop -> .",
+		}, ""},
+		{"skip", SymlinkSkip, nil, []string{"real/ d755", "real/file 644"}, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := &DirSpec{SourceDir: dir, TargetDir: "/t", Symlinks: tc.policy, Exclude: tc.exclude}
+			entries, err := spec.Walk()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("err = %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			if got := describeEntries(entries); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("entries =\n%q\nwant\n%q", got, tc.want)
+			}
+		})
+	}
+}
+
+// describeEntries renders entries as "path mode", "dir/ dMODE" or "link -> target"
+func describeEntries(entries []*TreeEntry) []string {
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		switch {
+		case e.IsSymlink():
+			out = append(out, e.Path+" -> "+e.LinkTarget)
+		case e.IsDir():
+			out = append(out, fmt.Sprintf("%s/ d%o", e.Path, e.Mode.Perm()))
+		default:
+			out = append(out, fmt.Sprintf("%s %o", e.Path, e.Mode.Perm()))
+		}
+	}
+	return out
+}
+
+func TestDirSpec_FileSpecs(t *testing.T) {
+	// embed.FS reports files as 0444
+	src := fstest.MapFS{
+		"install.sh":      {Data: []byte("#!/bin/sh"), Mode: 0o444},
+		"conf/app.conf":   {Data: []byte("port=80"), Mode: 0o444},
+		"conf/extra/x.rc": {Data: []byte("x"), Mode: 0o444},
+	}
+
+	specs, err := (&DirSpec{Source: src, TargetDir: "/opt/app", FileMode: 0o755}).FileSpecs()
+	if err != nil {
+		t.Fatalf("FileSpecs: %v", err)
+	}
+	var got []string
+	for _, spec := range specs {
+		if err := spec.Validate(); err != nil {
+			t.Errorf("%s: %v", spec.Filename, err)
+		}
+		r, size, err := spec.Content.ReaderAndSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		got = append(got, fmt.Sprintf("%s/%s %o %o %d %s", spec.TargetDir, spec.Filename, spec.Mode, spec.FolderMode, size, data))
+	}
+	want := []string{
+		"/opt/app/conf/app.conf 755 755 7 port=80",
+		"/opt/app/conf/extra/x.rc 755 755 1 x",
+		"/opt/app/install.sh 755 755 9 #!/bin/sh",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("specs =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	sub, err := fs.Sub(src, "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	specs, err = FileSpecsFromFS(sub, "/etc/app")
+	if err != nil || len(specs) != 2 || specs[0].TargetDir != "/etc/app" || specs[0].Mode != 0o444 || specs[1].TargetDir != "/etc/app/extra" {
+		t.Errorf("FileSpecsFromFS = %+v, %v; want app.conf and extra/x.rc under /etc/app", specs, err)
+	}
+
+	dir := t.TempDir()
+	if err := os.Symlink("target", filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if _, err := (&DirSpec{SourceDir: dir, TargetDir: "/t", Symlinks: SymlinkRecreate}).FileSpecs(); err == nil || !strings.Contains(err.Error(), "symlinks cannot be uploaded") {
+		t.Errorf("recreate err = %v; want symlink error", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+// interface guard: ensure DryRunClient satisfies Client for any option type
+var _ Client[any] = (*DryRunClient[any])(nil)
+
+// PlannedRun is one command recorded by DryRunClient instead of being executed
+type PlannedRun[O any] struct {
+	Command *command.Command // command as passed to Run
+	Line    string           // rendered command line
+	Options []O              // run options as passed to Run
+	Target  string           // target the command would run on
+	Time    time.Time        // when Run was called
+}
+
+// DryRunClient implements Client without executing anything. It records every
+// command and returns canned results (or empty successful ones), so existing
+// code can produce a plan of what it would do. It is safe for concurrent use
+type DryRunClient[O any] struct {
+	target string
+
+	mu      sync.Mutex
+	runs    []PlannedRun[O]
+	results map[string]*parser.RawResult // canned results by command line
+}
+
+// NewDryRunClient creates a DryRunClient reporting target (e.g. "alice@web-1") as the host
+func NewDryRunClient[O any](target string) *DryRunClient[O] {
+	return &DryRunClient[O]{
+		target:  target,
+		results: make(map[string]*parser.RawResult),
+	}
+}
+
+// SetResult makes Run return a copy of rr for commands rendering to line.
+// Parsers are applied to canned results, so provide the output they expect
+func (c *DryRunClient[O]) SetResult(line string, rr *parser.RawResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[line] = cloneResult(rr)
+}
+
+// Run records cmd and returns its canned result, or an empty successful one
+func (c *DryRunClient[O]) Run(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cmd == nil {
+		return nil, utils.ErrCommandNil
+	}
+
+	line := cmd.String()
+	now := time.Now()
+
+	c.mu.Lock()
+	c.runs = append(c.runs, PlannedRun[O]{
+		Command: cmd,
+		Line:    line,
+		Options: append([]O(nil), opts...),
+		Target:  c.target,
+		Time:    now,
+	})
+	canned, ok := c.results[line]
+	c.mu.Unlock()
+
+	result := parser.NewRawResult(cmd)
+	if ok {
+		result = cloneResult(canned)
+		result.CmdPtr = cmd
+		result.Attempt = 1
+	}
+	result.Host = c.target
+	result.StartedAt = now
+	result.FinishedAt = now
+
+	if ok && cmd.Parser != nil && dst != nil {
+		if parseErr := cmd.Parser.Parse(result, dst); parseErr != nil {
+			result.Err = fmt.Errorf("parse error: %w", parseErr)
+		}
+	}
+	return result, result.Err
+}
+
+// cloneResult copies rr without sharing its output bytes or EnvKeys, so that
+// callers of Run cannot change what later runs of the same command see
+func cloneResult(rr *parser.RawResult) *parser.RawResult {
+	cp := *rr
+	cp.StdoutBytes = bytes.Clone(rr.StdoutBytes)
+	cp.StderrBytes = bytes.Clone(rr.StderrBytes)
+	cp.EnvKeys = slices.Clone(rr.EnvKeys)
+	return &cp
+}
+
+// Runs returns the recorded commands in call order
+func (c *DryRunClient[O]) Runs() []PlannedRun[O] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]PlannedRun[O](nil), c.runs...)
+}
+
+// Reset forgets recorded commands but keeps canned results
+func (c *DryRunClient[O]) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runs = nil
+}
+
+// Plan writes a numbered list of recorded commands to w
+func (c *DryRunClient[O]) Plan(w io.Writer) error {
+	runs := c.Runs()
+	if _, err := fmt.Fprintf(w, "plan for %s: %d command(s)\n", c.target, len(runs)); err != nil {
+		return err
+	}
+	for i, r := range runs {
+		line := fmt.Sprintf("%3d. %s", i+1, r.Line)
+		if n := len(r.Options); n > 0 {
+			line += fmt.Sprintf("  (%d option(s))", n)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close is a no-op
+func (c *DryRunClient[O]) Close() error {
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+// upperParser stores raw stdout in upper case into *string
+type upperParser struct{}
+
+func (upperParser) Parse(raw *parser.RawResult, dst any) error {
+	*dst.(*string) = strings.ToUpper(raw.Stdout)
+	return nil
+}
+
+func TestDryRunClient(t *testing.T) {
+	cl := NewDryRunClient[string]("alice@web-1")
+	cl.SetResult("hostname", &parser.RawResult{Stdout: "web-1"})
+
+	ctx := context.Background()
+	if err := RunNoResult[string](ctx, cl, command.New("mkdir -p %s", command.WithArgs("/opt/app")), "opt-a", "opt-b"); err != nil {
+		t.Fatalf("RunNoResult error = %v", err)
+	}
+	host, err := RunParse[string, string](ctx, cl, command.New("hostname", command.WithParser(upperParser{})))
+	if err != nil {
+		t.Fatalf("RunParse error = %v", err)
+	}
+	if host != "WEB-1" {
+		t.Errorf("parsed canned result = %q; want %q", host, "WEB-1")
+	}
+
+	stdout, _, code, err := RunRaw[string](ctx, cl, command.New("systemctl restart app"))
+	if err != nil || stdout != "" || code != 0 {
+		t.Errorf("RunRaw = %q, %d, %v; want empty successful result", stdout, code, err)
+	}
+
+	runs := cl.Runs()
+	if len(runs) != 3 {
+		t.Fatalf("len(Runs()) = %d; want 3", len(runs))
+	}
+	if runs[0].Line != "mkdir -p /opt/app" || len(runs[0].Options) != 2 || runs[0].Target != "alice@web-1" {
+		t.Errorf("Runs()[0] = %+v; want rendered mkdir with 2 options", runs[0])
+	}
+
+	var plan bytes.Buffer
+	if err := cl.Plan(&plan); err != nil {
+		t.Fatalf("Plan error = %v", err)
+	}
+	want := "plan for alice@web-1: 3 command(s)\n" +
+		"  1. mkdir -p /opt/app  (2 option(s))\n" +
+		"  2. hostname\n" +
+		"  3. systemctl restart app\n"
+	if plan.String() != want {
+		t.Errorf("Plan =\n%s\nwant\n%s", plan.String(), want)
+	}
+
+	cl.Reset()
+	if len(cl.Runs()) != 0 {
+		t.Errorf("Runs() after Reset = %d; want 0", len(cl.Runs()))
+	}
+
+	if _, err := cl.Run(ctx, nil, nil); !errors.Is(err, utils.ErrCommandNil) {
+		t.Errorf("Run(nil) error = %v; want %v", err, utils.ErrCommandNil)
+	}
+}
+
+func TestDryRunClient_CannedResultCopied(t *testing.T) {
+	cl := NewDryRunClient[string]("web-1")
+	canned := &parser.RawResult{Stdout: "ok", StdoutBytes: []byte("ok"), StderrBytes: []byte("warn"), EnvKeys: []string{"A"}}
+	cl.SetResult("check", canned)
+	canned.StdoutBytes[0], canned.EnvKeys[0] = 'X', "X"
+
+	ctx := context.Background()
+	for i := range 2 {
+		rr, err := cl.Run(ctx, command.New("check"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rr.StdoutBytes) != "ok" || string(rr.StderrBytes) != "warn" || rr.EnvKeys[0] != "A" {
+			t.Fatalf("run %d = %q, %q, %v; want the canned result unchanged", i+1, rr.StdoutBytes, rr.StderrBytes, rr.EnvKeys)
+		}
+		rr.StdoutBytes[0], rr.StderrBytes[0], rr.EnvKeys[0] = 'X', 'X', "X"
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ngrsoftlab/rexec/command"
+)
+
+// EnvMode selects the environment a command starts with
+type EnvMode int
+
+const (
+	EnvInherit EnvMode = iota // start from the client process (local) or login session (ssh) environment
+	EnvClear                  // start empty: only configured variables are set (set PATH yourself)
+)
+
+// String returns the mode name
+func (m EnvMode) String() string {
+	switch m {
+	case EnvInherit:
+		return "inherit"
+	case EnvClear:
+		return "clear"
+	default:
+		return fmt.Sprintf("EnvMode(%d)", int(m))
+	}
+}
+
+// ValidateEnv checks that every key is a portable variable name ([A-Za-z_][A-Za-z0-9_]*)
+func ValidateEnv(env map[string]string) error {
+	for k := range env {
+		if !validEnvName(k) {
+			return fmt.Errorf("invalid environment variable name %q", k)
+		}
+	}
+	return nil
+}
+
+// validEnvName reports whether name is a portable shell variable name
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// EnvCommand wraps line so that it runs with env applied, using POSIX quoting:
+// `env [-i] K='v' ... sh -c '<line>'`. Keys are sorted. With EnvInherit and no
+// variables line is returned unchanged
+func EnvCommand(line string, env map[string]string, mode EnvMode) string {
+	if mode == EnvInherit && len(env) == 0 {
+		return line
+	}
+
+	var b strings.Builder
+	b.WriteString("env")
+	if mode == EnvClear {
+		b.WriteString(" -i")
+	}
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		b.WriteString(" " + k + "=" + command.Quote(env[k]))
+	}
+	b.WriteString(" sh -c " + command.Quote(line))
+	return b.String()
+}
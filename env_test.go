@@ -0,0 +1,69 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestEnvCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		env  map[string]string
+		mode EnvMode
+		want string
+	}{
+		{"inherit_empty", "echo hi", nil, EnvInherit, "echo hi"},
+		{"inherit", "echo $A", map[string]string{"B": "2", "A": "1"}, EnvInherit, `env A='1' B='2' sh -c 'echo $A'`},
+		{"clear_empty", "id", nil, EnvClear, `env -i sh -c 'id'`},
+		{"quotes", "echo it's", map[string]string{"Q": "a'b"}, EnvClear, `env -i Q='a'\''b' sh -c 'echo it'\''s'`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := EnvCommand(tc.line, tc.env, tc.mode); got != tc.want {
+				t.Errorf("EnvCommand = %s; want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEnvCommandShell(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH, skipping")
+	}
+
+	value := "$HOME `id` \"q\" it's \\n\nnext"
+	line := EnvCommand(`printf '%s' "$V"`, map[string]string{"V": value}, EnvInherit)
+	out, err := exec.Command("sh", "-c", line).Output()
+	if err != nil {
+		t.Fatalf("run %s: %v", line, err)
+	}
+	if string(out) != value {
+		t.Errorf("value = %q; want %q", out, value)
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"valid", map[string]string{"A": "", "_b1": "x"}, false},
+		{"empty_name", map[string]string{"": "x"}, true},
+		{"leading_digit", map[string]string{"1A": "x"}, true},
+		{"space", map[string]string{"A B": "x"}, true},
+		{"equals", map[string]string{"A=B": "x"}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ValidateEnv(tc.env); (err != nil) != tc.wantErr {
+				t.Errorf("ValidateEnv err = %v; wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
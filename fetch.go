@@ -0,0 +1,190 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultFetchMode is the local file mode when neither FetchSpec.Mode nor the remote mode is known
+const defaultFetchMode = 0o644
+
+// FileFetcher defines an interface for downloading files according to a FetchSpec
+type FileFetcher[O any] interface {
+	// Fetch reads the remote file described by spec into its local destination
+	Fetch(ctx context.Context, spec *FetchSpec, opts ...O) error
+}
+
+// FetchSpec describes a file to download from the target.
+// Exactly one of Writer and LocalPath should be set
+type FetchSpec struct {
+	RemotePath    string      // file to read on the target
+	Writer        io.Writer   // destination stream
+	LocalPath     string      // local file to create or replace; parent directories are created
+	Mode          os.FileMode // LocalPath permission bits (0 keeps the remote mode)
+	PreserveTimes bool        // set the LocalPath modification (and, if known, access) time to the remote ones
+}
+
+// Validate checks that the spec has a remote path and exactly one destination
+func (s *FetchSpec) Validate() error {
+	if s == nil {
+		return fmt.Errorf("fetch specification empty")
+	}
+	if s.RemotePath == "" {
+		return fmt.Errorf("remote path required")
+	}
+	if (s.Writer == nil) == (s.LocalPath == "") {
+		return fmt.Errorf("exactly one of writer and local path required")
+	}
+	return nil
+}
+
+// Write stores a downloaded file: fill copies the content into the destination.
+// For LocalPath it writes a hidden temp file (AtomicTempName) in the same directory,
+// applies Mode (or remoteMode) and, with PreserveTimes, mtime and atime (a zero atime
+// is left unchanged), and renames it over LocalPath. If fill or any step fails the
+// temp file is removed and an existing LocalPath is left untouched. It returns the
+// bytes written by fill
+func (s *FetchSpec) Write(remoteMode os.FileMode, mtime, atime time.Time, fill func(w io.Writer) (int64, error)) (n int64, err error) {
+	if s.LocalPath == "" {
+		return fill(s.Writer)
+	}
+
+	mode := s.localMode(remoteMode)
+	if err := os.MkdirAll(filepath.Dir(s.LocalPath), 0o755); err != nil {
+		return 0, fmt.Errorf("create local directory: %w", err)
+	}
+	tmpPath := filepath.Join(filepath.Dir(s.LocalPath), AtomicTempName(filepath.Base(s.LocalPath)))
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("create local temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			err = errors.Join(err, removeTemp(tmpPath))
+		}
+	}()
+
+	if n, err = fill(f); err != nil {
+		return n, err
+	}
+	if err := f.Sync(); err != nil {
+		return n, fmt.Errorf("sync local temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return n, fmt.Errorf("close local temp file: %w", err)
+	}
+	if err := s.finishLocal(tmpPath, mode, mtime, atime); err != nil {
+		return n, err
+	}
+	if err := os.Rename(tmpPath, s.LocalPath); err != nil {
+		return n, fmt.Errorf("rename local temp file: %w", err)
+	}
+	return n, nil
+}
+
+// PartialPath returns the file a resumable download of LocalPath is written to
+// before it is renamed: PartialName(LocalPath) in the same directory
+func (s *FetchSpec) PartialPath() string {
+	return filepath.Join(filepath.Dir(s.LocalPath), PartialName(filepath.Base(s.LocalPath)))
+}
+
+// PartialSize returns the size of PartialPath, or 0 if there is none
+func (s *FetchSpec) PartialSize() (int64, error) {
+	info, err := os.Stat(s.PartialPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("stat partial file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("partial file %s is not a regular file", s.PartialPath())
+	}
+	return info.Size(), nil
+}
+
+// WriteFrom is Write for resumable downloads into LocalPath: PartialPath is cut to
+// offset bytes and fill appends the rest. The complete file is synced, gets its mode
+// and times and is renamed over LocalPath. Unlike Write, the partial file is kept if
+// fill fails, so that a later call can continue it
+func (s *FetchSpec) WriteFrom(offset int64, remoteMode os.FileMode, mtime, atime time.Time, fill func(w io.Writer) (int64, error)) (int64, error) {
+	if s.LocalPath == "" {
+		return 0, fmt.Errorf("resumable download needs a local path")
+	}
+
+	mode := s.localMode(remoteMode)
+	if err := os.MkdirAll(filepath.Dir(s.LocalPath), 0o755); err != nil {
+		return 0, fmt.Errorf("create local directory: %w", err)
+	}
+	partial := s.PartialPath()
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("open partial file: %w", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return 0, fmt.Errorf("truncate partial file: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek partial file: %w", err)
+	}
+
+	n, err := fill(f)
+	if err != nil {
+		return n, err
+	}
+	if err := f.Sync(); err != nil {
+		return n, fmt.Errorf("sync partial file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return n, fmt.Errorf("close partial file: %w", err)
+	}
+	if err := s.finishLocal(partial, mode, mtime, atime); err != nil {
+		return n, err
+	}
+	if err := os.Rename(partial, s.LocalPath); err != nil {
+		return n, fmt.Errorf("rename partial file: %w", err)
+	}
+	return n, nil
+}
+
+// localMode returns Mode, else the remote mode, else defaultFetchMode
+func (s *FetchSpec) localMode(remoteMode os.FileMode) os.FileMode {
+	mode := s.Mode.Perm()
+	if mode == 0 {
+		mode = remoteMode.Perm()
+	}
+	if mode == 0 {
+		mode = defaultFetchMode
+	}
+	return mode
+}
+
+// finishLocal applies mode and, with PreserveTimes, the remote times to the downloaded file at p
+func (s *FetchSpec) finishLocal(p string, mode os.FileMode, mtime, atime time.Time) error {
+	if err := os.Chmod(p, mode); err != nil {
+		return fmt.Errorf("chmod local file: %w", err)
+	}
+	if s.PreserveTimes && !mtime.IsZero() {
+		if err := os.Chtimes(p, atime, mtime); err != nil {
+			return fmt.Errorf("set local file times: %w", err)
+		}
+	}
+	return nil
+}
+
+// removeTemp deletes the temp file of an incomplete download
+func removeTemp(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove local temp file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchSpec_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *FetchSpec
+		wantErr string
+	}{
+		{"nil_spec", nil, "fetch specification empty"},
+		{"no_remote", &FetchSpec{LocalPath: "/tmp/x"}, "remote path required"},
+		{"no_destination", &FetchSpec{RemotePath: "/var/log/syslog"}, "exactly one of writer and local path"},
+		{"two_destinations", &FetchSpec{RemotePath: "/var/log/syslog", LocalPath: "/tmp/x", Writer: io.Discard}, "exactly one of writer and local path"},
+		{"writer", &FetchSpec{RemotePath: "/var/log/syslog", Writer: io.Discard}, ""},
+		{"local_path", &FetchSpec{RemotePath: "/var/log/syslog", LocalPath: "/tmp/x"}, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.spec.Validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v; want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetchSpec_Write(t *testing.T) {
+	mtime := time.Unix(1600000000, 0)
+	fill := func(w io.Writer) (int64, error) {
+		n, err := io.WriteString(w, "payload")
+		return int64(n), err
+	}
+
+	tests := []struct {
+		name       string
+		mode       os.FileMode
+		remoteMode os.FileMode
+		preserve   bool
+		wantMode   os.FileMode
+		wantMtime  bool
+	}{
+		{"remote_mode", 0, 0o640, false, 0o640, false},
+		{"override_mode", 0o600, 0o755, false, 0o600, false},
+		{"default_mode", 0, 0, false, 0o644, false},
+		{"preserve_times", 0, 0o644, true, 0o644, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			local := filepath.Join(t.TempDir(), "sub", "out.txt")
+			spec := &FetchSpec{RemotePath: "/r", LocalPath: local, Mode: tc.mode, PreserveTimes: tc.preserve}
+			n, err := spec.Write(tc.remoteMode, mtime, time.Time{}, fill)
+			if err != nil || n != 7 {
+				t.Fatalf("Write = %d, %v; want 7, nil", n, err)
+			}
+			info, err := os.Stat(local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tc.wantMode {
+				t.Errorf("mode = %o; want %o", info.Mode().Perm(), tc.wantMode)
+			}
+			if got := info.ModTime().Equal(mtime); got != tc.wantMtime {
+				t.Errorf("mtime = %v; preserved %v, want %v", info.ModTime(), got, tc.wantMtime)
+			}
+		})
+	}
+
+	t.Run("writer", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := (&FetchSpec{RemotePath: "/r", Writer: &buf}).Write(0o600, mtime, mtime, fill); err != nil || buf.String() != "payload" {
+			t.Errorf("Write = %q, %v; want payload", buf.String(), err)
+		}
+	})
+
+	t.Run("failure_keeps_existing", func(t *testing.T) {
+		errBroken := errors.New("connection lost")
+		dir := t.TempDir()
+		local := filepath.Join(dir, "out.txt")
+		if err := os.WriteFile(local, []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := (&FetchSpec{RemotePath: "/r", LocalPath: local}).Write(0o644, mtime, mtime, func(w io.Writer) (int64, error) {
+			n, _ := io.WriteString(w, "part")
+			return int64(n), errBroken
+		})
+		if !errors.Is(err, errBroken) {
+			t.Errorf("err = %v; want %v", err, errBroken)
+		}
+		if data, err := os.ReadFile(local); err != nil || string(data) != "old" {
+			t.Errorf("existing file = %q, %v; want it untouched", data, err)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("dir entries = %v; want the temp file removed", entries)
+		}
+	})
+
+	t.Run("failure_leaves_nothing", func(t *testing.T) {
+		dir := t.TempDir()
+		local := filepath.Join(dir, "out.txt")
+		_, err := (&FetchSpec{RemotePath: "/r", LocalPath: local}).Write(0o644, mtime, mtime, func(w io.Writer) (int64, error) {
+			return 0, context.Canceled
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v; want %v", err, context.Canceled)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("dir entries = %v; want none", entries)
+		}
+	})
+}
+
+func TestFetchSpec_WriteFrom(t *testing.T) {
+	mtime := time.Unix(1600000000, 0)
+	errBroken := errors.New("connection lost")
+	local := filepath.Join(t.TempDir(), "sub", "out.txt")
+	spec := &FetchSpec{RemotePath: "/r", LocalPath: local, PreserveTimes: true}
+
+	if n, err := spec.PartialSize(); err != nil || n != 0 {
+		t.Fatalf("PartialSize without partial = %d, %v; want 0, nil", n, err)
+	}
+
+	// a failed download keeps what it wrote
+	_, err := spec.WriteFrom(0, 0o640, mtime, time.Time{}, func(w io.Writer) (int64, error) {
+		n, _ := io.WriteString(w, "payXXX")
+		return int64(n), errBroken
+	})
+	if !errors.Is(err, errBroken) {
+		t.Fatalf("err = %v; want %v", err, errBroken)
+	}
+	if n, err := spec.PartialSize(); err != nil || n != 6 {
+		t.Fatalf("PartialSize = %d, %v; want 6, nil", n, err)
+	}
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Errorf("local file created for a failed download: %v", err)
+	}
+
+	// resuming at 3 drops the bytes past the offset
+	n, err := spec.WriteFrom(3, 0o640, mtime, time.Time{}, func(w io.Writer) (int64, error) {
+		n, err := io.WriteString(w, "load")
+		return int64(n), err
+	})
+	if err != nil || n != 4 {
+		t.Fatalf("WriteFrom = %d, %v; want 4, nil", n, err)
+	}
+	data, err := os.ReadFile(local)
+	if err != nil || string(data) != "payload" {
+		t.Fatalf("content = %q, %v; want payload", data, err)
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 || !info.ModTime().Equal(mtime) {
+		t.Errorf("mode, mtime = %o, %v; want 640, %v", info.Mode().Perm(), info.ModTime(), mtime)
+	}
+	if _, err := os.Stat(spec.PartialPath()); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
@@ -5,9 +5,16 @@ package rexec
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"time"
+
+	"github.com/ngrsoftlab/rexec/utils"
 )
 
 // FileTransfer defines an interface for copying files according to a FileSpec
@@ -17,13 +24,20 @@ type FileTransfer[O any] interface {
 }
 
 // FileContent holds the source of file data for transfer.
-// Only one of Data, SourcePath, or Reader should be set
+// Only one of Data, SourcePath, Reader or FS should be set
 type FileContent struct {
 	Reader     io.Reader // stream to read file data from
 	Data       []byte    // in-memory file data
 	SourcePath string    // path to the file on disk
+	FS         fs.FS     // file system holding FSPath (e.g. an embed.FS)
+	FSPath     string    // slash-separated path of the file inside FS
+	Size       int64     // length of a non-seekable Reader, if known (SCP needs it)
+	SizeKnown  bool      // Size is exact even when it is 0; otherwise 0 means unknown
 }
 
+// UnknownSize is the size Stream reports for a non-seekable Reader of unknown length
+const UnknownSize int64 = -1
+
 // FileSpec describes where and how to create a file on the target
 type FileSpec struct {
 	TargetDir  string       // destination directory
@@ -31,6 +45,71 @@ type FileSpec struct {
 	Mode       os.FileMode  // file permission bits
 	FolderMode os.FileMode  // permission bits for any created directories
 	Content    *FileContent // file data and source information
+	Atomic     bool         // write to a hidden temp file in TargetDir and rename it over Filename
+	// SkipUnchanged leaves the target alone if its SHA-256 equals the content's (only data is compared)
+	SkipUnchanged bool
+	Verify        bool      // compare the SHA-256 of the target with the content's after upload
+	Owner         string    // user name or numeric uid to chown the file to ("" keeps the default)
+	Group         string    // group name or numeric gid to chown the file to ("" keeps the default)
+	ModTime       time.Time // modification time to set (zero keeps the upload time)
+	AccessTime    time.Time // access time to set with ModTime (zero means ModTime)
+	PreserveTimes bool      // use the SourcePath or FS file modification time when ModTime is zero
+	Backup        bool      // copy an existing target to BackupName(Filename, now) before writing it
+}
+
+// PartialName returns the hidden name a resumable transfer writes filename to
+// before renaming it: ".<filename>.rexec-partial". It is stable so that a later
+// attempt can find and continue the partial file
+func PartialName(filename string) string {
+	return "." + filename + ".rexec-partial"
+}
+
+// backupTimeLayout formats the timestamp suffix of backup files
+const backupTimeLayout = "20060102T150405Z"
+
+// BackupName returns the name of the backup of filename taken at now:
+// "<filename>.bak.<UTC timestamp>", e.g. "app.conf.bak.20250102T150405Z"
+func BackupName(filename string, now time.Time) string {
+	return filename + ".bak." + now.UTC().Format(backupTimeLayout)
+}
+
+// Times returns the modification and access times to set on the target: ModTime and
+// AccessTime, or with PreserveTimes the modification time of the SourcePath or FS file
+// (used for both). Zero times mean the target keeps the upload time
+func (t *FileSpec) Times() (mtime, atime time.Time, err error) {
+	mtime = t.ModTime
+	if mtime.IsZero() && t.PreserveTimes && t.Content != nil {
+		var info fs.FileInfo
+		switch {
+		case len(t.Content.Data) > 0:
+		case t.Content.SourcePath != "":
+			info, err = os.Stat(t.Content.SourcePath)
+		case t.Content.FS != nil:
+			info, err = fs.Stat(t.Content.FS, t.Content.FSPath)
+		}
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("stat source times: %w", err)
+		}
+		if info != nil {
+			mtime = info.ModTime()
+		}
+	}
+	if mtime.IsZero() {
+		return time.Time{}, time.Time{}, nil
+	}
+	atime = mtime
+	if !t.ModTime.IsZero() && !t.AccessTime.IsZero() {
+		atime = t.AccessTime
+	}
+	return mtime, atime, nil
+}
+
+// AtomicTempName returns the hidden temp file name used by atomic writes of filename:
+// ".<filename>.rexec-<random>", which stays in the same directory so the rename is atomic
+func AtomicTempName(filename string) string {
+	var b [6]byte
+	_, _ = rand.Read(b[:])
+	return "." + filename + ".rexec-" + hex.EncodeToString(b[:])
 }
 
 // Validate checks that the spec has all required fields and content
@@ -47,14 +126,24 @@ func (t *FileSpec) Validate() error {
 	if t.Content == nil {
 		return fmt.Errorf("file content required")
 	}
-	if len(t.Content.Data) == 0 && t.Content.SourcePath == "" && t.Content.Reader == nil {
+	if len(t.Content.Data) == 0 && t.Content.SourcePath == "" && t.Content.Reader == nil && t.Content.FS == nil {
 		return fmt.Errorf("file content empty")
 	}
 	return nil
 }
 
+// Stream is ReaderAndSize for transfers that do not need the length upfront:
+// a non-seekable Reader without Size is returned as is with UnknownSize
+func (t *FileContent) Stream() (io.ReadCloser, int64, error) {
+	r, size, err := t.ReaderAndSize()
+	if errors.Is(err, utils.ErrUnknownSize) {
+		return io.NopCloser(t.Reader), UnknownSize, nil
+	}
+	return r, size, err
+}
+
 // ReaderAndSize yields an io.ReadCloser and its length based on which
-// content field is set: Data, SourcePath, or Reader
+// content field is set: Data, SourcePath, FS, or Reader
 func (t *FileContent) ReaderAndSize() (io.ReadCloser, int64, error) {
 	switch {
 	case t == nil:
@@ -74,6 +163,23 @@ func (t *FileContent) ReaderAndSize() (io.ReadCloser, int64, error) {
 			return nil, 0, fmt.Errorf("stat source file: %w", err)
 		}
 		return f, info.Size(), nil
+	case t.FS != nil:
+		f, err := t.FS.Open(t.FSPath)
+		if err != nil {
+			return nil, 0, fmt.Errorf("open fs file: %w", err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			if err := f.Close(); err != nil {
+				return nil, 0, fmt.Errorf("close fs file: %w", err)
+			}
+			return nil, 0, fmt.Errorf("stat fs file: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			f.Close()
+			return nil, 0, fmt.Errorf("fs file %s is not a regular file", t.FSPath)
+		}
+		return f, info.Size(), nil
 	case t.Reader != nil:
 		if s, ok := t.Reader.(io.Seeker); ok {
 			cur, err := s.Seek(0, io.SeekCurrent)
@@ -86,9 +192,12 @@ func (t *FileContent) ReaderAndSize() (io.ReadCloser, int64, error) {
 			}
 			_, err = s.Seek(cur, io.SeekStart)
 			return io.NopCloser(t.Reader), end - cur, err
-		} else {
-			return nil, 0, fmt.Errorf("reader is not seekable")
 		}
+		if t.Size > 0 || (t.SizeKnown && t.Size == 0) {
+			return io.NopCloser(t.Reader), t.Size, nil
+		}
+		return nil, 0, fmt.Errorf("%w: reader is not seekable and Size is not set "+
+			"(SFTP and local transfers stream it; SCP needs Size or ssh.WithSpool)", utils.ErrUnknownSize)
 	default:
 		return nil, 0, fmt.Errorf("no file content provided")
 	}
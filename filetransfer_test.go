@@ -4,11 +4,16 @@ package rexec
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ngrsoftlab/rexec/utils"
 )
 
 func TestFileSpec_Validate(t *testing.T) {
@@ -23,6 +28,7 @@ func TestFileSpec_Validate(t *testing.T) {
 		{name: "no_content", spec: &FileSpec{Filename: "file", TargetDir: "dir"}, wantErr: "file content required"},
 		{name: "empty_content", spec: &FileSpec{Filename: "file", TargetDir: "dir", Content: &FileContent{}}, wantErr: "file content empty"},
 		{name: "valid", spec: &FileSpec{Filename: "file", TargetDir: "dir", Content: &FileContent{Data: []byte{1, 2, 3}}}, wantErr: ""},
+		{name: "valid_fs", spec: &FileSpec{Filename: "file", TargetDir: "dir", Content: &FileContent{FS: fstest.MapFS{}, FSPath: "a"}}, wantErr: ""},
 	}
 
 	for _, tc := range tests {
@@ -58,8 +64,13 @@ func TestFileContent_ReaderAndSize(t *testing.T) {
 		{"source_path", "sourcepath", []byte("abc"), 3, false, ""},
 		{"seekable_reader", "readerSeek", []byte("seekable"), 8, false, ""},
 		{"nonseekable_reader", "readerNoSeek", []byte("x"), 0, true, "reader is not seekable"},
+		{"nonseekable_sized", "readerSized", []byte("sized"), 5, false, ""},
+		{"nonseekable_empty", "readerEmpty", []byte{}, 0, false, ""},
 		{"nil_content", "nil", nil, 0, true, "no file content provided"},
 		{"open_error", "statError", nil, 0, true, "open source file"},
+		{"fs", "fs", []byte("embedded"), 8, false, ""},
+		{"fs_missing", "fsMissing", nil, 0, true, "open fs file"},
+		{"fs_dir", "fsDir", nil, 0, true, "not a regular file"},
 	}
 
 	for _, tc := range tests {
@@ -85,10 +96,23 @@ func TestFileContent_ReaderAndSize(t *testing.T) {
 			case "readerNoSeek":
 				r := noSeek{Reader: bytes.NewReader(tc.inputData)}
 				content = &FileContent{Reader: r}
+			case "readerSized":
+				content = &FileContent{Reader: noSeek{Reader: bytes.NewReader(tc.inputData)}, Size: int64(len(tc.inputData))}
+				expectedData = tc.inputData
+			case "readerEmpty":
+				content = &FileContent{Reader: noSeek{Reader: bytes.NewReader(tc.inputData)}, SizeKnown: true}
+				expectedData = tc.inputData
 			case "nil":
 				content = nil
 			case "statError":
 				content = &FileContent{SourcePath: "/nonexistent"}
+			case "fs":
+				content = &FileContent{FS: fstest.MapFS{"scripts/a.sh": {Data: tc.inputData}}, FSPath: "scripts/a.sh"}
+				expectedData = tc.inputData
+			case "fsMissing":
+				content = &FileContent{FS: fstest.MapFS{}, FSPath: "nope"}
+			case "fsDir":
+				content = &FileContent{FS: fstest.MapFS{"scripts/a.sh": {}}, FSPath: "scripts"}
 			}
 
 			r, size, err := content.ReaderAndSize()
@@ -120,3 +144,86 @@ func TestFileContent_ReaderAndSize(t *testing.T) {
 		})
 	}
 }
+
+func TestFileContent_Stream(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  *FileContent
+		wantSize int64
+		wantErr  bool
+	}{
+		{"data", &FileContent{Data: []byte("abc")}, 3, false},
+		{"seekable", &FileContent{Reader: strings.NewReader("abcd")}, 4, false},
+		{"sized", &FileContent{Reader: noSeek{Reader: strings.NewReader("ab")}, Size: 2}, 2, false},
+		{"unknown", &FileContent{Reader: noSeek{Reader: strings.NewReader("stream")}}, UnknownSize, false},
+		{"known_empty", &FileContent{Reader: noSeek{Reader: strings.NewReader("")}, SizeKnown: true}, 0, false},
+		{"nil", nil, 0, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, size, err := tc.content.Stream()
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil || size != tc.wantSize {
+				t.Fatalf("Stream = %d, %v; want %d, nil", size, err, tc.wantSize)
+			}
+			r.Close()
+		})
+	}
+
+	_, _, err := (&FileContent{Reader: noSeek{Reader: strings.NewReader("x")}}).ReaderAndSize()
+	if !errors.Is(err, utils.ErrUnknownSize) || !strings.Contains(err.Error(), "SCP needs Size") {
+		t.Errorf("ReaderAndSize err = %v; want ErrUnknownSize naming the SCP requirement", err)
+	}
+}
+
+func TestFileSpec_Times(t *testing.T) {
+	srcTime := time.Unix(1500000000, 0)
+	mtime, atime := time.Unix(1600000000, 0), time.Unix(1600000100, 0)
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.WriteFile(src, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(src, srcTime, srcTime); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{"f": {Data: []byte("x"), ModTime: srcTime}}
+
+	tests := []struct {
+		name      string
+		spec      *FileSpec
+		wantMtime time.Time
+		wantAtime time.Time
+		wantErr   bool
+	}{
+		{"unset", &FileSpec{Content: &FileContent{SourcePath: src}}, time.Time{}, time.Time{}, false},
+		{"mod_time", &FileSpec{ModTime: mtime, Content: &FileContent{Data: []byte("x")}}, mtime, mtime, false},
+		{"both_times", &FileSpec{ModTime: mtime, AccessTime: atime, Content: &FileContent{Data: []byte("x")}}, mtime, atime, false},
+		{"preserve_source_path", &FileSpec{PreserveTimes: true, Content: &FileContent{SourcePath: src}}, srcTime, srcTime, false},
+		{"preserve_fs", &FileSpec{PreserveTimes: true, Content: &FileContent{FS: fsys, FSPath: "f"}}, srcTime, srcTime, false},
+		{"preserve_data", &FileSpec{PreserveTimes: true, Content: &FileContent{Data: []byte("x")}}, time.Time{}, time.Time{}, false},
+		{"mod_time_wins", &FileSpec{PreserveTimes: true, ModTime: mtime, Content: &FileContent{SourcePath: src}}, mtime, mtime, false},
+		{"missing_source", &FileSpec{PreserveTimes: true, Content: &FileContent{SourcePath: src + ".missing"}}, time.Time{}, time.Time{}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gotMtime, gotAtime, err := tc.spec.Times()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v; want error %v", err, tc.wantErr)
+			}
+			if !gotMtime.Equal(tc.wantMtime) || !gotAtime.Equal(tc.wantAtime) {
+				t.Errorf("Times = %v, %v; want %v, %v", gotMtime, gotAtime, tc.wantMtime, tc.wantAtime)
+			}
+		})
+	}
+
+	if got := BackupName("app.conf", time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)); got != "app.conf.bak.20250102T150405Z" {
+		t.Errorf("BackupName = %q", got)
+	}
+}
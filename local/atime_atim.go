@@ -0,0 +1,20 @@
+// Copyright © NGRSoftlab 2020-2025
+
+//go:build linux || openbsd || dragonfly || solaris
+
+package local
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// fileAtime returns the access time of the file described by info, or its modification time if unknown
+func fileAtime(info fs.FileInfo) time.Time {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(st.Atim.Unix())
+}
@@ -0,0 +1,15 @@
+// Copyright © NGRSoftlab 2020-2025
+
+//go:build !(linux || openbsd || dragonfly || solaris || darwin || freebsd || netbsd)
+
+package local
+
+import (
+	"io/fs"
+	"time"
+)
+
+// fileAtime returns the modification time where the access time cannot be read
+func fileAtime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
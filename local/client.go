@@ -3,14 +3,16 @@
 package local
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
-	"runtime/debug"
-	"strings"
+	"os/user"
+	"slices"
 	"time"
 
 	"github.com/ngrsoftlab/rexec"
@@ -26,6 +28,8 @@ var _ rexec.Client[RunOption] = (*Client)(nil)
 type Client struct {
 	cfg    *Config               // execution settings (workdir, env, etc.)
 	mapper *utils.ExitCodeMapper // maps exit codes to user-friendly messages
+	host   string                // local hostname recorded in results
+	user   string                // current user recorded in results
 }
 
 // NewClient returns a Client using cfg, or defaults if cfg is nil
@@ -33,30 +37,51 @@ func NewClient(cfg *Config) *Client {
 	if cfg == nil {
 		cfg = NewConfig()
 	}
-	return &Client{cfg: cfg, mapper: utils.NewDefaultExitCodeMapper()}
+	return &Client{
+		cfg:    cfg,
+		mapper: utils.NewDefaultExitCodeMapper(),
+		host:   localHostname(),
+		user:   localUsername(),
+	}
 }
 
 // Run executes cmd, captures stdout/stderr and duration,
 // then applies cmd.Parser to dst if provided
-func (cl *Client) Run(ctx context.Context, cmd *command.Command, dst any, opts ...RunOption) (*parser.RawResult, error) {
-	var err error
-	result := parser.NewRawResult(cmd)
+func (cl *Client) Run(ctx context.Context, cmd *command.Command, dst any, opts ...RunOption) (result *parser.RawResult, err error) {
+	result = parser.NewRawResult(cmd)
+
+	ctx, finishSpan := cl.cfg.Telemetry.StartRun(ctx, "local.run", cmd, cl.host, cl.cfg.LogRedactors...)
+	defer func() {
+		finishSpan(result, err)
+		rexec.LogRun(ctx, cl.cfg.log(), cmd, result, err, cl.cfg.LogRedactors)
+	}()
 
+	var runCfg *localRunConfig
 	defer func() {
-		if r := recover(); r != nil {
-			result.Err = fmt.Errorf("recovered from panic on run: %v\n%s", r, debug.Stack())
-			result.ExitCode = -1
-			err = result.Err
+		if runCfg != nil && runCfg.events != nil {
+			rexec.EmitExit(ctx, runCfg.events, result, err)
 		}
 	}()
 
-	runCfg := newRunConfig(cl.cfg.WorkDir, cl.cfg.EnvVars, opts...)
+	defer rexec.RecoverRun(result, &err)
+
+	runCfg = newRunConfig(cl.cfg.WorkDir, cl.cfg.EnvVars,
+		append([]RunOption{WithRunEnvMode(cl.cfg.EnvMode)}, opts...)...)
+	cl.describeRun(result, runCfg)
 
 	if validateErr := cl.cfg.Validate(); validateErr != nil {
 		return result, fmt.Errorf("config is invalid: %w", validateErr)
 	}
+	if envErr := rexec.ValidateEnv(runCfg.envVars); envErr != nil {
+		return result, envErr
+	}
 
 	execCmd := cl.prepareCommandContext(ctx, cmd, runCfg)
+	cl.cfg.log().Debug("command started",
+		slog.String("host", cl.host),
+		slog.String("cmd", rexec.RedactString(cmd.String(), cl.cfg.LogRedactors)),
+		slog.String("workdir", result.WorkDir),
+	)
 
 	if runCaptureErr := cl.runAndCapture(ctx, runCfg, execCmd, result); runCaptureErr != nil {
 		return result, runCaptureErr
@@ -74,15 +99,19 @@ func (cl *Client) Close() error {
 	return nil
 }
 
-// prepareCommandContext builds an exec.Cmd for “sh -c <cmd.String()>”, setting working directory and environment from cfg.
+// prepareCommandContext builds an exec.Cmd for “sh -c <cmd.String()>”, setting working directory and environment from cfg
 func (cl *Client) prepareCommandContext(ctx context.Context, cmd *command.Command, cfg *localRunConfig) *exec.Cmd {
 	execCmd := exec.CommandContext(ctx, "sh", "-c", cmd.String())
 	execCmd.Dir = cfg.dir
 
-	// merge os environment with cfg.envVars
-	env := os.Environ()
-	for k, v := range cfg.envVars {
-		env = append(env, fmt.Sprintf("%s=%q", k, v))
+	// start from the process environment unless it is cleared; later entries win.
+	// Env must stay non-nil: a nil Env makes exec inherit the environment
+	env := []string{}
+	if cfg.envMode == rexec.EnvInherit {
+		env = os.Environ()
+	}
+	for _, k := range slices.Sorted(maps.Keys(cfg.envVars)) {
+		env = append(env, k+"="+cfg.envVars[k])
 	}
 	execCmd.Env = env
 
@@ -91,31 +120,51 @@ func (cl *Client) prepareCommandContext(ctx context.Context, cmd *command.Comman
 
 // runAndCapture runs c.Run(), records duration, fills rawResult.Stdout, rawResult.Stderr and ExitCode
 func (cl *Client) runAndCapture(ctx context.Context, cfg *localRunConfig, c *exec.Cmd, rawResult *parser.RawResult) error {
-	var outBuf, errBuf bytes.Buffer
+	outBuf := rexec.NewCaptureBuffer(cfg.outputLimit)
+	errBuf := rexec.NewCaptureBuffer(cfg.outputLimit)
+	errTail := rexec.NewRingBuffer(rexec.ErrTailSize)
 
 	stdout := cfg.stdout
 	if stdout == nil {
-		stdout = &outBuf
+		stdout = outBuf
 	}
 
 	stderr := cfg.stderr
 	if stderr == nil {
-		stderr = &errBuf
+		stderr = errBuf
+	}
+	stderr = io.MultiWriter(stderr, errTail)
+
+	if cfg.events != nil {
+		outLines := rexec.NewLineWriter(ctx, rexec.StreamStdout, cfg.events)
+		errLines := rexec.NewLineWriter(ctx, rexec.StreamStderr, cfg.events)
+		defer outLines.Flush()
+		defer errLines.Flush()
+		stdout = io.MultiWriter(stdout, outLines)
+		stderr = io.MultiWriter(stderr, errLines)
 	}
 
 	c.Stdout, c.Stderr = stdout, stderr
 
-	start := time.Now()
+	rawResult.MarkStarted()
 	runErr := c.Run()
-	rawResult.Duration = time.Since(start)
+	rawResult.MarkFinished()
 
+	captureErr := errors.Join(outBuf.Close(), errBuf.Close())
 	if cfg.stdout == nil {
-		rawResult.Stdout = outBuf.String()
+		rawResult.StdoutBytes = outBuf.Bytes()
+		rawResult.Stdout = string(rawResult.StdoutBytes)
+		rawResult.StdoutTotal = outBuf.Total()
+		rawResult.StdoutSpill = outBuf.SpillPath()
 	}
 
 	if cfg.stderr == nil {
-		rawResult.Stderr = errBuf.String()
+		rawResult.StderrBytes = errBuf.Bytes()
+		rawResult.Stderr = string(rawResult.StderrBytes)
+		rawResult.StderrTotal = errBuf.Total()
+		rawResult.StderrSpill = errBuf.SpillPath()
 	}
+	rawResult.Truncated = outBuf.Truncated() || errBuf.Truncated()
 
 	if ctxErr := ctx.Err(); ctxErr != nil {
 		err := fmt.Errorf(
@@ -135,20 +184,46 @@ func (cl *Client) runAndCapture(ctx context.Context, cfg *localRunConfig, c *exe
 			code = exitErr.ExitCode()
 		}
 		msg := cl.mapper.Lookup(code)
-		stderrText := strings.TrimSpace(rawResult.Stderr)
-		if len(stderrText) > 200 {
-			stderrText = stderrText[:200] + "..."
-		}
-		err := fmt.Errorf("command failed (%s): %s: %w", msg, stderrText, runErr)
+		err := fmt.Errorf("command failed (%s): %s: %w", msg, errTail.Summary(), runErr)
 		rawResult.ExitCode = code
 		rawResult.Err = err
 		return err
 	}
 
+	if captureErr != nil {
+		err := fmt.Errorf("capture output: %w", captureErr)
+		rawResult.Err = err
+		return err
+	}
+
 	rawResult.ExitCode = 0
 	return nil
 }
 
+// describeRun records where and how the command runs in result
+func (cl *Client) describeRun(result *parser.RawResult, cfg *localRunConfig) {
+	result.Host = cl.host
+	result.User = cl.user
+	result.WorkDir = cfg.dir
+	result.EnvKeys = slices.Sorted(maps.Keys(cfg.envVars))
+}
+
+// localHostname returns the machine hostname, or "localhost" if it is unknown
+func localHostname() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "localhost"
+}
+
+// localUsername returns the name of the current user, falling back to $USER
+func localUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
 // applyParser invokes cmd.Parser.Parse on result into dst if both are set
 func (cl *Client) applyParser(result *parser.RawResult, cmd *command.Command, dst any) error {
 	if cmd.Parser != nil && dst != nil {
@@ -1,15 +1,21 @@
 package local
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"log/slog"
 	"os/exec"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/ngrsoftlab/rexec"
 	"github.com/ngrsoftlab/rexec/command"
 	"github.com/ngrsoftlab/rexec/parser"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestNewClientAndClose(t *testing.T) {
@@ -118,6 +124,35 @@ func TestRunAndCapture(t *testing.T) {
 	}
 }
 
+func TestRunErrorStderr(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH, skipping")
+	}
+
+	tests := []struct {
+		name    string
+		cmd     string
+		want    string
+		notWant string
+	}{
+		{"short", "echo '  no such file  ' >&2; exit 2", "): no such file: exit status 2", ""},
+		{"long", "echo head >&2; head -c 2000 /dev/zero | tr '\\0' a >&2; echo tail >&2; exit 1", ": ..." + strings.Repeat("a", 100), "head"},
+		{"long_keeps_last_line", "head -c 2000 /dev/zero | tr '\\0' a >&2; echo >&2; echo real error >&2; exit 1", "a\nreal error: exit status 1", ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewClient(nil).Run(context.Background(), command.New(tc.cmd), nil)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v; want it to contain %q", err, tc.want)
+			}
+			if tc.notWant != "" && strings.Contains(err.Error(), tc.notWant) {
+				t.Errorf("err = %v; want no %q", err, tc.notWant)
+			}
+		})
+	}
+}
+
 type nopParser struct{}
 
 func (nopParser) Parse(raw *parser.RawResult, dst any) error { return nil }
@@ -153,3 +188,215 @@ func TestApplyParser(t *testing.T) {
 		})
 	}
 }
+
+func TestRunWithEvents(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH, skipping")
+	}
+
+	cl := NewClient(nil)
+	cmd := command.New("printf 'one\\ntwo\\n'; printf 'oops' >&2; exit 3")
+
+	var stdout, stderr []string
+	var exit *rexec.StreamEvent
+	for ev := range rexec.Stream(context.Background(), cl, cmd, WithEvents) {
+		switch ev.Stream {
+		case rexec.StreamStdout:
+			stdout = append(stdout, ev.Text())
+		case rexec.StreamStderr:
+			stderr = append(stderr, ev.Text())
+		case rexec.StreamExit:
+			exit = &ev
+		}
+	}
+
+	if !reflect.DeepEqual(stdout, []string{"one", "two"}) {
+		t.Errorf("stdout lines = %q; want [one two]", stdout)
+	}
+	if !reflect.DeepEqual(stderr, []string{"oops"}) {
+		t.Errorf("stderr lines = %q; want [oops]", stderr)
+	}
+	if exit == nil {
+		t.Fatal("no exit event received")
+	}
+	if exit.ExitCode != 3 || exit.Err == nil {
+		t.Errorf("exit event = code %d, err %v; want code 3 with error", exit.ExitCode, exit.Err)
+	}
+}
+
+func TestRunBinaryOutput(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH, skipping")
+	}
+
+	cl := NewClient(nil)
+	rr, err := cl.Run(context.Background(), command.New(`printf 'a\r\n\000\377b'`), nil)
+	if err != nil {
+		t.Fatalf("Run error = %v", err)
+	}
+	want := []byte{'a', '\r', '\n', 0x00, 0xff, 'b'}
+	if !bytes.Equal(rr.StdoutBytes, want) {
+		t.Errorf("StdoutBytes = %q; want %q", rr.StdoutBytes, want)
+	}
+	if rr.Stdout != string(want) {
+		t.Errorf("Stdout = %q; want %q", rr.Stdout, want)
+	}
+}
+
+func TestRunOutputLimit(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH, skipping")
+	}
+
+	cl := NewClient(nil)
+	cmd := command.New("printf '0123456789'; printf 'abcdefghij' >&2")
+	rr, err := cl.Run(context.Background(), cmd, nil,
+		WithOutputLimit(rexec.OutputLimit{MaxBytes: 4, Policy: rexec.TruncateHead}))
+	if err != nil {
+		t.Fatalf("Run error = %v", err)
+	}
+	if rr.Stdout != "6789" || rr.Stderr != "ghij" {
+		t.Errorf("Stdout, Stderr = %q, %q; want %q, %q", rr.Stdout, rr.Stderr, "6789", "ghij")
+	}
+	if !rr.Truncated || rr.StdoutTotal != 10 || rr.StderrTotal != 10 {
+		t.Errorf("Truncated = %v, totals = %d/%d; want true, 10/10", rr.Truncated, rr.StdoutTotal, rr.StderrTotal)
+	}
+}
+
+func TestRunMetadata(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH, skipping")
+	}
+
+	dir := t.TempDir()
+	cl := NewClient(NewConfig().WithEnvVars(map[string]string{"B": "2"}))
+	rr, err := cl.Run(context.Background(), command.New("true"), nil, WithWorkdir(dir), WithEnvVar("A", "1"))
+	if err != nil {
+		t.Fatalf("Run error = %v", err)
+	}
+
+	if rr.StartedAt.IsZero() || rr.FinishedAt.Before(rr.StartedAt) {
+		t.Errorf("StartedAt = %v, FinishedAt = %v; want ordered non-zero times", rr.StartedAt, rr.FinishedAt)
+	}
+	if rr.Duration != rr.FinishedAt.Sub(rr.StartedAt) {
+		t.Errorf("Duration = %v; want FinishedAt-StartedAt", rr.Duration)
+	}
+	if rr.Host == "" {
+		t.Error("Host is empty")
+	}
+	if rr.WorkDir != dir {
+		t.Errorf("WorkDir = %q; want %q", rr.WorkDir, dir)
+	}
+	if !reflect.DeepEqual(rr.EnvKeys, []string{"A", "B"}) {
+		t.Errorf("EnvKeys = %v; want [A B]", rr.EnvKeys)
+	}
+	if rr.PTY || rr.Attempt != 1 {
+		t.Errorf("PTY = %v, Attempt = %d; want false, 1", rr.PTY, rr.Attempt)
+	}
+
+	rr, err = cl.Run(context.Background(), command.New("true"), nil)
+	if err != nil {
+		t.Fatalf("Run error = %v", err)
+	}
+	if rr.WorkDir != "" {
+		t.Errorf("WorkDir without workdir = %q; want empty", rr.WorkDir)
+	}
+}
+
+// interface guard: DryRunClient can stand in for the local client
+var _ rexec.Client[RunOption] = rexec.NewDryRunClient[RunOption]("localhost")
+
+func TestRunTelemetry(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH, skipping")
+	}
+
+	spans := tracetest.NewSpanRecorder()
+	tel, err := rexec.NewTelemetry(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig().WithTelemetry(tel)
+	cfg.LogRedactors = []parser.Redactor{parser.RedactValues("hunter2")}
+	cl := NewClient(cfg)
+	_, _ = cl.Run(context.Background(), command.New("echo hunter2 >/dev/null; exit 3"), nil)
+
+	ended := spans.Ended()
+	if len(ended) != 1 || ended[0].Name() != "local.run" {
+		t.Fatalf("spans = %v; want one local.run span", ended)
+	}
+	for _, kv := range ended[0].Attributes() {
+		if kv.Key == rexec.AttrExitCode && kv.Value.AsInt64() != 3 {
+			t.Errorf("exit code attr = %d; want 3", kv.Value.AsInt64())
+		}
+		if kv.Key == rexec.AttrCommand && kv.Value.AsString() != "echo *** >/dev/null; exit 3" {
+			t.Errorf("command attr = %q; want the secret masked", kv.Value.AsString())
+		}
+	}
+	if ended[0].Status().Code != codes.Error {
+		t.Errorf("status = %v; want error", ended[0].Status())
+	}
+}
+
+func TestRunLogging(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH, skipping")
+	}
+
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	cl := NewClient(NewConfig().
+		WithEnvVars(map[string]string{"TOKEN": "env-secret"}).
+		WithLogger(logger, parser.RedactValues("hunter2")))
+
+	_, _ = cl.Run(context.Background(), command.New("echo hunter2; exit 4"), nil)
+
+	out := logBuf.String()
+	for _, want := range []string{`msg="command started"`, `msg="command failed"`, "level=WARN", "exit_code=4", `cmd="echo ***; exit 4"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log = %q; want containing %q", out, want)
+		}
+	}
+	for _, secret := range []string{"hunter2", "env-secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log leaks %q: %q", secret, out)
+		}
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH, skipping")
+	}
+	t.Setenv("REXEC_INHERITED", "parent")
+	tricky := "$HOME `id` \"q\" it's \\n"
+
+	tests := []struct {
+		name string
+		cfg  *Config
+		opts []RunOption
+		want string
+	}{
+		{"inherit", NewConfig(), []RunOption{WithEnvVar("V", tricky)}, "parent|" + tricky},
+		{"clear_config", NewConfig().WithEnvMode(rexec.EnvClear), []RunOption{WithEnvVar("V", tricky)}, "|" + tricky},
+		{"clear_run", NewConfig(), []RunOption{WithRunEnvMode(rexec.EnvClear)}, "|"},
+		{"override_inherited", NewConfig().WithEnvVars(map[string]string{"REXEC_INHERITED": "child"}), nil, "child|"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr, err := NewClient(tc.cfg).Run(context.Background(),
+				command.New(`printf '%%s|%%s' "$REXEC_INHERITED" "$V"`), nil, tc.opts...)
+			if err != nil {
+				t.Fatalf("Run error = %v", err)
+			}
+			if rr.Stdout != tc.want {
+				t.Errorf("stdout = %q; want %q", rr.Stdout, tc.want)
+			}
+		})
+	}
+
+	if _, err := NewClient(nil).Run(context.Background(), command.New("true"), nil, WithEnvVar("A B", "x")); err == nil {
+		t.Error("expected error for an invalid variable name")
+	}
+}
@@ -4,13 +4,22 @@ package local
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/utils"
 )
 
 // Config holds settings for running commands locally
 type Config struct {
-	WorkDir string            // directory in which to execute commands
-	EnvVars map[string]string // additional environment variables to set
+	WorkDir      string            // directory in which to execute commands
+	EnvVars      map[string]string // additional environment variables to set
+	EnvMode      rexec.EnvMode     // inherit (default) or clear the process environment
+	Telemetry    *rexec.Telemetry  // optional: OpenTelemetry spans and metrics for runs
+	Logger       *slog.Logger      // optional: structured logger for runs
+	LogRedactors []parser.Redactor // optional: mask secrets in logged and traced command lines
 }
 
 // NewConfig creates a Config with defaults (no workdir, empty env)
@@ -37,6 +46,35 @@ func (lc *Config) WithEnvVars(env map[string]string) *Config {
 	return lc
 }
 
+// WithEnvMode selects whether commands inherit the process environment
+// (rexec.EnvInherit, default) or start with only the configured variables (rexec.EnvClear)
+func (lc *Config) WithEnvMode(mode rexec.EnvMode) *Config {
+	lc.EnvMode = mode
+	return lc
+}
+
+// WithTelemetry enables OpenTelemetry spans and metrics for runs
+func (lc *Config) WithTelemetry(t *rexec.Telemetry) *Config {
+	lc.Telemetry = t
+	return lc
+}
+
+// WithLogger enables structured logging of runs; redactors mask secrets in command lines,
+// in logs and in spans. Environment values are never logged
+func (lc *Config) WithLogger(logger *slog.Logger, redactors ...parser.Redactor) *Config {
+	lc.Logger = logger
+	lc.LogRedactors = redactors
+	return lc
+}
+
+// log returns Logger or one that discards everything
+func (lc *Config) log() *slog.Logger {
+	if lc.Logger == nil {
+		return utils.NopLogger()
+	}
+	return lc.Logger
+}
+
 // Validate checks that WorkDir exists and is a directory
 func (lc *Config) Validate() error {
 	if lc.WorkDir == "" {
@@ -4,10 +4,14 @@ package local
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/ngrsoftlab/rexec"
 )
@@ -22,17 +26,118 @@ func NewTransfer() *Transfer {
 
 // Copy validates spec and writes the file locally.
 func (lt *Transfer) Copy(ctx context.Context, spec *rexec.FileSpec) error {
+	_, err := lt.CopyChecked(ctx, spec)
+	return err
+}
+
+// CopyChecked is Copy that reports whether the file changed. With spec.SkipUnchanged
+// an existing file with the same SHA-256 is left alone; with spec.Verify the written
+// file is hashed again and compared. With spec.Backup an existing file is first copied
+// to rexec.BackupName, keeping its mode and modification time
+func (lt *Transfer) CopyChecked(ctx context.Context, spec *rexec.FileSpec) (*rexec.CopyResult, error) {
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return nil, ctx.Err()
 	default:
 	}
 
 	if err := lt.validate(spec); err != nil {
+		return nil, err
+	}
+
+	fullPath := filepath.Join(spec.TargetDir, spec.Filename)
+	res := &rexec.CopyResult{Changed: true}
+	if spec.NeedsChecksum() {
+		sum, err := spec.Content.SHA256()
+		if err != nil {
+			return nil, err
+		}
+		res.Checksum = sum
+	}
+	if spec.SkipUnchanged {
+		current, err := fileChecksum(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		if current == res.Checksum {
+			res.Changed = false
+			return res, nil
+		}
+	}
+
+	if spec.Backup {
+		if err := backupFile(fullPath, filepath.Join(spec.TargetDir, rexec.BackupName(spec.Filename, time.Now()))); err != nil {
+			return nil, err
+		}
+	}
+
+	n, err := lt.writeFile(spec)
+	res.Bytes = n
+	if err != nil {
+		return res, err
+	}
+
+	if spec.Verify {
+		written, err := fileChecksum(fullPath)
+		if err != nil {
+			return res, err
+		}
+		if err := rexec.VerifyChecksum(fullPath, res.Checksum, written); err != nil {
+			return res, err
+		}
+		res.Verified = true
+	}
+	return res, nil
+}
+
+// fileChecksum returns the hex SHA-256 of the file at path, or "" if it does not exist
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("open target file: %w", err)
+	}
+	defer f.Close()
+
+	sum, err := rexec.SumSHA256(f)
+	if err != nil {
+		return "", fmt.Errorf("checksum target file: %w", err)
+	}
+	return sum, nil
+}
+
+// Fetch copies the local file spec.RemotePath into the destination of spec,
+// keeping its mode and, with PreserveTimes, its modification time
+func (lt *Transfer) Fetch(ctx context.Context, spec *rexec.FetchSpec) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := spec.Validate(); err != nil {
 		return err
 	}
 
-	return lt.writeFile(spec)
+	src, err := os.Open(spec.RemotePath)
+	if err != nil {
+		return fmt.Errorf("open source file: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat source file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("fetch %q: not a regular file", spec.RemotePath)
+	}
+
+	if _, err := spec.Write(info.Mode(), info.ModTime(), time.Time{}, func(w io.Writer) (int64, error) {
+		return io.Copy(w, src)
+	}); err != nil {
+		return fmt.Errorf("fetch %q: %w", spec.RemotePath, err)
+	}
+	return nil
 }
 
 // createDirectory ensures that the given path exists, creating any necessary parent directories with the specified mode
@@ -43,28 +148,176 @@ func (lt *Transfer) createDirectory(path string, mode os.FileMode) error {
 	return nil
 }
 
-// writeFile writes spec.Content to TargetDir/Filename, creating parent directories and applying file and folder modes
-func (lt *Transfer) writeFile(spec *rexec.FileSpec) error {
+// writeFile writes spec.Content to TargetDir/Filename, creating parent directories and applying file and folder modes.
+// With spec.Atomic the data goes to a hidden temp file that is synced, chmodded and renamed over the target
+// It returns the number of bytes written
+func (lt *Transfer) writeFile(spec *rexec.FileSpec) (int64, error) {
 	fullPath := filepath.Join(spec.TargetDir, spec.Filename)
 	parentDir := filepath.Dir(fullPath)
 
 	if err := lt.createDirectory(parentDir, spec.FolderMode); err != nil {
-		return err
+		return 0, err
 	}
 
-	reader, _, err := spec.Content.ReaderAndSize()
+	reader, _, err := spec.Content.Stream()
+	if err != nil {
+		return 0, fmt.Errorf("read source data: %w", err)
+	}
+	defer reader.Close()
+
+	if spec.Atomic {
+		return lt.writeAtomic(fullPath, reader, spec)
+	}
 
 	outFile, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, spec.Mode)
 	if err != nil {
-		return fmt.Errorf("create target file: %w", err)
+		return 0, fmt.Errorf("create target file: %w", err)
 	}
 	defer outFile.Close()
 
-	if _, err := io.Copy(outFile, reader); err != nil {
-		return fmt.Errorf("copy content: %w", err)
+	n, err := io.Copy(outFile, reader)
+	if err != nil {
+		return n, fmt.Errorf("copy content: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return n, fmt.Errorf("close target file: %w", err)
+	}
+	return n, applyOwnerAndTimes(fullPath, spec)
+}
+
+// writeAtomic copies reader into a temp file next to fullPath, applies the mode, owner
+// and times of spec and renames it over fullPath; the temp file is removed if any step fails
+func (lt *Transfer) writeAtomic(fullPath string, reader io.Reader, spec *rexec.FileSpec) (n int64, err error) {
+	tmpPath := filepath.Join(filepath.Dir(fullPath), rexec.AtomicTempName(filepath.Base(fullPath)))
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			if rmErr := os.Remove(tmpPath); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+				err = errors.Join(err, fmt.Errorf("remove temp file: %w", rmErr))
+			}
+		}
+	}()
+
+	if n, err = io.Copy(tmp, reader); err != nil {
+		return n, fmt.Errorf("copy content: %w", err)
+	}
+	if err := tmp.Chmod(spec.Mode.Perm()); err != nil {
+		return n, fmt.Errorf("chmod temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return n, fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return n, fmt.Errorf("close temp file: %w", err)
+	}
+	if err := applyOwnerAndTimes(tmpPath, spec); err != nil {
+		return n, err
+	}
+	if err := os.Rename(tmpPath, fullPath); err != nil {
+		return n, fmt.Errorf("rename temp file: %w", err)
+	}
+	return n, nil
+}
+
+// applyOwnerAndTimes chowns path to spec.Owner and spec.Group and sets the times of spec
+func applyOwnerAndTimes(path string, spec *rexec.FileSpec) error {
+	if spec.Owner != "" || spec.Group != "" {
+		uid, gid, err := lookupIDs(spec.Owner, spec.Group)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("chown target file: %w", err)
+		}
+	}
+
+	mtime, atime, err := spec.Times()
+	if err != nil {
+		return err
+	}
+	if !mtime.IsZero() {
+		if err := os.Chtimes(path, atime, mtime); err != nil {
+			return fmt.Errorf("set target file times: %w", err)
+		}
 	}
 	return nil
+}
 
+// lookupIDs resolves a user and a group given by name or number; an empty one is -1 (unchanged)
+func lookupIDs(owner, group string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if owner != "" {
+		if uid, err = strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return 0, 0, fmt.Errorf("lookup owner: %w", err)
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return 0, 0, fmt.Errorf("lookup owner %s: uid %q is not numeric", owner, u.Uid)
+			}
+		}
+	}
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("lookup group: %w", err)
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("lookup group %s: gid %q is not numeric", group, g.Gid)
+			}
+		}
+	}
+	return uid, gid, nil
+}
+
+// backupFile copies the regular file at path to backup with the same mode, times and,
+// on Unix where the process may set it, owner; a missing path is not an error.
+// Where the access time cannot be read, the modification time is used for both
+func backupFile(path, backup string) error {
+	src, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open file to back up: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file to back up: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("back up %s: not a regular file", path)
+	}
+
+	dst, err := os.OpenFile(backup, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("create backup: %w", err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("close backup: %w", err)
+	}
+	if err := os.Chmod(backup, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("chmod backup: %w", err)
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		// like cp -p, keep the owner only where the process may set it
+		_ = os.Chown(backup, uid, gid)
+	}
+	if err := os.Chtimes(backup, fileAtime(info), info.ModTime()); err != nil {
+		return fmt.Errorf("set backup times: %w", err)
+	}
+	return nil
 }
 
 // validate checks that spec is valid and that TargetDir,
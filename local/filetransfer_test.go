@@ -5,9 +5,16 @@ package local
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/ngrsoftlab/rexec"
 )
@@ -81,3 +88,245 @@ func TestTransfer_Copy(t *testing.T) {
 		})
 	}
 }
+
+func TestTransfer_Fetch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(src, []byte("log line\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1600000000, 0)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tests := []struct {
+		name    string
+		spec    *rexec.FetchSpec
+		wantErr string
+	}{
+		{"writer", &rexec.FetchSpec{RemotePath: src, Writer: &buf}, ""},
+		{"local_path", &rexec.FetchSpec{RemotePath: src, LocalPath: filepath.Join(dir, "out", "app.log"), PreserveTimes: true}, ""},
+		{"missing", &rexec.FetchSpec{RemotePath: filepath.Join(dir, "nope"), Writer: &buf}, "open source file"},
+		{"directory", &rexec.FetchSpec{RemotePath: dir, Writer: &buf}, "not a regular file"},
+		{"invalid", &rexec.FetchSpec{RemotePath: src}, "exactly one of writer and local path"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			err := NewTransfer().Fetch(context.Background(), tc.spec)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("err = %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			if tc.spec.Writer != nil {
+				if buf.String() != "log line\n" {
+					t.Errorf("content = %q", buf.String())
+				}
+				return
+			}
+			info, err := os.Stat(tc.spec.LocalPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0o640 || !info.ModTime().Equal(mtime) {
+				t.Errorf("mode, mtime = %o, %v; want 640, %v", info.Mode().Perm(), info.ModTime(), mtime)
+			}
+		})
+	}
+}
+
+func TestTransfer_CopyFS(t *testing.T) {
+	src := fstest.MapFS{
+		"etc/app.conf":   {Data: []byte("port=80"), Mode: 0o444},
+		"etc/conf.d/x.c": {Data: []byte("x"), Mode: 0o444},
+	}
+	dst := t.TempDir()
+	specs, err := rexec.FileSpecsFromFS(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range specs {
+		if err := NewTransfer().Copy(context.Background(), spec); err != nil {
+			t.Fatalf("Copy(%s): %v", spec.Filename, err)
+		}
+	}
+	for name, want := range map[string]string{"etc/app.conf": "port=80", "etc/conf.d/x.c": "x"} {
+		data, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v; want %q", name, data, err, want)
+		}
+	}
+
+	missing := &rexec.FileSpec{TargetDir: dst, Filename: "m", Content: &rexec.FileContent{FS: src, FSPath: "nope"}}
+	if err := NewTransfer().Copy(context.Background(), missing); err == nil || !strings.Contains(err.Error(), "open fs file") {
+		t.Errorf("missing fs file err = %v; want open error", err)
+	}
+}
+
+// brokenReader yields data and then err
+type brokenReader struct {
+	data string
+	err  error
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestTransfer_CopyAtomic(t *testing.T) {
+	errBroken := errors.New("source broken")
+	tests := []struct {
+		name     string
+		content  *rexec.FileContent
+		wantErr  error
+		wantData string
+		wantMode os.FileMode
+	}{
+		{"replace", &rexec.FileContent{Data: []byte("new config")}, nil, "new config", 0o600},
+		{"failed_source", &rexec.FileContent{Reader: &brokenReader{data: "half", err: errBroken}}, errBroken, "old config", 0o644},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "app.conf")
+			if err := os.WriteFile(target, []byte("old config"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := NewTransfer().Copy(context.Background(), &rexec.FileSpec{TargetDir: dir, Filename: "app.conf",
+				Mode: 0o600, FolderMode: 0o755, Content: tc.content, Atomic: true})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v; want %v", err, tc.wantErr)
+			}
+
+			data, _ := os.ReadFile(target)
+			info, err := os.Stat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.wantData || info.Mode().Perm() != tc.wantMode {
+				t.Errorf("target = %q, %v; want %q with mode %o", data, info.Mode(), tc.wantData, tc.wantMode)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("temp files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestTransfer_CopyChecked(t *testing.T) {
+	dir := t.TempDir()
+	tr := NewTransfer()
+	steps := []struct {
+		name        string
+		data        string
+		wantChanged bool
+	}{
+		{"new_file", "v1", true},
+		{"unchanged", "v1", false},
+		{"changed", "v2", true},
+	}
+
+	for _, step := range steps {
+		res, err := tr.CopyChecked(context.Background(), &rexec.FileSpec{TargetDir: dir, Filename: "app.conf", Mode: 0o644,
+			Content: &rexec.FileContent{Data: []byte(step.data)}, SkipUnchanged: true, Verify: true})
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if res.Changed != step.wantChanged || res.Verified != step.wantChanged || len(res.Checksum) != 64 {
+			t.Errorf("%s: result = %+v; want changed and verified %v", step.name, res, step.wantChanged)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, "app.conf")); string(data) != step.data {
+			t.Errorf("%s: data = %q; want %q", step.name, data, step.data)
+		}
+	}
+}
+
+func TestTransfer_CopyAttrs(t *testing.T) {
+	me, err := user.Current()
+	if err != nil {
+		t.Skipf("current user: %v", err)
+	}
+	oldTime, oldATime := time.Unix(1500000000, 0), time.Unix(1500000100, 0)
+	mtime := time.Unix(1600000000, 0)
+
+	for _, atomic := range []bool{false, true} {
+		t.Run(fmt.Sprintf("atomic_%v", atomic), func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "app.conf")
+			if err := os.WriteFile(target, []byte("old"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(target, oldATime, oldTime); err != nil {
+				t.Fatal(err)
+			}
+			srcInfo, err := os.Stat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// platforms without a readable access time back up the modification time instead
+			wantATime := fileAtime(srcInfo)
+			if runtime.GOOS == "linux" && !wantATime.Equal(oldATime) {
+				t.Fatalf("fileAtime = %v; want %v", wantATime, oldATime)
+			}
+			wantUID, wantGID, hasOwner := fileOwner(srcInfo)
+			if hasOwner && os.Geteuid() == 0 {
+				if err := os.Chown(target, 65534, 65534); err != nil {
+					t.Fatal(err)
+				}
+				wantUID, wantGID = 65534, 65534
+			}
+
+			err = NewTransfer().Copy(context.Background(), &rexec.FileSpec{TargetDir: dir, Filename: "app.conf", Mode: 0o640,
+				Content: &rexec.FileContent{Data: []byte("new")}, Atomic: atomic,
+				Owner: me.Username, Group: me.Gid, ModTime: mtime, Backup: true})
+			if err != nil {
+				t.Fatalf("Copy: %v", err)
+			}
+
+			info, err := os.Stat(target)
+			if err != nil || !info.ModTime().Equal(mtime) {
+				t.Errorf("target = %v, %v; want mtime %v", info, err, mtime)
+			}
+			backups, _ := filepath.Glob(filepath.Join(dir, "app.conf.bak.*"))
+			if len(backups) != 1 {
+				t.Fatalf("backups = %v; want one", backups)
+			}
+			// stat before reading, which may update the access time
+			info, err = os.Stat(backups[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(backups[0])
+			if string(data) != "old" || info.Mode().Perm() != 0o600 || !info.ModTime().Equal(oldTime) {
+				t.Errorf("backup = %q, %v; want old content, mode 600 and the old mtime", data, info)
+			}
+			if got := fileAtime(info); !got.Equal(wantATime) {
+				t.Errorf("backup atime = %v; want %v", got, wantATime)
+			}
+			if uid, gid, ok := fileOwner(info); hasOwner && (!ok || uid != wantUID || gid != wantGID) {
+				t.Errorf("backup owner = %d:%d; want %d:%d", uid, gid, wantUID, wantGID)
+			}
+		})
+	}
+
+	err = NewTransfer().Copy(context.Background(), &rexec.FileSpec{TargetDir: t.TempDir(), Filename: "f", Mode: 0o644,
+		Content: &rexec.FileContent{Data: []byte("x")}, Owner: "no-such-user-rexec"})
+	if err == nil || !strings.Contains(err.Error(), "lookup owner") {
+		t.Errorf("unknown owner err = %v; want lookup error", err)
+	}
+}
@@ -4,6 +4,8 @@ package local
 
 import (
 	"io"
+
+	"github.com/ngrsoftlab/rexec"
 )
 
 // RunOption modifies settings for a single Run invocation
@@ -13,8 +15,12 @@ type RunOption func(*localRunConfig)
 type localRunConfig struct {
 	dir     string            // working directory for this run
 	envVars map[string]string // environment variables for this run
+	envMode rexec.EnvMode     // inherit or clear the process environment
 	stdout  io.Writer         // custom stdout writer (nil => buffer)
 	stderr  io.Writer         // custom stderr writer (nil => buffer)
+
+	outputLimit rexec.OutputLimit        // in-memory capture limit per stream
+	events      chan<- rexec.StreamEvent // optional: receives output lines and the exit event
 }
 
 // newRunConfig creates a localRunConfig from base settings and applies opts
@@ -48,6 +54,13 @@ func WithEnvVar(key, value string) RunOption {
 	}
 }
 
+// WithRunEnvMode overrides the environment mode of the Config for one Run
+func WithRunEnvMode(mode rexec.EnvMode) RunOption {
+	return func(rc *localRunConfig) {
+		rc.envMode = mode
+	}
+}
+
 // WithStdout directs live stdout to the given writer instead of buffering
 func WithStdout(stdout io.Writer) RunOption {
 	return func(rc *localRunConfig) {
@@ -61,3 +74,20 @@ func WithStderr(stderr io.Writer) RunOption {
 		rc.stderr = stderr
 	}
 }
+
+// WithEvents publishes every output line and a final exit event to ch. Every Run sends
+// exactly one exit event, also when it fails before the command starts; under rexec.Retry
+// that is one per attempt. Sends block until ch is read, so the command is slowed down by a slow consumer
+func WithEvents(ch chan<- rexec.StreamEvent) RunOption {
+	return func(rc *localRunConfig) {
+		rc.events = ch
+	}
+}
+
+// WithOutputLimit bounds the stdout and stderr bytes kept in memory, each stream separately.
+// Output beyond limit.MaxBytes is dropped or spilled to a temp file according to limit.Policy
+func WithOutputLimit(limit rexec.OutputLimit) RunOption {
+	return func(rc *localRunConfig) {
+		rc.outputLimit = limit
+	}
+}
@@ -0,0 +1,12 @@
+// Copyright © NGRSoftlab 2020-2025
+
+//go:build !unix
+
+package local
+
+import "io/fs"
+
+// fileOwner reports no owner where files have no Unix uid and gid
+func fileOwner(fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,259 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+// RunFunc has the signature of Client.Run
+type RunFunc[O any] func(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error)
+
+// Middleware wraps a RunFunc with additional behaviour (logging, retries, policies, ...)
+type Middleware[O any] func(next RunFunc[O]) RunFunc[O]
+
+// wrappedClient is a Client whose Run goes through a middleware chain
+type wrappedClient[O any] struct {
+	client Client[O]
+	run    RunFunc[O]
+}
+
+// Wrap returns a Client that passes every Run through mws before reaching client.
+// The first middleware is the outermost one. Close is forwarded to client
+func Wrap[O any](client Client[O], mws ...Middleware[O]) Client[O] {
+	run := RunFunc[O](func(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+		if client == nil {
+			return nil, utils.ErrClientNil
+		}
+		return client.Run(ctx, cmd, dst, opts...)
+	})
+	for i := len(mws) - 1; i >= 0; i-- {
+		run = mws[i](run)
+	}
+	return &wrappedClient[O]{client: client, run: run}
+}
+
+// Run executes cmd through the middleware chain
+func (c *wrappedClient[O]) Run(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+	return c.run(ctx, cmd, dst, opts...)
+}
+
+// Close closes the wrapped client
+func (c *wrappedClient[O]) Close() error {
+	if c.client == nil {
+		return utils.ErrClientNil
+	}
+	return c.client.Close()
+}
+
+// RecoverRun must be deferred directly by Client implementations: it turns a panic
+// during Run into an error stored in result and *err
+func RecoverRun(result *parser.RawResult, err *error) {
+	if r := recover(); r != nil {
+		*err = panicError(r)
+		result.Err = *err
+		result.ExitCode = -1
+	}
+}
+
+// panicError builds the error reported for a recovered panic
+func panicError(r any) error {
+	return fmt.Errorf("%w: %v\n%s", utils.ErrPanicRecovered, r, debug.Stack())
+}
+
+// Recover turns a panic anywhere below it in the chain into an error with exit code -1
+func Recover[O any]() Middleware[O] {
+	return func(next RunFunc[O]) RunFunc[O] {
+		return func(ctx context.Context, cmd *command.Command, dst any, opts ...O) (rr *parser.RawResult, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					if rr == nil {
+						rr = parser.NewRawResult(cmd)
+					}
+					err = panicError(r)
+					rr.Err = err
+					rr.ExitCode = -1
+				}
+			}()
+			return next(ctx, cmd, dst, opts...)
+		}
+	}
+}
+
+// Logging logs the outcome of every run to logger: Info on success, Error on failure.
+// Command lines and error text pass through redactors before being logged
+func Logging[O any](logger *slog.Logger, redactors ...parser.Redactor) Middleware[O] {
+	return func(next RunFunc[O]) RunFunc[O] {
+		return func(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+			rr, err := next(ctx, cmd, dst, opts...)
+
+			attrs := []slog.Attr{slog.String("cmd", redact(cmd, redactors))}
+			if rr != nil {
+				attrs = append(attrs,
+					slog.String("host", rr.Host),
+					slog.Int("exit_code", rr.ExitCode),
+					slog.Int("attempt", rr.Attempt),
+					slog.Duration("duration", rr.Duration),
+				)
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", RedactString(err.Error(), redactors)))
+				logger.LogAttrs(ctx, slog.LevelError, "command failed", attrs...)
+			} else {
+				logger.LogAttrs(ctx, slog.LevelInfo, "command finished", attrs...)
+			}
+			return rr, err
+		}
+	}
+}
+
+// LogRun logs the outcome of a run from inside a client: Debug on success, Warn on failure
+// and Error for a recovered panic. Command line and error text pass through redactors.
+// attrs are added after the common host, cmd, exit_code, attempt and duration attributes
+func LogRun(ctx context.Context, logger *slog.Logger, cmd *command.Command, rr *parser.RawResult, err error,
+	redactors []parser.Redactor, attrs ...slog.Attr) {
+	level := slog.LevelDebug
+	msg := "command finished"
+	switch {
+	case errors.Is(err, utils.ErrPanicRecovered):
+		level, msg = slog.LevelError, "command panicked"
+	case err != nil:
+		level, msg = slog.LevelWarn, "command failed"
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	all := []slog.Attr{slog.String("cmd", redact(cmd, redactors))}
+	if rr != nil {
+		all = append(all,
+			slog.String("host", rr.Host),
+			slog.Int("exit_code", rr.ExitCode),
+			slog.Int("attempt", rr.Attempt),
+			slog.Duration("duration", rr.Duration),
+		)
+	}
+	all = append(all, attrs...)
+	if err != nil {
+		all = append(all, slog.String("error", RedactString(err.Error(), redactors)))
+	}
+	logger.LogAttrs(ctx, level, msg, all...)
+}
+
+// RedactString passes s through redactors
+func RedactString(s string, redactors []parser.Redactor) string {
+	for _, r := range redactors {
+		s = r(s)
+	}
+	return s
+}
+
+// Timing calls observe with the wall time spent below it in the chain, including retries
+func Timing[O any](observe func(cmd *command.Command, d time.Duration, err error)) Middleware[O] {
+	return func(next RunFunc[O]) RunFunc[O] {
+		return func(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+			start := time.Now()
+			rr, err := next(ctx, cmd, dst, opts...)
+			observe(cmd, time.Since(start), err)
+			return rr, err
+		}
+	}
+}
+
+// Retry runs a failed command again, up to attempts runs in total, waiting delay between them.
+// retryIf decides whether a failure is retried; nil retries every error.
+// Context cancellation is never retried. RawResult.Attempt holds the attempt number.
+// Every attempt is a separate Run, so a WithEvents channel gets the exit event of each
+// attempt; Stream reports only the last one
+func Retry[O any](attempts int, delay time.Duration, retryIf func(rr *parser.RawResult, err error) bool) Middleware[O] {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return func(next RunFunc[O]) RunFunc[O] {
+		return func(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+			var rr *parser.RawResult
+			var err error
+			for attempt := 1; ; attempt++ {
+				rr, err = next(ctx, cmd, dst, opts...)
+				if rr != nil {
+					rr.Attempt = attempt
+				}
+				if err == nil || attempt >= attempts || ctx.Err() != nil {
+					return rr, err
+				}
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					return rr, err
+				}
+				if retryIf != nil && !retryIf(rr, err) {
+					return rr, err
+				}
+
+				t := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return rr, err
+				case <-t.C:
+				}
+			}
+		}
+	}
+}
+
+// AllowCommands rejects every command whose String() matches none of patterns
+func AllowCommands[O any](patterns ...*regexp.Regexp) Middleware[O] {
+	return commandPolicy[O](func(line string) bool {
+		return matchAny(patterns, line)
+	})
+}
+
+// DenyCommands rejects every command whose String() matches any of patterns
+func DenyCommands[O any](patterns ...*regexp.Regexp) Middleware[O] {
+	return commandPolicy[O](func(line string) bool {
+		return !matchAny(patterns, line)
+	})
+}
+
+// commandPolicy runs only commands accepted by allowed; others fail with utils.ErrCommandDenied.
+// The error does not quote the command line, which may hold secrets
+func commandPolicy[O any](allowed func(line string) bool) Middleware[O] {
+	return func(next RunFunc[O]) RunFunc[O] {
+		return func(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+			if !allowed(cmd.String()) {
+				rr := parser.NewRawResult(cmd)
+				rr.ExitCode = -1
+				rr.Err = utils.ErrCommandDenied
+				return rr, rr.Err
+			}
+			return next(ctx, cmd, dst, opts...)
+		}
+	}
+}
+
+// matchAny reports whether line matches at least one pattern
+func matchAny(patterns []*regexp.Regexp, line string) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact renders cmd and passes it through redactors
+func redact(cmd *command.Command, redactors []parser.Redactor) string {
+	if cmd == nil {
+		return ""
+	}
+	return RedactString(cmd.String(), redactors)
+}
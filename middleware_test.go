@@ -0,0 +1,243 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+// funcClient runs every command through fn
+type funcClient struct {
+	fn func(cmd *command.Command) (*parser.RawResult, error)
+}
+
+func (c *funcClient) Run(_ context.Context, cmd *command.Command, _ any, _ ...string) (*parser.RawResult, error) {
+	return c.fn(cmd)
+}
+
+func (c *funcClient) Close() error { return nil }
+
+func TestWrap_Order(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware[string] {
+		return func(next RunFunc[string]) RunFunc[string] {
+			return func(ctx context.Context, cmd *command.Command, dst any, opts ...string) (*parser.RawResult, error) {
+				order = append(order, name)
+				return next(ctx, cmd, dst, opts...)
+			}
+		}
+	}
+	cl := Wrap[string](&funcClient{fn: func(cmd *command.Command) (*parser.RawResult, error) {
+		order = append(order, "client")
+		return parser.NewRawResult(cmd), nil
+	}}, mw("outer"), mw("inner"))
+
+	if _, err := cl.Run(context.Background(), command.New("true"), nil); err != nil {
+		t.Fatalf("Run error = %v", err)
+	}
+	if strings.Join(order, ",") != "outer,inner,client" {
+		t.Errorf("order = %v; want [outer inner client]", order)
+	}
+	if err := cl.Close(); err != nil {
+		t.Errorf("Close error = %v", err)
+	}
+
+	if _, err := Wrap[string](nil).Run(context.Background(), command.New("true"), nil); !errors.Is(err, utils.ErrClientNil) {
+		t.Errorf("nil client err = %v; want ErrClientNil", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	cl := Wrap[string](&funcClient{fn: func(*command.Command) (*parser.RawResult, error) {
+		panic("boom")
+	}}, Recover[string]())
+
+	rr, err := cl.Run(context.Background(), command.New("true"), nil)
+	if err == nil || !strings.Contains(err.Error(), "recovered from panic on run: boom") {
+		t.Fatalf("err = %v; want recovered panic", err)
+	}
+	if rr == nil || rr.ExitCode != -1 || rr.Err != err {
+		t.Errorf("rr = %+v; want exit -1 with err", rr)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	errTemp := errors.New("temporary")
+	errFatal := errors.New("fatal")
+	tests := []struct {
+		name        string
+		failures    []error
+		attempts    int
+		retryIf     func(*parser.RawResult, error) bool
+		wantCalls   int
+		wantErr     error
+		wantAttempt int
+	}{
+		{"success_first", nil, 3, nil, 1, nil, 1},
+		{"success_after_retry", []error{errTemp, errTemp}, 3, nil, 3, nil, 3},
+		{"exhausted", []error{errTemp, errTemp, errTemp}, 2, nil, 2, errTemp, 2},
+		{"not_retryable", []error{errFatal, errTemp}, 3, func(_ *parser.RawResult, err error) bool {
+			return !errors.Is(err, errFatal)
+		}, 1, errFatal, 1},
+		{"canceled_not_retried", []error{context.Canceled}, 3, nil, 1, context.Canceled, 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			cl := Wrap[string](&funcClient{fn: func(cmd *command.Command) (*parser.RawResult, error) {
+				calls++
+				rr := parser.NewRawResult(cmd)
+				if calls <= len(tc.failures) {
+					rr.Err = tc.failures[calls-1]
+					return rr, rr.Err
+				}
+				return rr, nil
+			}}, Retry[string](tc.attempts, time.Millisecond, tc.retryIf))
+
+			rr, err := cl.Run(context.Background(), command.New("flaky"), nil)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("err = %v; want %v", err, tc.wantErr)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("calls = %d; want %d", calls, tc.wantCalls)
+			}
+			if rr.Attempt != tc.wantAttempt {
+				t.Errorf("Attempt = %d; want %d", rr.Attempt, tc.wantAttempt)
+			}
+		})
+	}
+}
+
+func TestCommandPolicies(t *testing.T) {
+	ok := &funcClient{fn: func(cmd *command.Command) (*parser.RawResult, error) {
+		return parser.NewRawResult(cmd), nil
+	}}
+	tests := []struct {
+		name    string
+		mw      Middleware[string]
+		cmd     string
+		wantErr bool
+	}{
+		{"allow_match", AllowCommands[string](regexp.MustCompile(`^systemctl status `)), "systemctl status nginx", false},
+		{"allow_no_match", AllowCommands[string](regexp.MustCompile(`^systemctl status `)), "systemctl stop nginx", true},
+		{"deny_match", DenyCommands[string](regexp.MustCompile(`\brm\s+-rf\b`)), "rm -rf /", true},
+		{"deny_no_match", DenyCommands[string](regexp.MustCompile(`\brm\s+-rf\b`)), "ls /", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Wrap[string](ok, tc.mw).Run(context.Background(), command.New(tc.cmd), nil)
+			if tc.wantErr != errors.Is(err, utils.ErrCommandDenied) {
+				t.Errorf("err = %v; wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandPolicy_Redaction(t *testing.T) {
+	var logBuf, auditBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	secret := parser.RedactValues("hunter2")
+	ok := &funcClient{fn: func(cmd *command.Command) (*parser.RawResult, error) {
+		return parser.NewRawResult(cmd), nil
+	}}
+
+	cl := Wrap[string](ok,
+		Logging[string](logger, secret),
+		Audit[string](NewAuditLog(&auditBuf, secret)),
+		DenyCommands[string](regexp.MustCompile(`^mysql `)),
+	)
+	_, err := cl.Run(context.Background(), command.New("mysql -phunter2 -e 'drop database app'"), nil)
+	if !errors.Is(err, utils.ErrCommandDenied) {
+		t.Fatalf("err = %v; want %v", err, utils.ErrCommandDenied)
+	}
+
+	for name, text := range map[string]string{"error": err.Error(), "log": logBuf.String(), "audit": auditBuf.String()} {
+		if strings.Contains(text, "hunter2") {
+			t.Errorf("%s leaks secret: %q", name, text)
+		}
+	}
+	if !strings.Contains(logBuf.String(), `cmd="mysql -p*** -e 'drop database app'"`) {
+		t.Errorf("log = %q; want the redacted command", logBuf.String())
+	}
+}
+
+func TestLogRun(t *testing.T) {
+	redactors := []parser.Redactor{parser.RedactValues("hunter2")}
+
+	tests := []struct {
+		name      string
+		err       error
+		wantLevel string
+		wantMsg   string
+	}{
+		{"success", nil, "level=DEBUG", `msg="command finished"`},
+		{"failure", errors.New("bad password hunter2"), "level=WARN", `msg="command failed"`},
+		{"panic", panicError("boom"), "level=ERROR", `msg="command panicked"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			cmd := command.New("login -p hunter2")
+			rr := parser.NewRawResult(cmd)
+			rr.Host = "web-1"
+
+			LogRun(context.Background(), logger, cmd, rr, tc.err, redactors, slog.Uint64("session", 7))
+
+			out := logBuf.String()
+			for _, want := range []string{tc.wantLevel, tc.wantMsg, `cmd="login -p ***"`, "host=web-1", "session=7"} {
+				if !strings.Contains(out, want) {
+					t.Errorf("log = %q; want containing %q", out, want)
+				}
+			}
+			if strings.Contains(out, "hunter2") {
+				t.Errorf("log leaks secret: %q", out)
+			}
+		})
+	}
+}
+
+func TestLoggingAndTiming(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+
+	var observed time.Duration
+	cl := Wrap[string](&funcClient{fn: func(cmd *command.Command) (*parser.RawResult, error) {
+		rr := parser.NewRawResult(cmd)
+		rr.ExitCode = 1
+		rr.Err = errors.New("exit 1")
+		return rr, rr.Err
+	}},
+		Logging[string](logger, parser.RedactValues("hunter2")),
+		Timing[string](func(_ *command.Command, d time.Duration, _ error) { observed = d }),
+	)
+
+	_, _ = cl.Run(context.Background(), command.New("login -p hunter2"), nil)
+
+	out := logBuf.String()
+	for _, want := range []string{"level=ERROR", `msg="command failed"`, `cmd="login -p ***"`, "exit_code=1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log = %q; want containing %q", out, want)
+		}
+	}
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("log leaks secret: %q", out)
+	}
+	if observed <= 0 {
+		t.Errorf("observed duration = %v; want > 0", observed)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrTailSize is how many trailing stderr bytes are kept for error messages
+const ErrTailSize = 1 << 10
+
+// TruncatePolicy decides what happens to output beyond OutputLimit.MaxBytes
+type TruncatePolicy int
+
+const (
+	TruncateTail TruncatePolicy = iota // keep the first MaxBytes, drop the rest
+	TruncateHead                       // keep the last MaxBytes, drop the beginning
+	SpillToFile                        // keep the first MaxBytes in memory, write the full stream to a temp file
+)
+
+// OutputLimit bounds how much output of one stream is captured in memory.
+// A zero MaxBytes means no limit
+type OutputLimit struct {
+	MaxBytes int64          // max bytes kept in memory per stream
+	Policy   TruncatePolicy // what to do with the excess
+	SpillDir string         // directory for spill files (os.TempDir() if empty)
+}
+
+// CaptureBuffer is an io.Writer that records output according to an OutputLimit.
+// It never fails a write because of the limit, so the command keeps running
+type CaptureBuffer struct {
+	mu    sync.Mutex
+	limit OutputLimit
+	head  bytes.Buffer // captured output for TruncateTail and SpillToFile, or unlimited output
+	tail  *RingBuffer  // captured output for TruncateHead
+	total int64        // all bytes written, including dropped ones
+	spill *os.File     // full copy of the stream once SpillToFile is triggered
+	err   error        // first spill error
+}
+
+// NewCaptureBuffer creates a CaptureBuffer with the given limit
+func NewCaptureBuffer(limit OutputLimit) *CaptureBuffer {
+	b := &CaptureBuffer{}
+	b.SetLimit(limit)
+	return b
+}
+
+// SetLimit replaces the limit. Call it before the first Write
+func (b *CaptureBuffer) SetLimit(limit OutputLimit) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.limit = limit
+	b.tail = nil
+	if limit.MaxBytes > 0 && limit.Policy == TruncateHead {
+		b.tail = NewRingBuffer(int(limit.MaxBytes))
+	}
+}
+
+// Write records p, dropping or spilling anything beyond the limit
+func (b *CaptureBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.total += int64(len(p))
+	maxBytes := b.limit.MaxBytes
+
+	switch {
+	case maxBytes <= 0:
+		b.head.Write(p)
+	case b.limit.Policy == TruncateHead:
+		b.tail.Write(p)
+	default:
+		if room := maxBytes - int64(b.head.Len()); room > 0 {
+			b.head.Write(p[:min(int64(len(p)), room)])
+		}
+		if b.limit.Policy == SpillToFile && b.total > maxBytes {
+			b.writeSpill(p)
+		}
+	}
+	return len(p), nil
+}
+
+// writeSpill appends p to the spill file, creating it with the captured head first
+func (b *CaptureBuffer) writeSpill(p []byte) {
+	if b.err != nil {
+		return
+	}
+	if b.spill == nil {
+		f, err := os.CreateTemp(b.limit.SpillDir, "rexec-output-*")
+		if err != nil {
+			b.err = fmt.Errorf("create spill file: %w", err)
+			return
+		}
+		b.spill = f
+		// everything before p: the in-memory head is complete because the limit was just crossed
+		written := b.total - int64(len(p))
+		if _, err := f.Write(b.head.Bytes()[:written]); err != nil {
+			b.err = fmt.Errorf("write spill file: %w", err)
+			return
+		}
+	}
+	if _, err := b.spill.Write(p); err != nil {
+		b.err = fmt.Errorf("write spill file: %w", err)
+	}
+}
+
+// Bytes returns the captured output
+func (b *CaptureBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tail != nil {
+		return b.tail.Bytes()
+	}
+	return b.head.Bytes()
+}
+
+// Len returns the number of captured bytes
+func (b *CaptureBuffer) Len() int {
+	return len(b.Bytes())
+}
+
+// Total returns the number of bytes written, including dropped ones
+func (b *CaptureBuffer) Total() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
+
+// Truncated reports whether some output is missing from Bytes
+func (b *CaptureBuffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit.MaxBytes > 0 && b.total > b.limit.MaxBytes
+}
+
+// SpillPath returns the path of the spill file, or "" if output was not spilled
+func (b *CaptureBuffer) SpillPath() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.spill == nil {
+		return ""
+	}
+	return b.spill.Name()
+}
+
+// Close closes the spill file, if any, and returns the first spill error.
+// The file itself is kept for the caller to read and remove
+func (b *CaptureBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.spill != nil {
+		if err := b.spill.Close(); err != nil && b.err == nil {
+			b.err = fmt.Errorf("close spill file: %w", err)
+		}
+	}
+	return b.err
+}
+
+// Reset clears captured output and counters but keeps the limit
+func (b *CaptureBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.head.Reset()
+	if b.tail != nil {
+		b.tail.Reset()
+	}
+	b.total = 0
+	b.spill = nil
+	b.err = nil
+}
+
+// RingBuffer is an io.Writer that keeps only the last size bytes written to it
+type RingBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	start int   // index of the oldest byte
+	n     int   // number of stored bytes
+	total int64 // all bytes written
+}
+
+// NewRingBuffer creates a RingBuffer holding at most size bytes
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{buf: make([]byte, size)}
+}
+
+// Write stores p, overwriting the oldest bytes when full
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.total += int64(len(p))
+	size := len(r.buf)
+	if size == 0 {
+		return len(p), nil
+	}
+
+	data := p
+	if len(data) >= size {
+		copy(r.buf, data[len(data)-size:])
+		r.start, r.n = 0, size
+		return len(p), nil
+	}
+
+	for len(data) > 0 {
+		end := (r.start + r.n) % size
+		c := copy(r.buf[end:], data)
+		data = data[c:]
+		r.n += c
+		if r.n > size {
+			r.start = (r.start + r.n - size) % size
+			r.n = size
+		}
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the stored bytes, oldest first
+func (r *RingBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]byte, 0, r.n)
+	if r.start+r.n <= len(r.buf) {
+		return append(out, r.buf[r.start:r.start+r.n]...)
+	}
+	out = append(out, r.buf[r.start:]...)
+	return append(out, r.buf[:r.start+r.n-len(r.buf)]...)
+}
+
+// Truncated reports whether older bytes were overwritten
+func (r *RingBuffer) Truncated() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total > int64(r.n)
+}
+
+// Summary returns the stored text trimmed of surrounding whitespace,
+// prefixed with "..." when older output was dropped. Used in error messages
+func (r *RingBuffer) Summary() string {
+	text := strings.TrimSpace(string(r.Bytes()))
+	if r.Truncated() && text != "" {
+		return "..." + text
+	}
+	return text
+}
+
+// Reset discards stored bytes
+func (r *RingBuffer) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start, r.n, r.total = 0, 0, 0
+}
@@ -0,0 +1,110 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCaptureBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         OutputLimit
+		writes        []string
+		wantBytes     string
+		wantTotal     int64
+		wantTruncated bool
+		wantSpill     string
+	}{
+		{"unlimited", OutputLimit{}, []string{"abc", "def"}, "abcdef", 6, false, ""},
+		{"under_limit", OutputLimit{MaxBytes: 10}, []string{"abc"}, "abc", 3, false, ""},
+		{"truncate_tail", OutputLimit{MaxBytes: 4, Policy: TruncateTail}, []string{"abc", "def"}, "abcd", 6, true, ""},
+		{"truncate_head", OutputLimit{MaxBytes: 4, Policy: TruncateHead}, []string{"abc", "def"}, "cdef", 6, true, ""},
+		{"spill", OutputLimit{MaxBytes: 4, Policy: SpillToFile}, []string{"abc", "def", "gh"}, "abcd", 8, true, "abcdefgh"},
+		{"spill_not_needed", OutputLimit{MaxBytes: 4, Policy: SpillToFile}, []string{"abcd"}, "abcd", 4, false, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.limit.Policy == SpillToFile {
+				tc.limit.SpillDir = t.TempDir()
+			}
+			b := NewCaptureBuffer(tc.limit)
+			for _, w := range tc.writes {
+				if n, err := b.Write([]byte(w)); err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if err := b.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			if got := string(b.Bytes()); got != tc.wantBytes {
+				t.Errorf("Bytes() = %q; want %q", got, tc.wantBytes)
+			}
+			if b.Total() != tc.wantTotal {
+				t.Errorf("Total() = %d; want %d", b.Total(), tc.wantTotal)
+			}
+			if b.Truncated() != tc.wantTruncated {
+				t.Errorf("Truncated() = %v; want %v", b.Truncated(), tc.wantTruncated)
+			}
+
+			path := b.SpillPath()
+			if tc.wantSpill == "" {
+				if path != "" {
+					t.Errorf("SpillPath() = %q; want empty", path)
+				}
+				return
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read spill file: %v", err)
+			}
+			if string(data) != tc.wantSpill {
+				t.Errorf("spill content = %q; want %q", data, tc.wantSpill)
+			}
+		})
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		size          int
+		writes        []string
+		want          string
+		wantTruncated bool
+	}{
+		{"empty", 4, nil, "", false},
+		{"fits", 4, []string{"ab", "c"}, "abc", false},
+		{"exact", 4, []string{"abcd"}, "abcd", false},
+		{"wraps", 4, []string{"abc", "de", "f"}, "cdef", true},
+		{"large_write", 4, []string{"a", "0123456789"}, "6789", true},
+		{"many_small", 3, strings.Split("abcdefghij", ""), "hij", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRingBuffer(tc.size)
+			for _, w := range tc.writes {
+				_, _ = r.Write([]byte(w))
+			}
+			if got := string(r.Bytes()); got != tc.want {
+				t.Errorf("Bytes() = %q; want %q", got, tc.want)
+			}
+			if r.Truncated() != tc.wantTruncated {
+				t.Errorf("Truncated() = %v; want %v", r.Truncated(), tc.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestRingBuffer_Summary(t *testing.T) {
+	r := NewRingBuffer(5)
+	_, _ = r.Write([]byte("  error: disk full\n"))
+	if got := r.Summary(); got != "...full" {
+		t.Errorf("Summary() = %q; want %q", got, "...full")
+	}
+}
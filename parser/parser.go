@@ -18,12 +18,28 @@ type CommandInfo interface {
 
 // RawResult holds the outcome of running a command
 type RawResult struct {
-	CmdPtr   CommandInfo   // command.Command pointer
-	Stdout   string        // collected standard output
-	Stderr   string        // collected standard error
-	ExitCode int           // process exit code
-	Duration time.Duration // time taken to run the command
-	Err      error         // any error from execution or parsing
+	CmdPtr      CommandInfo   // command.Command pointer
+	Stdout      string        // collected standard output
+	Stderr      string        // collected standard error
+	StdoutBytes []byte        // byte-exact standard output
+	StderrBytes []byte        // byte-exact standard error
+	StdoutTotal int64         // stdout bytes produced by the command, including dropped ones
+	StderrTotal int64         // stderr bytes produced by the command, including dropped ones
+	StdoutSpill string        // temp file holding the full stdout when spilled (caller removes it)
+	StderrSpill string        // temp file holding the full stderr when spilled (caller removes it)
+	Truncated   bool          // stdout or stderr exceeded the output limit
+	ExitCode    int           // process exit code
+	Duration    time.Duration // time taken to run the command
+	Err         error         // any error from execution or parsing
+
+	StartedAt  time.Time // when the command was started
+	FinishedAt time.Time // when the command finished or was canceled
+	Host       string    // host the command ran on
+	User       string    // user the command ran as
+	WorkDir    string    // working directory set for the run, as given ("": the ssh login or local process directory)
+	EnvKeys    []string  // sorted names of environment variables set for this run (values are not kept)
+	PTY        bool      // a pseudo-terminal was allocated
+	Attempt    int       // attempt number, starting at 1
 }
 
 // NewRawResult initializes a RawResult for the given shell command
@@ -35,5 +51,17 @@ func NewRawResult(cmdPtr CommandInfo) *RawResult {
 		ExitCode: 0,
 		Duration: 0,
 		Err:      nil,
+		Attempt:  1,
 	}
 }
+
+// MarkStarted records the start time of the command
+func (r *RawResult) MarkStarted() {
+	r.StartedAt = time.Now()
+}
+
+// MarkFinished records the finish time and sets Duration from StartedAt
+func (r *RawResult) MarkFinished() {
+	r.FinishedAt = time.Now()
+	r.Duration = r.FinishedAt.Sub(r.StartedAt)
+}
@@ -0,0 +1,263 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package parser
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RecordVersion is the version of the ResultRecord schema
+const RecordVersion = 1
+
+// redactedMask replaces secrets removed by redactors
+const redactedMask = "***"
+
+// Redactor rewrites a command line (or text that may quote it) before it is serialised, e.g. to mask secrets
+type Redactor func(cmd string) string
+
+// RedactValues returns a Redactor that masks every occurrence of the given secrets
+func RedactValues(secrets ...string) Redactor {
+	return func(cmd string) string {
+		for _, s := range secrets {
+			if s != "" {
+				cmd = strings.ReplaceAll(cmd, s, redactedMask)
+			}
+		}
+		return cmd
+	}
+}
+
+// RedactPattern returns a Redactor that masks every match of re.
+// If re has capture groups, only the first group of each match is masked,
+// so `--password[= ](\S+)` keeps the flag name visible
+func RedactPattern(re *regexp.Regexp) Redactor {
+	return func(cmd string) string {
+		if re.NumSubexp() == 0 {
+			return re.ReplaceAllString(cmd, redactedMask)
+		}
+		var b strings.Builder
+		last := 0
+		for _, m := range re.FindAllStringSubmatchIndex(cmd, -1) {
+			if m[2] < 0 {
+				continue
+			}
+			b.WriteString(cmd[last:m[2]])
+			b.WriteString(redactedMask)
+			last = m[3]
+		}
+		b.WriteString(cmd[last:])
+		return b.String()
+	}
+}
+
+// StoredCommand is a CommandInfo restored from a serialised result
+type StoredCommand string
+
+// String returns the recorded command line
+func (c StoredCommand) String() string {
+	return string(c)
+}
+
+// RecordedError is an error restored from, or prepared for, a serialised result
+type RecordedError struct {
+	Message string `json:"message" yaml:"message"`               // error text
+	Type    string `json:"type,omitempty" yaml:"type,omitempty"` // Go type of the original error
+}
+
+// Error returns the recorded message
+func (e *RecordedError) Error() string {
+	return e.Message
+}
+
+// ResultRecord is the stable serialised form of a RawResult.
+// Output that is not valid UTF-8 is stored base64-encoded in the *_base64 fields
+type ResultRecord struct {
+	Version      int            `json:"version" yaml:"version"`
+	Command      string         `json:"command" yaml:"command"`
+	Host         string         `json:"host,omitempty" yaml:"host,omitempty"`
+	User         string         `json:"user,omitempty" yaml:"user,omitempty"`
+	WorkDir      string         `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+	EnvKeys      []string       `json:"env_keys,omitempty" yaml:"env_keys,omitempty"`
+	PTY          bool           `json:"pty,omitempty" yaml:"pty,omitempty"`
+	Attempt      int            `json:"attempt,omitempty" yaml:"attempt,omitempty"`
+	StartedAt    time.Time      `json:"started_at" yaml:"started_at"`
+	FinishedAt   time.Time      `json:"finished_at" yaml:"finished_at"`
+	Duration     string         `json:"duration" yaml:"duration"`
+	ExitCode     int            `json:"exit_code" yaml:"exit_code"`
+	Stdout       string         `json:"stdout,omitempty" yaml:"stdout,omitempty"`
+	StdoutBase64 string         `json:"stdout_base64,omitempty" yaml:"stdout_base64,omitempty"`
+	Stderr       string         `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	StderrBase64 string         `json:"stderr_base64,omitempty" yaml:"stderr_base64,omitempty"`
+	StdoutTotal  int64          `json:"stdout_total,omitempty" yaml:"stdout_total,omitempty"`
+	StderrTotal  int64          `json:"stderr_total,omitempty" yaml:"stderr_total,omitempty"`
+	Truncated    bool           `json:"truncated,omitempty" yaml:"truncated,omitempty"`
+	StdoutSpill  string         `json:"stdout_spill,omitempty" yaml:"stdout_spill,omitempty"`
+	StderrSpill  string         `json:"stderr_spill,omitempty" yaml:"stderr_spill,omitempty"`
+	Error        *RecordedError `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// NewResultRecord converts rr into its serialised form, passing the command line,
+// the error message and text stderr through redactors (errors and stderr often echo
+// the command). Base64-encoded stderr is kept as is
+func NewResultRecord(rr *RawResult, redactors ...Redactor) *ResultRecord {
+	rec := &ResultRecord{
+		Version:     RecordVersion,
+		Host:        rr.Host,
+		User:        rr.User,
+		WorkDir:     rr.WorkDir,
+		EnvKeys:     rr.EnvKeys,
+		PTY:         rr.PTY,
+		Attempt:     rr.Attempt,
+		StartedAt:   rr.StartedAt,
+		FinishedAt:  rr.FinishedAt,
+		Duration:    rr.Duration.String(),
+		ExitCode:    rr.ExitCode,
+		StdoutTotal: rr.StdoutTotal,
+		StderrTotal: rr.StderrTotal,
+		Truncated:   rr.Truncated,
+		StdoutSpill: rr.StdoutSpill,
+		StderrSpill: rr.StderrSpill,
+	}
+
+	if rr.CmdPtr != nil {
+		rec.Command = redact(rr.CmdPtr.String(), redactors)
+	}
+
+	rec.Stdout, rec.StdoutBase64 = encodeOutput(rr.StdoutBytes, rr.Stdout)
+	rec.Stderr, rec.StderrBase64 = encodeOutput(rr.StderrBytes, rr.Stderr)
+	rec.Stderr = redact(rec.Stderr, redactors)
+
+	if rr.Err != nil {
+		rec.Error = &RecordedError{Message: redact(rr.Err.Error(), redactors), Type: fmt.Sprintf("%T", rr.Err)}
+		if re, ok := rr.Err.(*RecordedError); ok {
+			rec.Error.Type = re.Type
+		}
+	}
+	return rec
+}
+
+// redact passes s through every redactor in order
+func redact(s string, redactors []Redactor) string {
+	for _, r := range redactors {
+		s = r(s)
+	}
+	return s
+}
+
+// RawResult converts the record back into a RawResult that shares no slices with rec.
+// CmdPtr is a StoredCommand and Err is a *RecordedError
+func (rec *ResultRecord) RawResult() (*RawResult, error) {
+	rr := NewRawResult(StoredCommand(rec.Command))
+	rr.Host = rec.Host
+	rr.User = rec.User
+	rr.WorkDir = rec.WorkDir
+	rr.EnvKeys = slices.Clone(rec.EnvKeys)
+	rr.PTY = rec.PTY
+	rr.Attempt = rec.Attempt
+	rr.StartedAt = rec.StartedAt
+	rr.FinishedAt = rec.FinishedAt
+	rr.ExitCode = rec.ExitCode
+	rr.StdoutTotal = rec.StdoutTotal
+	rr.StderrTotal = rec.StderrTotal
+	rr.Truncated = rec.Truncated
+	rr.StdoutSpill = rec.StdoutSpill
+	rr.StderrSpill = rec.StderrSpill
+
+	if rec.Duration != "" {
+		d, err := time.ParseDuration(rec.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("parse duration: %w", err)
+		}
+		rr.Duration = d
+	}
+
+	var err error
+	if rr.StdoutBytes, err = decodeOutput(rec.Stdout, rec.StdoutBase64); err != nil {
+		return nil, fmt.Errorf("decode stdout: %w", err)
+	}
+	if rr.StderrBytes, err = decodeOutput(rec.Stderr, rec.StderrBase64); err != nil {
+		return nil, fmt.Errorf("decode stderr: %w", err)
+	}
+	rr.Stdout = string(rr.StdoutBytes)
+	rr.Stderr = string(rr.StderrBytes)
+
+	if rec.Error != nil {
+		rr.Err = &RecordedError{Message: rec.Error.Message, Type: rec.Error.Type}
+	}
+	return rr, nil
+}
+
+// MarshalJSON encodes the result as a ResultRecord
+func (r *RawResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewResultRecord(r))
+}
+
+// UnmarshalJSON decodes a ResultRecord into the result
+func (r *RawResult) UnmarshalJSON(data []byte) error {
+	var rec ResultRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return err
+	}
+	return r.fromRecord(&rec)
+}
+
+// MarshalYAML encodes the result as a ResultRecord.
+// It satisfies the yaml.Marshaler interface of gopkg.in/yaml.v2 and gopkg.in/yaml.v3
+func (r *RawResult) MarshalYAML() (any, error) {
+	return NewResultRecord(r), nil
+}
+
+// UnmarshalYAML decodes a ResultRecord into the result.
+// It satisfies the yaml.Unmarshaler interface of gopkg.in/yaml.v3; with other
+// libraries decode a ResultRecord and call its RawResult method
+func (r *RawResult) UnmarshalYAML(value *yaml.Node) error {
+	var rec ResultRecord
+	if err := value.Decode(&rec); err != nil {
+		return err
+	}
+	return r.fromRecord(&rec)
+}
+
+// fromRecord replaces r with the result decoded from rec
+func (r *RawResult) fromRecord(rec *ResultRecord) error {
+	if rec.Version > RecordVersion {
+		return fmt.Errorf("unsupported result record version %d", rec.Version)
+	}
+	rr, err := rec.RawResult()
+	if err != nil {
+		return err
+	}
+	*r = *rr
+	return nil
+}
+
+// encodeOutput returns output as text, or base64 if it is not valid UTF-8
+func encodeOutput(data []byte, text string) (string, string) {
+	if data == nil {
+		data = []byte(text)
+	}
+	if utf8.Valid(data) {
+		return string(data), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(data)
+}
+
+// decodeOutput restores output from its text or base64 form
+func decodeOutput(text, b64 string) ([]byte, error) {
+	if b64 != "" {
+		return base64.StdEncoding.DecodeString(b64)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return []byte(text), nil
+}
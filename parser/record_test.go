@@ -0,0 +1,124 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRawResult_RoundTrip(t *testing.T) {
+	started := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	rr := NewRawResult(StoredCommand("cat image.png"))
+	rr.StdoutBytes = []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	rr.Stdout = string(rr.StdoutBytes)
+	rr.Stderr = "warning\n"
+	rr.ExitCode = 2
+	rr.Err = errors.New("command failed")
+	rr.Host = "web-1"
+	rr.User = "deploy"
+	rr.EnvKeys = []string{"A", "B"}
+	rr.StartedAt = started
+	rr.FinishedAt = started.Add(1500 * time.Millisecond)
+	rr.Duration = 1500 * time.Millisecond
+
+	formats := []struct {
+		name      string
+		marshal   func(any) ([]byte, error)
+		unmarshal func([]byte, any) error
+		wantIn    []string
+	}{
+		{"json", json.Marshal, json.Unmarshal,
+			[]string{`"error":{"message":"command failed","type":"*errors.errorString"}`, `"stdout_base64"`}},
+		{"yaml", yaml.Marshal, yaml.Unmarshal,
+			[]string{"error:\n    message: command failed\n    type: '*errors.errorString'", "stdout_base64:"}},
+	}
+
+	for _, f := range formats {
+		t.Run(f.name, func(t *testing.T) {
+			data, err := f.marshal(rr)
+			if err != nil {
+				t.Fatalf("Marshal error = %v", err)
+			}
+			for _, want := range f.wantIn {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("encoded = %s; want it to contain %q", data, want)
+				}
+			}
+
+			var got RawResult
+			if err := f.unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal error = %v", err)
+			}
+
+			if got.CmdPtr.String() != "cat image.png" {
+				t.Errorf("CmdPtr = %q; want %q", got.CmdPtr.String(), "cat image.png")
+			}
+			if !bytes.Equal(got.StdoutBytes, rr.StdoutBytes) || got.Stdout != rr.Stdout {
+				t.Errorf("Stdout = %q; want %q", got.StdoutBytes, rr.StdoutBytes)
+			}
+			if got.Stderr != rr.Stderr || got.ExitCode != 2 || got.Host != "web-1" || got.User != "deploy" {
+				t.Errorf("got %+v; want fields copied from %+v", got, rr)
+			}
+			if !slices.Equal(got.EnvKeys, rr.EnvKeys) {
+				t.Errorf("EnvKeys = %v; want %v", got.EnvKeys, rr.EnvKeys)
+			}
+			if !got.StartedAt.Equal(rr.StartedAt) || !got.FinishedAt.Equal(rr.FinishedAt) || got.Duration != rr.Duration {
+				t.Errorf("StartedAt, FinishedAt, Duration = %v, %v, %v; want %v, %v, %v",
+					got.StartedAt, got.FinishedAt, got.Duration, rr.StartedAt, rr.FinishedAt, rr.Duration)
+			}
+			var recErr *RecordedError
+			if !errors.As(got.Err, &recErr) || recErr.Message != "command failed" || recErr.Type != "*errors.errorString" {
+				t.Errorf("Err = %#v; want RecordedError with original message and type", got.Err)
+			}
+		})
+	}
+}
+
+func TestRawResult_UnmarshalFutureVersion(t *testing.T) {
+	var rr RawResult
+	err := json.Unmarshal([]byte(`{"version":99,"command":"true"}`), &rr)
+	if err == nil || !strings.Contains(err.Error(), "unsupported result record version") {
+		t.Errorf("err = %v; want unsupported version", err)
+	}
+}
+
+func TestRedactors(t *testing.T) {
+	tests := []struct {
+		name   string
+		redact Redactor
+		in     string
+		want   string
+	}{
+		{"values", RedactValues("s3cr3t", ""), "mysql -ps3cr3t -e 'select s3cr3t'", "mysql -p*** -e 'select ***'"},
+		{"pattern", RedactPattern(regexp.MustCompile(`token=\w+`)), "curl ?token=abc123", "curl ?***"},
+		{"pattern_group", RedactPattern(regexp.MustCompile(`--password[= ](\S+)`)), "app --password=hunter2 --user=bob", "app --password=*** --user=bob"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := NewRawResult(StoredCommand(tc.in))
+			rr.Stderr = "sh: " + tc.in + ": not found\n"
+			rr.Err = fmt.Errorf("remote command failed: %s", tc.in)
+			rec := NewResultRecord(rr, tc.redact)
+			if rec.Command != tc.want {
+				t.Errorf("Command = %q; want %q", rec.Command, tc.want)
+			}
+			if want := "sh: " + tc.want + ": not found\n"; rec.Stderr != want {
+				t.Errorf("Stderr = %q; want %q", rec.Stderr, want)
+			}
+			if want := "remote command failed: " + tc.want; rec.Error.Message != want {
+				t.Errorf("Error.Message = %q; want %q", rec.Error.Message, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of a running transfer, reported to a ProgressFunc
+type Progress struct {
+	Path      string        // file currently transferred
+	FileBytes int64         // bytes of Path transferred so far
+	FileSize  int64         // size of Path (UnknownSize if not known)
+	Bytes     int64         // bytes transferred by the whole operation (all files of a tree)
+	Total     int64         // bytes expected for the whole operation (UnknownSize if not known)
+	Elapsed   time.Duration // time since the operation started
+	Rate      float64       // average rate in bytes per second
+	ETA       time.Duration // estimated time left (0 if Total is unknown)
+	Done      bool          // Path is complete; reported once per file
+}
+
+// ProgressFunc receives transfer progress. It is called from the transferring
+// goroutine, so it should return quickly
+type ProgressFunc func(Progress)
+
+// ProgressChan returns a ProgressFunc that sends to ch without blocking:
+// updates are dropped while ch is full, except Done ones which wait for room
+// until ctx is done, so an abandoned channel cannot stall the transfer
+func ProgressChan(ctx context.Context, ch chan<- Progress) ProgressFunc {
+	return func(p Progress) {
+		if p.Done {
+			select {
+			case ch <- p:
+			case <-ctx.Done():
+			}
+			return
+		}
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// BandwidthLimiter is a token bucket limiting transfer throughput. One limiter
+// can be shared by concurrent transfers to cap their combined rate
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  int     // bucket capacity and largest single wait
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidthLimiter returns a limiter allowing bytesPerSec on average with bursts
+// of up to one second of traffic. A non-positive rate returns nil, which does not limit
+func NewBandwidthLimiter(bytesPerSec int64) *BandwidthLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &BandwidthLimiter{rate: float64(bytesPerSec), burst: int(bytesPerSec), tokens: float64(bytesPerSec), last: time.Now()}
+}
+
+// Burst returns the largest number of bytes that should be moved between waits
+func (l *BandwidthLimiter) Burst() int {
+	if l == nil {
+		return 0
+	}
+	return l.burst
+}
+
+// Wait takes n bytes from the bucket, sleeping until they are available or ctx is done
+func (l *BandwidthLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
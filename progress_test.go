@@ -0,0 +1,82 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimiter_Wait(t *testing.T) {
+	if NewBandwidthLimiter(0) != nil {
+		t.Error("NewBandwidthLimiter(0) should not limit")
+	}
+	var none *BandwidthLimiter
+	if err := none.Wait(context.Background(), 1<<30); err != nil || none.Burst() != 0 {
+		t.Errorf("nil limiter: Wait = %v, Burst = %d", err, none.Burst())
+	}
+
+	l := NewBandwidthLimiter(100_000)
+	start := time.Now()
+	if err := l.Wait(context.Background(), 100_000); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("full bucket waited %v", elapsed)
+	}
+	if err := l.Wait(context.Background(), 20_000); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("20 KB over an empty 100 KB/s bucket took %v; want about 200ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx, 100_000); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled Wait = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestProgressChan(t *testing.T) {
+	ch := make(chan Progress, 1)
+	report := ProgressChan(context.Background(), ch)
+	report(Progress{Bytes: 1})
+	report(Progress{Bytes: 2}) // dropped, channel full
+
+	done := make(chan struct{})
+	go func() {
+		report(Progress{Bytes: 3, Done: true}) // waits for room
+		close(done)
+	}()
+
+	if p := <-ch; p.Bytes != 1 {
+		t.Errorf("first = %+v; want Bytes 1", p)
+	}
+	if p := <-ch; p.Bytes != 3 || !p.Done {
+		t.Errorf("second = %+v; want the Done update", p)
+	}
+	<-done
+}
+
+func TestProgressChan_Abandoned(t *testing.T) {
+	ch := make(chan Progress, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	report := ProgressChan(ctx, ch)
+	report(Progress{Bytes: 1}) // fills the channel, which is never read again
+
+	done := make(chan struct{})
+	go func() {
+		report(Progress{Bytes: 2, Done: true})
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Done update blocked after the context was canceled")
+	}
+}
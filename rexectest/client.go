@@ -0,0 +1,281 @@
+// Copyright © NGRSoftlab 2020-2025
+
+// Package rexectest provides scriptable fakes of rexec clients and file transfers for unit tests
+package rexectest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+// ErrUnexpectedCommand is returned for a command that matches no expectation
+var ErrUnexpectedCommand = errors.New("unexpected command")
+
+// interface guard: ensure Client satisfies rexec.Client for any option type
+var _ rexec.Client[any] = (*Client[any])(nil)
+
+// Matcher selects commands by their rendered line or structure
+type Matcher interface {
+	Match(cmd *command.Command) bool
+	String() string
+}
+
+// matcher is a Matcher built from a predicate and a description
+type matcher struct {
+	desc string
+	fn   func(cmd *command.Command) bool
+}
+
+func (m matcher) Match(cmd *command.Command) bool { return m.fn(cmd) }
+func (m matcher) String() string                  { return m.desc }
+
+// Exact matches commands whose String() equals line
+func Exact(line string) Matcher {
+	return matcher{
+		desc: fmt.Sprintf("%q", line),
+		fn:   func(cmd *command.Command) bool { return cmd.String() == line },
+	}
+}
+
+// Regex matches commands whose String() matches pattern. It panics if pattern is invalid
+func Regex(pattern string) Matcher {
+	re := regexp.MustCompile(pattern)
+	return matcher{
+		desc: fmt.Sprintf("/%s/", pattern),
+		fn:   func(cmd *command.Command) bool { return re.MatchString(cmd.String()) },
+	}
+}
+
+// Func matches commands accepted by fn; desc is used in failure messages
+func Func(desc string, fn func(cmd *command.Command) bool) Matcher {
+	return matcher{desc: desc, fn: fn}
+}
+
+// Any matches every command
+func Any() Matcher {
+	return matcher{desc: "any command", fn: func(*command.Command) bool { return true }}
+}
+
+// Expectation is a scripted response for commands selected by a Matcher.
+// Its setters return the Expectation for chaining
+type Expectation struct {
+	matcher  Matcher
+	stdout   string
+	stderr   string
+	exitCode int
+	err      error
+	delay    time.Duration
+	times    int // 0 = unlimited
+	calls    int
+}
+
+// Stdout sets the returned stdout
+func (e *Expectation) Stdout(s string) *Expectation { e.stdout = s; return e }
+
+// Stderr sets the returned stderr
+func (e *Expectation) Stderr(s string) *Expectation { e.stderr = s; return e }
+
+// ExitCode sets the returned exit code; a non-zero code without Err yields a generic error
+func (e *Expectation) ExitCode(code int) *Expectation { e.exitCode = code; return e }
+
+// Err sets the returned error
+func (e *Expectation) Err(err error) *Expectation { e.err = err; return e }
+
+// Delay makes Run wait d (or until ctx is done) before responding
+func (e *Expectation) Delay(d time.Duration) *Expectation { e.delay = d; return e }
+
+// Times limits the expectation to n matches; later matching commands fall through to the next expectation
+func (e *Expectation) Times(n int) *Expectation { e.times = n; return e }
+
+// Once is shorthand for Times(1)
+func (e *Expectation) Once() *Expectation { return e.Times(1) }
+
+// exhausted reports whether the expectation already served all its calls
+func (e *Expectation) exhausted() bool {
+	return e.times > 0 && e.calls >= e.times
+}
+
+// Call is one Run invocation recorded by Client
+type Call[O any] struct {
+	Line    string           // rendered command line
+	Command *command.Command // command as passed to Run
+	Options []O              // options as passed to Run
+	Time    time.Time        // when Run was called
+}
+
+// Client is a scriptable fake implementing rexec.Client[O].
+// Expectations are checked in the order they were added. It is safe for concurrent use
+type Client[O any] struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []Call[O]
+	closed       bool
+}
+
+// NewClient creates a Client without expectations: every command fails with ErrUnexpectedCommand
+func NewClient[O any]() *Client[O] {
+	return &Client[O]{}
+}
+
+// On adds an expectation for commands selected by m. Without further setup
+// matching commands succeed with empty output
+func (c *Client[O]) On(m Matcher) *Expectation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &Expectation{matcher: m}
+	c.expectations = append(c.expectations, e)
+	return e
+}
+
+// Run records the call and answers with the first matching expectation
+func (c *Client[O]) Run(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+	if cmd == nil {
+		return nil, utils.ErrCommandNil
+	}
+	line := cmd.String()
+	result := parser.NewRawResult(cmd)
+	result.Host = "rexectest"
+
+	c.mu.Lock()
+	c.calls = append(c.calls, Call[O]{Line: line, Command: cmd, Options: append([]O(nil), opts...), Time: time.Now()})
+	var exp *Expectation
+	for _, e := range c.expectations {
+		if !e.exhausted() && e.matcher.Match(cmd) {
+			e.calls++
+			exp = e
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	result.MarkStarted()
+	defer result.MarkFinished()
+
+	if exp == nil {
+		result.ExitCode = -1
+		result.Err = fmt.Errorf("%w: %q", ErrUnexpectedCommand, line)
+		return result, result.Err
+	}
+
+	if exp.delay > 0 {
+		t := time.NewTimer(exp.delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			result.ExitCode = -1
+			result.Err = ctx.Err()
+			return result, result.Err
+		case <-t.C:
+		}
+	}
+
+	result.Stdout, result.StdoutBytes = exp.stdout, []byte(exp.stdout)
+	result.Stderr, result.StderrBytes = exp.stderr, []byte(exp.stderr)
+	result.StdoutTotal, result.StderrTotal = int64(len(exp.stdout)), int64(len(exp.stderr))
+	result.ExitCode = exp.exitCode
+	switch {
+	case exp.err != nil:
+		result.Err = exp.err
+	case exp.exitCode != 0:
+		result.Err = fmt.Errorf("command failed (exit %d): %s", exp.exitCode, strings.TrimSpace(exp.stderr))
+	}
+	if result.Err != nil {
+		return result, result.Err
+	}
+
+	if cmd.Parser != nil && dst != nil {
+		if parseErr := cmd.Parser.Parse(result, dst); parseErr != nil {
+			result.Err = fmt.Errorf("parse error: %w", parseErr)
+		}
+	}
+	return result, result.Err
+}
+
+// Close marks the client closed
+func (c *Client[O]) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// Closed reports whether Close was called
+func (c *Client[O]) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Calls returns the recorded calls in order
+func (c *Client[O]) Calls() []Call[O] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call[O](nil), c.calls...)
+}
+
+// CallCount returns how many recorded calls match m
+func (c *Client[O]) CallCount(m Matcher) int {
+	n := 0
+	for _, call := range c.Calls() {
+		if m.Match(call.Command) {
+			n++
+		}
+	}
+	return n
+}
+
+// AssertCalledTimes fails t unless exactly n recorded calls match m
+func (c *Client[O]) AssertCalledTimes(t testing.TB, m Matcher, n int) {
+	t.Helper()
+	if got := c.CallCount(m); got != n {
+		t.Errorf("rexectest: %s called %d time(s); want %d", m, got, n)
+	}
+}
+
+// AssertCallOrder fails t unless calls matching ms were made in this order
+// (other calls may occur in between)
+func (c *Client[O]) AssertCallOrder(t testing.TB, ms ...Matcher) {
+	t.Helper()
+	calls := c.Calls()
+	i := 0
+	for _, call := range calls {
+		if i < len(ms) && ms[i].Match(call.Command) {
+			i++
+		}
+	}
+	if i < len(ms) {
+		lines := make([]string, len(calls))
+		for j, call := range calls {
+			lines[j] = call.Line
+		}
+		t.Errorf("rexectest: call order broken at %s; calls were %q", ms[i], lines)
+	}
+}
+
+// AssertExpectations fails t for every expectation that was never matched,
+// or matched a different number of times than set with Times
+func (c *Client[O]) AssertExpectations(t testing.TB) {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.expectations {
+		switch {
+		case e.times > 0 && e.calls != e.times:
+			t.Errorf("rexectest: expectation %s matched %d time(s); want %d", e.matcher, e.calls, e.times)
+		case e.times == 0 && e.calls == 0:
+			t.Errorf("rexectest: expectation %s was never matched", e.matcher)
+		}
+	}
+}
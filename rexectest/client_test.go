@@ -0,0 +1,116 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexectest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser/examples"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+// recordingTB captures failures reported through testing.TB
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Helper() {}
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, format)
+}
+
+func TestClient_Run(t *testing.T) {
+	errDown := errors.New("host down")
+	fake := NewClient[string]()
+	fake.On(Exact("test -f /etc/app.conf && echo true || echo false")).Stdout("true\n")
+	fake.On(Regex(`^systemctl restart `)).ExitCode(1).Stderr("unit not found").Once()
+	fake.On(Regex(`^systemctl restart `)).Stdout("ok")
+	fake.On(Func("ping", func(cmd *command.Command) bool { return strings.HasPrefix(cmd.Template, "ping") })).Err(errDown)
+
+	ctx := context.Background()
+	exists, err := rexec.RunParse[string, bool](ctx, fake, command.New(
+		"test -f %s && echo true || echo false",
+		command.WithArgs("/etc/app.conf"),
+		command.WithParser(&examples.BoolParser{}),
+	))
+	if err != nil || !exists {
+		t.Errorf("RunParse = %v, %v; want true, nil", exists, err)
+	}
+
+	_, stderr, code, err := rexec.RunRaw[string](ctx, fake, command.New("systemctl restart app"))
+	if err == nil || code != 1 || stderr != "unit not found" {
+		t.Errorf("first restart = %q, %d, %v; want failure with exit 1", stderr, code, err)
+	}
+	stdout, _, code, err := rexec.RunRaw[string](ctx, fake, command.New("systemctl restart app"))
+	if err != nil || code != 0 || stdout != "ok" {
+		t.Errorf("second restart = %q, %d, %v; want success", stdout, code, err)
+	}
+
+	if err := rexec.RunNoResult[string](ctx, fake, command.New("ping -c1 %s", command.WithArgs("db"))); !errors.Is(err, errDown) {
+		t.Errorf("ping err = %v; want %v", err, errDown)
+	}
+	if err := rexec.RunNoResult[string](ctx, fake, command.New("reboot"), "now"); !errors.Is(err, ErrUnexpectedCommand) {
+		t.Errorf("reboot err = %v; want ErrUnexpectedCommand", err)
+	}
+
+	fake.AssertCalledTimes(t, Regex(`^systemctl`), 2)
+	fake.AssertCallOrder(t, Regex(`^test -f`), Exact("systemctl restart app"), Exact("reboot"))
+	fake.AssertExpectations(t)
+
+	calls := fake.Calls()
+	if len(calls) != 5 || calls[4].Line != "reboot" || len(calls[4].Options) != 1 {
+		t.Errorf("Calls() = %+v; want 5 calls ending with reboot and one option", calls)
+	}
+}
+
+func TestClient_Results(t *testing.T) {
+	fake := NewClient[string]()
+	fake.On(Exact("uname -s")).Stdout("Linux\n")
+	ctx := context.Background()
+
+	for i := range 2 {
+		rr, err := fake.Run(ctx, command.New("uname -s"), nil)
+		if err != nil || string(rr.StdoutBytes) != "Linux\n" {
+			t.Fatalf("run %d = %q, %v; want the scripted output", i+1, rr.StdoutBytes, err)
+		}
+		rr.StdoutBytes[0] = 'X'
+	}
+
+	if _, err := fake.Run(ctx, nil, nil); !errors.Is(err, utils.ErrCommandNil) {
+		t.Errorf("Run(nil) err = %v; want %v", err, utils.ErrCommandNil)
+	}
+}
+
+func TestClient_Delay(t *testing.T) {
+	fake := NewClient[string]()
+	fake.On(Any()).Delay(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rexec.RunNoResult[string](ctx, fake, command.New("sleep 1")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v; want DeadlineExceeded", err)
+	}
+}
+
+func TestClient_AssertionsFail(t *testing.T) {
+	fake := NewClient[string]()
+	fake.On(Exact("never"))
+	fake.On(Exact("twice")).Times(2)
+	_ = rexec.RunNoResult[string](context.Background(), fake, command.New("twice"))
+	_ = rexec.RunNoResult[string](context.Background(), fake, command.New("first"))
+
+	tb := &recordingTB{TB: t}
+	fake.AssertExpectations(tb)
+	fake.AssertCalledTimes(tb, Exact("twice"), 2)
+	fake.AssertCallOrder(tb, Exact("first"), Exact("twice"))
+	if len(tb.errors) != 4 {
+		t.Errorf("reported %d failures; want 4: %q", len(tb.errors), tb.errors)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexectest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+var (
+	ErrUnrecordedCommand = errors.New("command not recorded")          // no recording left for the command
+	ErrReplayOrder       = errors.New("command out of recorded order") // strict replay got a different command
+)
+
+// interface guard: ensure Replay satisfies rexec.Client for any option type
+var _ rexec.Client[any] = (*Replay[any])(nil)
+
+// Record returns a Client that runs commands on client and writes every result,
+// failed ones included, to w as a JSON-lines fixture for Replay.
+// Redactors mask secrets in the recorded command lines; pass the same ones to
+// WithReplayRedactors so that the commands still match when replayed
+func Record[O any](client rexec.Client[O], w io.Writer, redactors ...parser.Redactor) rexec.Client[O] {
+	return rexec.WithAudit(client, rexec.NewAuditLog(w, redactors...))
+}
+
+// ReadFixtures decodes JSON-lines records written by Record (or an AuditLog)
+func ReadFixtures(r io.Reader) ([]*parser.ResultRecord, error) {
+	var records []*parser.ResultRecord
+	dec := json.NewDecoder(r)
+	for {
+		rec := new(parser.ResultRecord)
+		if err := dec.Decode(rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			return nil, fmt.Errorf("decode fixture %d: %w", len(records)+1, err)
+		}
+		if rec.Version > parser.RecordVersion {
+			return nil, fmt.Errorf("fixture %d: unsupported record version %d", len(records)+1, rec.Version)
+		}
+		records = append(records, rec)
+	}
+}
+
+// ReplayMode selects how commands are matched against the recording
+type ReplayMode int
+
+const (
+	ReplayLenient ReplayMode = iota // any order; command lines compared with whitespace collapsed
+	ReplayStrict                    // recorded order; command lines must be equal
+)
+
+// ReplayOption configures a Replay
+type ReplayOption func(*replayConfig)
+
+// replayConfig holds Replay settings
+type replayConfig struct {
+	mode             ReplayMode
+	failOnUnrecorded bool              // unrecorded commands fail instead of succeeding with empty output
+	timing           bool              // wait the recorded duration before answering
+	redactors        []parser.Redactor // applied to command lines before they are matched
+}
+
+// WithReplayMode sets the matching mode (ReplayLenient by default)
+func WithReplayMode(mode ReplayMode) ReplayOption {
+	return func(c *replayConfig) {
+		c.mode = mode
+	}
+}
+
+// FailOnUnrecorded makes unrecorded commands fail with ErrUnrecordedCommand.
+// Without it they succeed with empty output. Strict mode always fails
+func FailOnUnrecorded() ReplayOption {
+	return func(c *replayConfig) {
+		c.failOnUnrecorded = true
+	}
+}
+
+// WithReplayRedactors masks command lines with redactors before they are matched,
+// so commands recorded with the same redactors are found despite their secrets
+func WithReplayRedactors(redactors ...parser.Redactor) ReplayOption {
+	return func(c *replayConfig) {
+		c.redactors = redactors
+	}
+}
+
+// WithReplayTiming makes Run wait the recorded duration (or until ctx is done) before answering
+func WithReplayTiming() ReplayOption {
+	return func(c *replayConfig) {
+		c.timing = true
+	}
+}
+
+// Replay implements rexec.Client by serving recorded results keyed by command.Command.String().
+// Several recordings of the same command are served in turn; in lenient mode the last one
+// is repeated once the others are used. It is safe for concurrent use
+type Replay[O any] struct {
+	cfg     replayConfig
+	records []*parser.ResultRecord
+
+	mu     sync.Mutex
+	served []bool // records already replayed
+	next   int    // strict mode: index of the next expected record
+	unrec  []string
+}
+
+// NewReplay creates a Replay serving records
+func NewReplay[O any](records []*parser.ResultRecord, opts ...ReplayOption) *Replay[O] {
+	r := &Replay[O]{
+		records: records,
+		served:  make([]bool, len(records)),
+	}
+	for _, opt := range opts {
+		opt(&r.cfg)
+	}
+	return r
+}
+
+// LoadReplay reads a fixture file written by Record and creates a Replay from it
+func LoadReplay[O any](path string, opts ...ReplayOption) (*Replay[O], error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open fixtures: %w", err)
+	}
+	defer f.Close()
+
+	records, err := ReadFixtures(f)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return NewReplay[O](records, opts...), nil
+}
+
+// Run answers cmd with its recorded result and applies cmd.Parser to dst
+func (r *Replay[O]) Run(ctx context.Context, cmd *command.Command, dst any, opts ...O) (*parser.RawResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cmd == nil {
+		return nil, utils.ErrCommandNil
+	}
+	line := rexec.RedactString(cmd.String(), r.cfg.redactors)
+
+	rec, err := r.take(line)
+	if err != nil {
+		result := parser.NewRawResult(cmd)
+		result.Host = "rexectest"
+		result.ExitCode = -1
+		result.Err = err
+		return result, err
+	}
+	if rec == nil {
+		result := parser.NewRawResult(cmd)
+		result.Host = "rexectest"
+		return result, nil
+	}
+
+	result, err := rec.RawResult()
+	if err != nil {
+		return nil, fmt.Errorf("replay %q: %w", line, err)
+	}
+	result.CmdPtr = cmd
+
+	if r.cfg.timing && result.Duration > 0 {
+		t := time.NewTimer(result.Duration)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			result.ExitCode = -1
+			result.Err = ctx.Err()
+			return result, result.Err
+		case <-t.C:
+		}
+	}
+
+	if result.Err == nil && cmd.Parser != nil && dst != nil {
+		if parseErr := cmd.Parser.Parse(result, dst); parseErr != nil {
+			result.Err = fmt.Errorf("parse error: %w", parseErr)
+		}
+	}
+	return result, result.Err
+}
+
+// take picks the record answering line. It returns nil, nil for an
+// unrecorded command that is allowed to succeed
+func (r *Replay[O]) take(line string) (*parser.ResultRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cfg.mode == ReplayStrict {
+		if r.next >= len(r.records) {
+			r.unrec = append(r.unrec, line)
+			return nil, fmt.Errorf("%w: %q (recording exhausted)", ErrUnrecordedCommand, line)
+		}
+		rec := r.records[r.next]
+		if rec.Command != line {
+			return nil, fmt.Errorf("%w: got %q, want %q", ErrReplayOrder, line, rec.Command)
+		}
+		r.served[r.next] = true
+		r.next++
+		return rec, nil
+	}
+
+	key := normalizeLine(line)
+	last := -1
+	for i, rec := range r.records {
+		if normalizeLine(rec.Command) != key {
+			continue
+		}
+		if !r.served[i] {
+			r.served[i] = true
+			return rec, nil
+		}
+		last = i
+	}
+	if last >= 0 {
+		return r.records[last], nil
+	}
+
+	r.unrec = append(r.unrec, line)
+	if r.cfg.failOnUnrecorded {
+		return nil, fmt.Errorf("%w: %q", ErrUnrecordedCommand, line)
+	}
+	return nil, nil
+}
+
+// Unused returns command lines of records that were never replayed
+func (r *Replay[O]) Unused() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var lines []string
+	for i, rec := range r.records {
+		if !r.served[i] {
+			lines = append(lines, rec.Command)
+		}
+	}
+	return lines
+}
+
+// Unrecorded returns command lines that had no recording, in call order
+func (r *Replay[O]) Unrecorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.unrec...)
+}
+
+// AssertReplayed fails t if some records were never replayed or some commands were not recorded
+func (r *Replay[O]) AssertReplayed(t testing.TB) {
+	t.Helper()
+	if unused := r.Unused(); len(unused) > 0 {
+		t.Errorf("rexectest: %d recorded command(s) never replayed: %q", len(unused), unused)
+	}
+	if unrec := r.Unrecorded(); len(unrec) > 0 {
+		t.Errorf("rexectest: %d command(s) not in the recording: %q", len(unrec), unrec)
+	}
+}
+
+// Close is a no-op
+func (r *Replay[O]) Close() error {
+	return nil
+}
+
+// normalizeLine collapses whitespace runs so lenient matching ignores formatting differences
+func normalizeLine(line string) string {
+	return strings.Join(strings.Fields(line), " ")
+}
@@ -0,0 +1,196 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexectest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/parser/examples"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+// tokenRedactor masks the token recorded by recordSession
+var tokenRedactor = parser.RedactPattern(regexp.MustCompile(`token=(\S+)`))
+
+// recordSession records a small session against a fake staging host
+func recordSession(t *testing.T) []byte {
+	t.Helper()
+	staging := NewClient[string]()
+	staging.On(Exact("test -f /etc/app.conf && echo true || echo false")).Stdout("true\n")
+	staging.On(Exact("systemctl restart app")).ExitCode(3).Stderr("unit not found").Once()
+	staging.On(Exact("systemctl restart app")).Stdout("ok\n")
+	staging.On(Regex(`^cat `)).Stdout("\x00\xffbinary")
+
+	var fixtures bytes.Buffer
+	rec := Record[string](staging, &fixtures, tokenRedactor)
+	ctx := context.Background()
+	_ = rexec.RunNoResult[string](ctx, rec, command.New("test -f /etc/app.conf && echo true || echo false"))
+	_ = rexec.RunNoResult[string](ctx, rec, command.New("systemctl restart app"))
+	_ = rexec.RunNoResult[string](ctx, rec, command.New("systemctl restart app"))
+	_ = rexec.RunNoResult[string](ctx, rec, command.New("cat /bin/x token=abc"))
+	return fixtures.Bytes()
+}
+
+func TestRecordReplay(t *testing.T) {
+	fixtures := recordSession(t)
+	if bytes.Contains(fixtures, []byte("token=abc")) {
+		t.Fatalf("fixtures contain the secret: %s", fixtures)
+	}
+
+	records, err := ReadFixtures(bytes.NewReader(fixtures))
+	if err != nil || len(records) != 4 {
+		t.Fatalf("ReadFixtures = %d records, %v; want 4", len(records), err)
+	}
+
+	replay := NewReplay[string](records, WithReplayRedactors(tokenRedactor))
+	ctx := context.Background()
+
+	exists, err := rexec.RunParse[string, bool](ctx, replay, command.New(
+		"test -f %s && echo true || echo false",
+		command.WithArgs("/etc/app.conf"),
+		command.WithParser(&examples.BoolParser{}),
+	))
+	if err != nil || !exists {
+		t.Errorf("RunParse = %v, %v; want true, nil", exists, err)
+	}
+
+	_, stderr, code, err := rexec.RunRaw[string](ctx, replay, command.New("systemctl  restart app"))
+	if err == nil || code != 3 || stderr != "unit not found" {
+		t.Errorf("first restart = %q, %d, %v; want recorded failure", stderr, code, err)
+	}
+	for i := 0; i < 2; i++ {
+		stdout, _, _, err := rexec.RunRaw[string](ctx, replay, command.New("systemctl restart app"))
+		if err != nil || stdout != "ok\n" {
+			t.Errorf("restart %d = %q, %v; want recorded success", i+2, stdout, err)
+		}
+	}
+
+	rr, err := replay.Run(ctx, command.New("cat /bin/x token=abc"), nil)
+	if err != nil || !bytes.Equal(rr.StdoutBytes, []byte("\x00\xffbinary")) {
+		t.Errorf("binary replay = %q, %v", rr.StdoutBytes, err)
+	}
+
+	rr, err = replay.Run(ctx, command.New("uptime"), nil)
+	if err != nil || rr.Stdout != "" || rr.ExitCode != 0 {
+		t.Errorf("unrecorded lenient = %+v, %v; want empty success", rr, err)
+	}
+
+	tb := &recordingTB{TB: t}
+	replay.AssertReplayed(tb)
+	if len(tb.errors) != 1 {
+		t.Errorf("reported %d failures; want 1 (uptime unrecorded): %q", len(tb.errors), tb.errors)
+	}
+}
+
+func TestReplayModes(t *testing.T) {
+	records := []*parser.ResultRecord{
+		{Version: 1, Command: "uname -s", Stdout: "Linux\n"},
+		{Version: 1, Command: "hostname", Stdout: "web-1\n"},
+	}
+
+	tests := []struct {
+		name    string
+		opts    []ReplayOption
+		cmds    []string
+		wantErr error
+	}{
+		{"lenient_any_order", nil, []string{"hostname", "uname -s"}, nil},
+		{"lenient_whitespace", nil, []string{" uname   -s "}, nil},
+		{"lenient_fail_unrecorded", []ReplayOption{FailOnUnrecorded()}, []string{"uname -s", "id"}, ErrUnrecordedCommand},
+		{"strict_in_order", []ReplayOption{WithReplayMode(ReplayStrict)}, []string{"uname -s", "hostname"}, nil},
+		{"strict_out_of_order", []ReplayOption{WithReplayMode(ReplayStrict)}, []string{"hostname"}, ErrReplayOrder},
+		{"strict_whitespace", []ReplayOption{WithReplayMode(ReplayStrict)}, []string{"uname  -s"}, ErrReplayOrder},
+		{"strict_exhausted", []ReplayOption{WithReplayMode(ReplayStrict)}, []string{"uname -s", "hostname", "uname -s"}, ErrUnrecordedCommand},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			replay := NewReplay[string](records, tc.opts...)
+			var err error
+			for _, line := range tc.cmds {
+				if _, err = replay.Run(context.Background(), command.New(line), nil); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("err = %v; want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplayRedactors(t *testing.T) {
+	records, err := ReadFixtures(bytes.NewReader(recordSession(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []ReplayOption
+		wantErr error
+	}{
+		{"same_redactors", []ReplayOption{WithReplayRedactors(tokenRedactor)}, nil},
+		{"no_redactors", nil, ErrUnrecordedCommand},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			replay := NewReplay[string](records, append(tc.opts, FailOnUnrecorded())...)
+			_, err := replay.Run(context.Background(), command.New("cat /bin/x token=abc"), nil)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("err = %v; want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplay_ResultsCopied(t *testing.T) {
+	records := []*parser.ResultRecord{{Version: 1, Command: "env", Stdout: "A=1\n", EnvKeys: []string{"A"}}}
+	replay := NewReplay[string](records)
+	ctx := context.Background()
+
+	for i := range 2 {
+		rr, err := replay.Run(ctx, command.New("env"), nil)
+		if err != nil || string(rr.StdoutBytes) != "A=1\n" || len(rr.EnvKeys) != 1 || rr.EnvKeys[0] != "A" {
+			t.Fatalf("run %d = %q, %v, %v; want the recorded result", i+1, rr.StdoutBytes, rr.EnvKeys, err)
+		}
+		rr.StdoutBytes[0], rr.EnvKeys[0] = 'X', "X"
+	}
+
+	if _, err := replay.Run(ctx, nil, nil); !errors.Is(err, utils.ErrCommandNil) {
+		t.Errorf("Run(nil) err = %v; want %v", err, utils.ErrCommandNil)
+	}
+}
+
+func TestLoadReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(path, recordSession(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	replay, err := LoadReplay[string](path, WithReplayMode(ReplayStrict))
+	if err != nil {
+		t.Fatalf("LoadReplay: %v", err)
+	}
+	stdout, _, _, err := rexec.RunRaw[string](context.Background(), replay, command.New("test -f /etc/app.conf && echo true || echo false"))
+	if err != nil || stdout != "true\n" {
+		t.Errorf("replay = %q, %v", stdout, err)
+	}
+
+	if _, err := ReadFixtures(strings.NewReader(`{"version":99,"command":"x"}`)); err == nil {
+		t.Error("expected error for a newer record version")
+	}
+	if _, err := LoadReplay[string](filepath.Join(t.TempDir(), "missing.jsonl")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexectest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ngrsoftlab/rexec"
+)
+
+// interface guards: ensure Transfer satisfies rexec.FileTransfer, rexec.CheckedTransfer, rexec.DirTransfer and rexec.FileFetcher for any option type
+var (
+	_ rexec.FileTransfer[any]    = (*Transfer[any])(nil)
+	_ rexec.CheckedTransfer[any] = (*Transfer[any])(nil)
+	_ rexec.DirTransfer[any]     = (*Transfer[any])(nil)
+	_ rexec.FileFetcher[any]     = (*Transfer[any])(nil)
+)
+
+// CopiedFile is one file recorded by Transfer
+type CopiedFile[O any] struct {
+	Spec    rexec.FileSpec // spec as passed to Copy (Content points to the caller's value)
+	Path    string         // TargetDir joined with Filename
+	Data    []byte         // content read from the spec
+	Options []O            // options as passed to Copy
+}
+
+// Transfer is a fake rexec.FileTransfer that keeps copied files in memory.
+// It is safe for concurrent use
+type Transfer[O any] struct {
+	mu     sync.Mutex
+	copies []CopiedFile[O]
+	files  map[string][]byte
+	errs   map[string]error
+}
+
+// NewTransfer creates an empty Transfer
+func NewTransfer[O any]() *Transfer[O] {
+	return &Transfer[O]{
+		files: make(map[string][]byte),
+		errs:  make(map[string]error),
+	}
+}
+
+// FailOn makes Copy and Fetch return err for the file at filePath (TargetDir joined with Filename)
+func (t *Transfer[O]) FailOn(filePath string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.errs[filePath] = err
+}
+
+// Copy validates spec, reads its content and stores it under TargetDir/Filename
+func (t *Transfer[O]) Copy(ctx context.Context, spec *rexec.FileSpec, opts ...O) error {
+	_, err := t.CopyChecked(ctx, spec, opts...)
+	return err
+}
+
+// CopyChecked is Copy that reports whether the file changed. With spec.SkipUnchanged
+// content equal to the stored file is not recorded again; with spec.Backup the stored
+// file is kept under rexec.BackupName. Owner, group and times are only recorded in Spec
+func (t *Transfer[O]) CopyChecked(ctx context.Context, spec *rexec.FileSpec, opts ...O) (*rexec.CopyResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	filePath := path.Join(spec.TargetDir, spec.Filename)
+
+	t.mu.Lock()
+	failErr := t.errs[filePath]
+	t.mu.Unlock()
+	if failErr != nil {
+		return nil, failErr
+	}
+
+	reader, _, err := spec.Content.Stream()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read content: %w", err)
+	}
+
+	res := &rexec.CopyResult{Changed: true, Bytes: int64(len(data))}
+	if spec.NeedsChecksum() {
+		sum := sha256.Sum256(data)
+		res.Checksum = hex.EncodeToString(sum[:])
+		res.Verified = spec.Verify
+	}
+	if spec.SkipUnchanged {
+		if current, ok := t.File(filePath); ok && bytes.Equal(current, data) {
+			return &rexec.CopyResult{Checksum: res.Checksum}, nil
+		}
+	}
+	if spec.Backup {
+		if current, ok := t.File(filePath); ok {
+			t.Put(path.Join(spec.TargetDir, rexec.BackupName(spec.Filename, time.Now())), current)
+		}
+	}
+	if err := t.store(ctx, spec, filePath, data, opts); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// store records one copied file unless FailOn was set for filePath
+func (t *Transfer[O]) store(ctx context.Context, spec *rexec.FileSpec, filePath string, data []byte, opts []O) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.errs[filePath]; err != nil {
+		return err
+	}
+	t.copies = append(t.copies, CopiedFile[O]{
+		Spec:    *spec,
+		Path:    filePath,
+		Data:    data,
+		Options: append([]O(nil), opts...),
+	})
+	t.files[filePath] = data
+	return nil
+}
+
+// CopyDir walks spec and stores every regular file as if it was passed to Copy.
+// Directories and symlinks are not recorded
+func (t *Transfer[O]) CopyDir(ctx context.Context, spec *rexec.DirSpec, opts ...O) error {
+	entries, err := spec.Walk()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || e.IsSymlink() {
+			continue
+		}
+		filePath := path.Join(spec.TargetDir, e.Path)
+		fileSpec := &rexec.FileSpec{
+			TargetDir:  path.Dir(filePath),
+			Filename:   path.Base(filePath),
+			Mode:       e.Mode.Perm(),
+			FolderMode: spec.FolderMode,
+			Content:    &rexec.FileContent{},
+		}
+		reader, err := e.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", e.Path, err)
+		}
+		fileSpec.Content.Data = data
+		if err := t.store(ctx, fileSpec, filePath, data, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put stores data at filePath as if it was copied, so that Fetch can return it
+func (t *Transfer[O]) Put(filePath string, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.files[path.Clean(filePath)] = append([]byte(nil), data...)
+}
+
+// Fetch writes the content stored at spec.RemotePath (by Copy, CopyDir or Put) to
+// the destination of spec. Unknown paths fail with an error wrapping fs.ErrNotExist
+func (t *Transfer[O]) Fetch(ctx context.Context, spec *rexec.FetchSpec, opts ...O) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	filePath := path.Clean(spec.RemotePath)
+
+	t.mu.Lock()
+	failErr := t.errs[filePath]
+	data, ok := t.files[filePath]
+	t.mu.Unlock()
+	if failErr != nil {
+		return failErr
+	}
+	if !ok {
+		return fmt.Errorf("fetch %s: %w", filePath, fs.ErrNotExist)
+	}
+
+	_, err := spec.Write(0, time.Time{}, time.Time{}, func(w io.Writer) (int64, error) {
+		n, err := w.Write(data)
+		return int64(n), err
+	})
+	return err
+}
+
+// Copies returns every successful Copy in call order
+func (t *Transfer[O]) Copies() []CopiedFile[O] {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]CopiedFile[O](nil), t.copies...)
+}
+
+// File returns the last content copied to filePath
+func (t *Transfer[O]) File(filePath string) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data, ok := t.files[filePath]
+	return data, ok
+}
+
+// Paths returns the sorted paths of all copied files
+func (t *Transfer[O]) Paths() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Sorted(maps.Keys(t.files))
+}
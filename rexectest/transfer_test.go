@@ -0,0 +1,153 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexectest
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ngrsoftlab/rexec"
+)
+
+func TestTransfer_Copy(t *testing.T) {
+	errDenied := errors.New("permission denied")
+	tr := NewTransfer[string]()
+	tr.FailOn("/etc/shadow", errDenied)
+
+	ctx := context.Background()
+	specs := []*rexec.FileSpec{
+		{TargetDir: "/etc/app", Filename: "app.conf", Mode: 0o644, Content: &rexec.FileContent{Data: []byte("port=80")}},
+		{TargetDir: "/opt/app", Filename: "run.sh", Mode: 0o755, Content: &rexec.FileContent{Reader: strings.NewReader("#!/bin/sh")}},
+	}
+	for _, spec := range specs {
+		if err := tr.Copy(ctx, spec, "opt"); err != nil {
+			t.Fatalf("Copy(%s) error = %v", spec.Filename, err)
+		}
+	}
+
+	if err := tr.Copy(ctx, &rexec.FileSpec{TargetDir: "/etc", Filename: "shadow", Content: &rexec.FileContent{Data: []byte("x")}}); !errors.Is(err, errDenied) {
+		t.Errorf("FailOn err = %v; want %v", err, errDenied)
+	}
+	if err := tr.Copy(ctx, &rexec.FileSpec{TargetDir: "/etc"}); err == nil {
+		t.Error("invalid spec: want error")
+	}
+
+	if got := tr.Paths(); !reflect.DeepEqual(got, []string{"/etc/app/app.conf", "/opt/app/run.sh"}) {
+		t.Errorf("Paths() = %v", got)
+	}
+	if data, ok := tr.File("/opt/app/run.sh"); !ok || string(data) != "#!/bin/sh" {
+		t.Errorf("File(run.sh) = %q, %v; want script content", data, ok)
+	}
+	copies := tr.Copies()
+	if len(copies) != 2 || copies[1].Spec.Mode != 0o755 || len(copies[0].Options) != 1 {
+		t.Errorf("Copies() = %+v; want 2 copies with modes and options", copies)
+	}
+}
+
+func TestTransfer_CopyDir(t *testing.T) {
+	errDenied := errors.New("permission denied")
+	tr := NewTransfer[string]()
+	tr.FailOn("/srv/app/secret.key", errDenied)
+
+	src := fstest.MapFS{
+		"app.conf":     {Data: []byte("port=80"), Mode: 0o640},
+		"bin/run.sh":   {Data: []byte("#!/bin/sh"), Mode: 0o755},
+		"bin/run.bak":  {Data: []byte("old"), Mode: 0o644},
+		"secret.key":   {Data: []byte("k"), Mode: 0o600},
+		"data/.keep":   {Mode: 0o644},
+		"data/sub/dir": {Mode: fs.ModeDir | 0o755},
+	}
+	ctx := context.Background()
+	spec := &rexec.DirSpec{Source: src, TargetDir: "/srv/app", Exclude: []string{"*.bak", "secret.key"}}
+	if err := tr.CopyDir(ctx, spec, "opt"); err != nil {
+		t.Fatalf("CopyDir error = %v", err)
+	}
+	if got := tr.Paths(); !reflect.DeepEqual(got, []string{"/srv/app/app.conf", "/srv/app/bin/run.sh", "/srv/app/data/.keep"}) {
+		t.Errorf("Paths() = %v", got)
+	}
+	copies := tr.Copies()
+	if len(copies) != 3 || copies[0].Spec.Mode != 0o640 || copies[1].Spec.TargetDir != "/srv/app/bin" || len(copies[1].Options) != 1 {
+		t.Errorf("Copies() = %+v; want 3 copies with modes, dirs and options", copies)
+	}
+
+	spec.Exclude = nil
+	if err := tr.CopyDir(ctx, spec); !errors.Is(err, errDenied) {
+		t.Errorf("FailOn err = %v; want %v", err, errDenied)
+	}
+}
+
+func TestTransfer_Fetch(t *testing.T) {
+	errDenied := errors.New("permission denied")
+	tr := NewTransfer[string]()
+	tr.Put("/var/log/app.log", []byte("started"))
+	tr.Put("/etc/shadow", []byte("x"))
+	tr.FailOn("/etc/shadow", errDenied)
+	ctx := context.Background()
+
+	if err := tr.Copy(ctx, &rexec.FileSpec{TargetDir: "/etc/app", Filename: "app.conf", Content: &rexec.FileContent{Data: []byte("port=80")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote string
+		want   string
+		err    error
+	}{
+		{"/var/log/app.log", "started", nil},
+		{"/etc/app/../app/app.conf", "port=80", nil},
+		{"/etc/shadow", "", errDenied},
+		{"/nope", "", fs.ErrNotExist},
+	}
+	for _, tc := range tests {
+		var buf strings.Builder
+		err := tr.Fetch(ctx, &rexec.FetchSpec{RemotePath: tc.remote, Writer: &buf})
+		if !errors.Is(err, tc.err) || buf.String() != tc.want {
+			t.Errorf("Fetch(%s) = %q, %v; want %q, %v", tc.remote, buf.String(), err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestTransfer_CopyChecked(t *testing.T) {
+	tr := NewTransfer[string]()
+	ctx := context.Background()
+	spec := func(data string) *rexec.FileSpec {
+		return &rexec.FileSpec{TargetDir: "/etc/app", Filename: "app.conf", SkipUnchanged: true,
+			Content: &rexec.FileContent{Data: []byte(data)}}
+	}
+
+	for i, step := range []struct {
+		data        string
+		wantChanged bool
+	}{{"v1", true}, {"v1", false}, {"v2", true}} {
+		res, err := tr.CopyChecked(ctx, spec(step.data))
+		if err != nil || res.Changed != step.wantChanged || len(res.Checksum) != 64 {
+			t.Errorf("step %d: CopyChecked = %+v, %v; want changed %v", i, res, err, step.wantChanged)
+		}
+	}
+	if copies := tr.Copies(); len(copies) != 2 {
+		t.Errorf("Copies() = %d; want 2 (unchanged copy not recorded)", len(copies))
+	}
+}
+
+func TestTransfer_CopyBackup(t *testing.T) {
+	tr := NewTransfer[string]()
+	ctx := context.Background()
+	tr.Put("/etc/app/app.conf", []byte("old"))
+	if err := tr.Copy(ctx, &rexec.FileSpec{TargetDir: "/etc/app", Filename: "app.conf", Backup: true,
+		Content: &rexec.FileContent{Data: []byte("new")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := tr.Paths()
+	if len(paths) != 2 || paths[0] != "/etc/app/app.conf" || !strings.HasPrefix(paths[1], "/etc/app/app.conf.bak.") {
+		t.Fatalf("Paths() = %v; want the file and one backup", paths)
+	}
+	if data, _ := tr.File(paths[1]); string(data) != "old" {
+		t.Errorf("backup = %q; want old", data)
+	}
+}
@@ -3,17 +3,19 @@
 package ssh
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"net"
+	"log/slog"
+	"maps"
+	"path"
 	"regexp"
-	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ngrsoftlab/rexec"
@@ -26,6 +28,13 @@ import (
 // interface guard: ensure Client satisfies rexec.Client[RunOption]
 var _ rexec.Client[RunOption] = (*Client)(nil)
 
+// workdirExitCode is the exit status of the cd prologue when the working directory is missing;
+// together with workdirMarker as the only output it tells a missing directory from a failing command
+const (
+	workdirExitCode = 97
+	workdirMarker   = "rexec: cannot change directory to "
+)
+
 // Client runs shell commands over an SSH connection
 type Client struct {
 	cfg    *Config       // SSH connection settings
@@ -36,32 +45,17 @@ type Client struct {
 	keepAliveChan  chan struct{}         // signals keepalive goroutine to stop
 	sessionLimiter chan struct{}         // limits concurrent sessions
 	mapper         *utils.ExitCodeMapper // maps exit codes to messages
+	sessionSeq     atomic.Uint64         // last session ID handed out
 }
 
 // NewClient dials the SSH server using cfg, retrying on failure,
-// and starts a keepalive loop. Returns an SSH Client or error
+// and starts a keepalive loop. It is NewClientContext with context.Background()
 func NewClient(cfg *Config) (*Client, error) {
+	return NewClientContext(context.Background(), cfg)
+}
 
-	sshCfg, err := cfg.ClientConfig()
-	if err != nil {
-		return nil, fmt.Errorf("build client config: %w", err)
-	}
-
-	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
-	var conn *gossh.Client
-	var lastErr error
-
-	for i := 0; i <= cfg.retryCount; i++ {
-		conn, lastErr = gossh.Dial("tcp", addr, sshCfg)
-		if lastErr == nil {
-			break
-		}
-		time.Sleep(cfg.retryInterval)
-	}
-	if lastErr != nil {
-		return nil, fmt.Errorf("dial failed: %w", lastErr)
-	}
-
+// newClient wraps an established connection and starts the keepalive loop
+func newClient(cfg *Config, conn *gossh.Client) *Client {
 	cl := &Client{
 		cfg:            cfg,
 		client:         conn,
@@ -69,10 +63,8 @@ func NewClient(cfg *Config) (*Client, error) {
 		keepAliveChan:  make(chan struct{}),
 		sessionLimiter: make(chan struct{}, cfg.maxSessions),
 	}
-
 	go cl.keepalive()
-
-	return cl, nil
+	return cl
 }
 
 // keepalive periodically sends a no-op request to keep the TCP connection alive
@@ -83,8 +75,11 @@ func (cl *Client) keepalive() {
 		select {
 		case <-t.C:
 			cl.mu.Lock()
-			_, _, _ = cl.client.Conn.SendRequest("keepalive@openssh.com", false, nil)
+			_, _, err := cl.client.Conn.SendRequest("keepalive@openssh.com", false, nil)
 			cl.mu.Unlock()
+			if err != nil {
+				cl.cfg.log().Warn("keepalive failed", slog.String("host", cl.cfg.Host), slog.String("error", err.Error()))
+			}
 		case <-cl.keepAliveChan:
 			return
 		}
@@ -94,55 +89,92 @@ func (cl *Client) keepalive() {
 // Session wraps gossh.Session to release a session slot when closed
 type Session struct {
 	*gossh.Session
-	client *Client // parent client to signal limiter
+	ID        uint64    // per-client session number, used in logs
+	client    *Client   // parent client to signal limiter
+	closeOnce sync.Once // releases the slot only once
+	done      func()    // decrements the active sessions metric
 }
 
 // Close closes the SSH session and frees a slot in sessionLimiter
 func (w *Session) Close() error {
 	err := w.Session.Close()
-	<-w.client.sessionLimiter
+	w.closeOnce.Do(func() {
+		w.client.cfg.log().Debug("session closed", slog.String("host", w.client.cfg.Host), slog.Uint64("session", w.ID))
+		w.done()
+		<-w.client.sessionLimiter
+	})
 	return err
 }
 
 // OpenSession acquires a session slot, opens a new SSH session, or returns an error
-func (cl *Client) OpenSession(ctx context.Context) (*Session, error) {
+func (cl *Client) OpenSession(ctx context.Context) (sess *Session, err error) {
+	tel := cl.cfg.telemetry
+	ctx, span := tel.Start(ctx, "ssh.session.open", rexec.AttrHost.String(cl.cfg.Host))
+	defer func() { rexec.End(span, err) }()
+
+	_, wait := tel.Start(ctx, "ssh.session.wait")
 	select {
 	case cl.sessionLimiter <- struct{}{}:
+		rexec.End(wait, nil)
 	case <-ctx.Done():
+		rexec.End(wait, ctx.Err())
 		return nil, ctx.Err()
 	}
 
 	cl.mu.Lock()
-	sess, err := cl.client.NewSession()
+	gsess, err := cl.client.NewSession()
 	cl.mu.Unlock()
 	if err != nil {
 		<-cl.sessionLimiter
+		cl.cfg.log().Warn("session open failed", slog.String("host", cl.cfg.Host), slog.String("error", err.Error()))
 		return nil, err
 	}
 
-	return &Session{Session: sess, client: cl}, nil
+	id := cl.sessionSeq.Add(1)
+	cl.cfg.log().Debug("session opened", slog.String("host", cl.cfg.Host), slog.Uint64("session", id))
+	return &Session{Session: gsess, ID: id, client: cl, done: tel.SessionOpened(ctx, cl.cfg.Host)}, nil
 }
 
 // Run executes cmd on the remote host, captures stdout/stderr, exit code, and duration,
 // and applies cmd.Parser to dst if provided
-func (cl *Client) Run(ctx context.Context, cmd *command.Command, dst any, opts ...RunOption) (*parser.RawResult, error) {
+func (cl *Client) Run(ctx context.Context, cmd *command.Command, dst any, opts ...RunOption) (result *parser.RawResult, err error) {
 	if cl == nil || cl.client == nil {
+		if events := runEvents(opts); events != nil {
+			rexec.EmitExit(ctx, events, nil, utils.ErrSessionNotOpen)
+		}
 		return nil, utils.ErrSessionNotOpen
 	}
 
-	result := parser.NewRawResult(cmd)
+	result = parser.NewRawResult(cmd)
 
-	var err error
-	defer cl.recoverSession(result, &err)
+	var sessID uint64
+	ctx, finishSpan := cl.cfg.telemetry.StartRun(ctx, "ssh.run", cmd, cl.cfg.Host, cl.cfg.redactors()...)
+	defer func() {
+		finishSpan(result, err)
+		rexec.LogRun(ctx, cl.cfg.log(), cmd, result, err, cl.cfg.redactors(), slog.Uint64("session", sessID))
+	}()
 
-	runCfg := newRunConfig(cl.cfg.remoteWorkdir, cl.cfg.envVars, opts...)
+	var runCfg *runConfig
+	defer func() { runCfg.emitExit(ctx, result, err) }()
+	defer rexec.RecoverRun(result, &err)
+
+	runCfg = newRunConfig(cl.cfg.remoteWorkdir, cl.cfg.envVars,
+		append([]RunOption{WithRunEnvMode(cl.cfg.envMode)}, opts...)...)
 	runCfg.usePTY = cl.requiresPTY(cmd.String())
+	runCfg.attachEvents(ctx)
+
+	result.Host = cl.cfg.Host
+	result.User = cl.cfg.User
+	result.PTY = runCfg.usePTY
+	result.WorkDir = runCfg.dir
+	result.EnvKeys = slices.Sorted(maps.Keys(runCfg.env))
 
 	sess, err := cl.OpenSession(ctx)
 	if err != nil {
 		return result, fmt.Errorf("open session: %w", err)
 	}
 	defer sess.Close()
+	sessID = sess.ID
 
 	if err := cl.requestPTY(sess.Session, runCfg); err != nil {
 		return result, err
@@ -161,11 +193,19 @@ func (cl *Client) Run(ctx context.Context, cmd *command.Command, dst any, opts .
 		return result, fmt.Errorf("get stdin pipe: %w", err)
 	}
 
-	cmdStr := cmd.String()
-	for k, v := range runCfg.env {
-		cmdStr = fmt.Sprintf("export %s=%q; %s", k, v, cmdStr)
+	cmdStr, err := cl.applyEnv(sess, cmd.String(), runCfg)
+	if err != nil {
+		return result, err
 	}
+	cmdStr = workdirCommand(cmdStr, runCfg.dir)
 
+	cl.cfg.log().Debug("command started",
+		slog.String("host", cl.cfg.Host),
+		slog.String("cmd", rexec.RedactString(cmd.String(), cl.cfg.redactors())),
+		slog.Uint64("session", sessID),
+		slog.Bool("pty", runCfg.usePTY),
+	)
+	result.MarkStarted()
 	if err := sess.Start(cmdStr); err != nil {
 		return result, fmt.Errorf("start command: %w", err)
 	}
@@ -173,9 +213,14 @@ func (cl *Client) Run(ctx context.Context, cmd *command.Command, dst any, opts .
 	var wg sync.WaitGroup
 	wg.Add(2)
 
+	// a PTY merges stderr into stdout, so the error tail follows stdout instead
+	stdout := runCfg.stdout
+	if runCfg.usePTY {
+		stdout = io.MultiWriter(stdout, runCfg.errTail)
+	}
 	go func() {
 		defer wg.Done()
-		cl.handleStdout(stdoutPipe, stdinPipe, runCfg.stdout)
+		cl.handleStdout(stdoutPipe, stdinPipe, stdout)
 	}()
 
 	go func() {
@@ -201,6 +246,8 @@ func (cl *Client) Run(ctx context.Context, cmd *command.Command, dst any, opts .
 	case <-ctx.Done():
 		sess.Close()
 		wg.Wait()
+		result.MarkFinished()
+		_ = runCfg.fillOutput(result)
 		err = ctx.Err()
 		result.Err = err
 		result.ExitCode = -1
@@ -208,20 +255,28 @@ func (cl *Client) Run(ctx context.Context, cmd *command.Command, dst any, opts .
 
 	case e := <-done:
 		wg.Wait()
-		result.Stdout = runCfg.bufOut.String()
-		result.Stderr = runCfg.bufErr.String()
+		result.MarkFinished()
+		captureErr := runCfg.fillOutput(result)
 
 		var exitErr *gossh.ExitError
 		if errors.As(e, &exitErr) {
 			code := exitErr.ExitStatus()
-			msg := cl.mapper.Lookup(code)
-			err = fmt.Errorf("remote command failed (%s): %s: %w", msg, result.Stderr, e)
+			if workdirMissing(code, runCfg.dir, runCfg.errTail.Summary()) {
+				err = fmt.Errorf("%w: %s on %s", utils.ErrWorkdirMissing, runCfg.dir, cl.cfg.Host)
+			} else {
+				msg := cl.mapper.Lookup(code)
+				err = fmt.Errorf("remote command failed (%s): %s: %w", msg, runCfg.errTail.Summary(), e)
+			}
 			result.Err = err
 			result.ExitCode = code
 		} else if e != nil {
 			err = e
 			result.Err = e
 			result.ExitCode = -1
+		} else if captureErr != nil {
+			err = fmt.Errorf("capture output: %w", captureErr)
+			result.Err = err
+			result.ExitCode = 0
 		} else {
 			err = nil
 			result.ExitCode = 0
@@ -237,6 +292,62 @@ func (cl *Client) Run(ctx context.Context, cmd *command.Command, dst any, opts .
 	return result, result.Err
 }
 
+// applyEnv sends runCfg.env through SSH "env" requests and returns the command line to run.
+// Variables rejected by sshd (no matching AcceptEnv) and all variables in EnvClear mode
+// are passed with a POSIX-quoted `env [-i] K='v' sh -c '...'` wrapper instead
+func (cl *Client) applyEnv(sess *Session, line string, runCfg *runConfig) (string, error) {
+	if err := rexec.ValidateEnv(runCfg.env); err != nil {
+		return "", err
+	}
+	if runCfg.envMode == rexec.EnvClear {
+		return rexec.EnvCommand(line, runCfg.env, rexec.EnvClear), nil
+	}
+
+	rejected := make(map[string]string)
+	for _, k := range slices.Sorted(maps.Keys(runCfg.env)) {
+		if err := sess.Setenv(k, runCfg.env[k]); err != nil {
+			rejected[k] = runCfg.env[k]
+		}
+	}
+	if len(rejected) > 0 {
+		cl.cfg.log().Debug("env request rejected, using env wrapper",
+			slog.String("host", cl.cfg.Host),
+			slog.Uint64("session", sess.ID),
+			slog.Any("keys", slices.Sorted(maps.Keys(rejected))),
+		)
+	}
+	return rexec.EnvCommand(line, rejected, rexec.EnvInherit), nil
+}
+
+// workdirCommand prefixes line with a POSIX-quoted cd into dir. The prologue is separated
+// by a newline so that operators in line (&&, ||, &) keep their meaning; if cd fails the
+// command does not run and the shell exits with workdirExitCode. An empty dir returns line
+func workdirCommand(line, dir string) string {
+	if dir == "" {
+		return line
+	}
+	q := command.Quote(dir)
+	return "cd -- " + q + " 2>/dev/null || { printf '%s%s\\n' " + command.Quote(workdirMarker) + " " + q +
+		" >&2; exit " + strconv.Itoa(workdirExitCode) + "; }\n" + line
+}
+
+// workdirMissing reports whether a run failed in the cd prologue of workdirCommand:
+// the shell exited with workdirExitCode and the marker line for dir is all the
+// (stderr, or merged PTY) output. A command that exits 97 after printing exactly
+// that line is indistinguishable
+func workdirMissing(code int, dir, outputTail string) bool {
+	return dir != "" && code == workdirExitCode && outputTail == workdirMarker+dir
+}
+
+// resolvePath joins a relative remote path onto the configured working directory.
+// SFTP has no current directory, so relative paths would otherwise resolve against home
+func (cl *Client) resolvePath(p string) string {
+	if cl.cfg.remoteWorkdir == "" || path.IsAbs(p) {
+		return p
+	}
+	return path.Join(cl.cfg.remoteWorkdir, p)
+}
+
 // Close shuts down keepalive and closes the SSH connection
 func (cl *Client) Close() error {
 	cl.closeOnce.Do(func() {
@@ -257,15 +368,6 @@ func (cl *Client) requiresPTY(shellCmd string) bool {
 
 }
 
-// recoverSession catches panics during Run and records them in result.Err
-func (cl *Client) recoverSession(result *parser.RawResult, err *error) {
-	if r := recover(); r != nil {
-		*err = fmt.Errorf("recovered from panic on run: %v\n%s", r, debug.Stack())
-		result.Err = *err
-		result.ExitCode = -1
-	}
-}
-
 // requestPTY asks the server for a pseudo-terminal if runCfg.usePTY is true
 func (cl *Client) requestPTY(sess *gossh.Session, runCfg *runConfig) error {
 	const (
@@ -291,17 +393,38 @@ func (cl *Client) requestPTY(sess *gossh.Session, runCfg *runConfig) error {
 	return nil
 }
 
-// handleStdout reads lines from stdoutPipe, writes them to stdout writer,
-// and automatically responds to password prompts using sudoPassword
+// passwordPrompt matches sudo/su password prompts in command output
+var passwordPrompt = regexp.MustCompile(`(?i)password[^:\n]*:\s*$`)
+
+// promptWindow is how many trailing output bytes are kept to detect a prompt split across reads
+const promptWindow = 256
+
+// handleStdout copies stdoutPipe to stdout byte for byte and automatically
+// responds to password prompts using sudoPassword
 func (cl *Client) handleStdout(stdoutPipe io.Reader, stdinPipe io.Writer, stdout io.Writer) {
-	passwordPrompt := regexp.MustCompile(`(?i)password\s*:`)
-	scanner := bufio.NewScanner(stdoutPipe)
-	for scanner.Scan() {
-		line := scanner.Text()
-		fmt.Fprintln(stdout, line)
-		if passwordPrompt.MatchString(line) && cl.cfg.sudoPassword != "" {
-			io.WriteString(stdinPipe, "sudo "+cl.cfg.sudoPassword+"\n")
+	buf := bufPool.Get().([]byte)
+	defer bufPool.Put(buf)
+
+	var tail []byte
+	for {
+		n, err := stdoutPipe.Read(buf)
+		if n > 0 {
+			stdout.Write(buf[:n])
+			if cl.cfg.sudoPassword != "" {
+				tail = append(tail, buf[:n]...)
+				if len(tail) > promptWindow {
+					tail = tail[len(tail)-promptWindow:]
+				}
+				if passwordPrompt.Match(tail) {
+					cl.cfg.log().Debug("answering password prompt", slog.String("host", cl.cfg.Host))
+					// the prompt takes the whole line as the password, so nothing else may precede it
+					io.WriteString(stdinPipe, cl.cfg.sudoPassword+"\n")
+					tail = tail[:0]
+				}
+			}
+		}
+		if err != nil {
+			return
 		}
 	}
-
 }
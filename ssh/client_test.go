@@ -0,0 +1,91 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+func TestHandleStdout(t *testing.T) {
+	longLine := strings.Repeat("x", 200<<10)
+	binary := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0xff, 0x00}
+
+	tests := []struct {
+		name      string
+		sudoPass  string
+		input     []byte
+		oneByte   bool
+		wantStdin string
+	}{
+		{"binary", "", binary, false, ""},
+		{"long_line_without_newline", "", []byte(longLine), false, ""},
+		{"crlf_preserved", "", []byte("a\r\nb\r\n"), false, ""},
+		{"no_trailing_newline", "", []byte("last"), false, ""},
+		{"password_prompt", "secret", []byte("[sudo] password for alice: "), false, "secret\n"},
+		{"password_prompt_split", "secret", []byte("[sudo] password for alice: "), true, "secret\n"},
+		{"password_in_output", "secret", []byte("Password: changed\n"), false, ""},
+		{"prompt_without_sudo_password", "", []byte("Password: "), false, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cl := &Client{cfg: &Config{sudoPassword: tc.sudoPass}}
+			var out, stdin bytes.Buffer
+
+			src := bytes.NewReader(tc.input)
+			if tc.oneByte {
+				cl.handleStdout(iotest.OneByteReader(src), &stdin, &out)
+			} else {
+				cl.handleStdout(src, &stdin, &out)
+			}
+
+			if !bytes.Equal(out.Bytes(), tc.input) {
+				t.Errorf("stdout differs from input: got %d bytes, want %d", out.Len(), len(tc.input))
+			}
+			if stdin.String() != tc.wantStdin {
+				t.Errorf("stdin = %q; want %q", stdin.String(), tc.wantStdin)
+			}
+		})
+	}
+}
+
+func TestRun_NotOpenEmitsExit(t *testing.T) {
+	tests := []struct {
+		name string
+		cl   *Client
+	}{
+		{"nil_client", nil},
+		{"closed_client", &Client{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			events := make(chan rexec.StreamEvent, 2)
+			_, err := tc.cl.Run(context.Background(), command.New("true"), nil, WithEvents(events))
+			if !errors.Is(err, utils.ErrSessionNotOpen) {
+				t.Fatalf("err = %v; want %v", err, utils.ErrSessionNotOpen)
+			}
+			close(events)
+
+			var got []rexec.StreamEvent
+			for ev := range events {
+				got = append(got, ev)
+			}
+			if len(got) != 1 || got[0].Stream != rexec.StreamExit || !errors.Is(got[0].Err, utils.ErrSessionNotOpen) {
+				t.Errorf("events = %+v; want one exit event with %v", got, utils.ErrSessionNotOpen)
+			}
+		})
+	}
+}
+
+// interface guard: DryRunClient can stand in for the ssh client
+var _ rexec.Client[RunOption] = rexec.NewDryRunClient[RunOption]("alice@host")
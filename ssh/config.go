@@ -3,11 +3,17 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/utils"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
 )
@@ -16,10 +22,14 @@ const (
 	defaultMaxSessions = 1                // default maximum concurrent SSH sessions
 	defaultRetryCount  = 3                // default number of connection retries
 	defaultTimeout     = 30 * time.Second // default dial timeout
-	defaultRetryDelay  = 5 * time.Second  // default delay between retry attempts
+	defaultRetryDelay  = 5 * time.Second  // default delay before the first retry
+	defaultMaxRetry    = 1 * time.Minute  // default cap of the exponential retry delay
 	defaultKeepAlive   = 30 * time.Second // default TCP keepalive interval
 )
 
+// DialContextFunc opens a connection to addr, e.g. through a proxy or an existing tunnel
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
 // ConfigOption customizes SSH Config settings
 type ConfigOption func(*Config) error
 
@@ -30,13 +40,19 @@ type Config struct {
 	User           string            // *SSH username
 	timeout        time.Duration     //  dial timeout duration
 	retryCount     int               // reconnect attempts
-	retryInterval  time.Duration     // delay between retries
+	retryInterval  time.Duration     // delay before the first retry, doubled for each next one
+	maxRetryDelay  time.Duration     // cap of the retry delay
 	keepAlive      time.Duration     // TCP keepalive interval
 	knownHostsPath string            // path to known_hosts for host key verification
 	sudoPassword   string            // optional: password for sudo operations on remote host
 	envVars        map[string]string // environment variables to set on remote session
+	envMode        rexec.EnvMode     // inherit (default) or clear the remote login environment
 	remoteWorkdir  string            // optional: working directory on the remote host
 	maxSessions    int               // optional: max concurrent sessions per connection
+	telemetry      *rexec.Telemetry  // optional: OpenTelemetry spans and metrics
+	logger         *slog.Logger      // optional: structured logger, secrets are never logged
+	logRedactors   []parser.Redactor // optional: extra redactors for logged and traced command lines
+	dialContext    DialContextFunc   // optional: opens the transport connection instead of net.Dialer
 
 	auth *auth // authentication settings
 }
@@ -51,6 +67,7 @@ func NewConfig(user, host string, port int, opts ...ConfigOption) (*Config, erro
 		timeout:       defaultTimeout,
 		retryCount:    defaultRetryCount,
 		retryInterval: defaultRetryDelay,
+		maxRetryDelay: defaultMaxRetry,
 		keepAlive:     defaultKeepAlive,
 		envVars:       make(map[string]string),
 		auth:          &auth{},
@@ -93,7 +110,8 @@ func WithTimeout(timeout time.Duration) ConfigOption {
 	}
 }
 
-// WithRetry sets connection retry count and interval
+// WithRetry sets the number of connection retries and the delay before the first one.
+// Later delays double up to the cap set by WithMaxRetryDelay and are jittered
 func WithRetry(count int, interval time.Duration) ConfigOption {
 	return func(cfg *Config) error {
 		if count < 0 || interval < 0 {
@@ -105,6 +123,17 @@ func WithRetry(count int, interval time.Duration) ConfigOption {
 	}
 }
 
+// WithMaxRetryDelay caps the exponential delay between connection retries
+func WithMaxRetryDelay(d time.Duration) ConfigOption {
+	return func(cfg *Config) error {
+		if d <= 0 {
+			return fmt.Errorf("max retry delay must be >0")
+		}
+		cfg.maxRetryDelay = d
+		return nil
+	}
+}
+
 // WithKeepAlive sets the TCP keepalive interval
 func WithKeepAlive(keepAlive time.Duration) ConfigOption {
 	return func(cfg *Config) error {
@@ -137,6 +166,18 @@ func WithEnvVars(envVars map[string]string) ConfigOption {
 	}
 }
 
+// WithEnvMode selects whether commands inherit the remote login environment
+// (rexec.EnvInherit, default) or start with only the configured variables (rexec.EnvClear)
+func WithEnvMode(mode rexec.EnvMode) ConfigOption {
+	return func(cfg *Config) error {
+		if mode != rexec.EnvInherit && mode != rexec.EnvClear {
+			return fmt.Errorf("unknown env mode %v", mode)
+		}
+		cfg.envMode = mode
+		return nil
+	}
+}
+
 // WithKnownHosts sets the path to a known_hosts file for host key checking
 func WithKnownHosts(path string) ConfigOption {
 	return func(cfg *Config) error {
@@ -151,7 +192,8 @@ func WithKnownHosts(path string) ConfigOption {
 	}
 }
 
-// WithWorkdir sets the remote working directory
+// WithWorkdir sets the remote working directory. A missing directory is reported
+// as described for WithRunWorkdir
 func WithWorkdir(path string) ConfigOption {
 	return func(cfg *Config) error {
 		if path == "" {
@@ -174,6 +216,43 @@ func WithMaxSessions(maxSessions int) ConfigOption {
 	}
 }
 
+// WithTelemetry enables OpenTelemetry spans and metrics for dial, sessions, runs and transfers
+func WithTelemetry(t *rexec.Telemetry) ConfigOption {
+	return func(cfg *Config) error {
+		if t == nil {
+			return fmt.Errorf("telemetry cannot be nil")
+		}
+		cfg.telemetry = t
+		return nil
+	}
+}
+
+// WithLogger enables structured logging of dials, keepalives, sessions, runs and transfers.
+// Passwords and the sudo password are always masked, in logs and in spans; redactors mask further secrets in command lines
+func WithLogger(logger *slog.Logger, redactors ...parser.Redactor) ConfigOption {
+	return func(cfg *Config) error {
+		if logger == nil {
+			return fmt.Errorf("logger cannot be nil")
+		}
+		cfg.logger = logger
+		cfg.logRedactors = redactors
+		return nil
+	}
+}
+
+// WithDialContext makes the client open its transport connection with dial instead of
+// net.Dialer, e.g. to go through an HTTP CONNECT proxy or an existing tunnel.
+// Retries, handshake timeout and host key checks still apply
+func WithDialContext(dial DialContextFunc) ConfigOption {
+	return func(cfg *Config) error {
+		if dial == nil {
+			return fmt.Errorf("dial function cannot be nil")
+		}
+		cfg.dialContext = dial
+		return nil
+	}
+}
+
 // WithAgentAuth enables SSH agent-based authentication
 func WithAgentAuth() ConfigOption {
 	return func(cfg *Config) error {
@@ -202,6 +281,23 @@ func WithPasswordAuth(password string) ConfigOption {
 	}
 }
 
+// log returns the configured logger or one that discards everything
+func (c *Config) log() *slog.Logger {
+	if c.logger == nil {
+		return utils.NopLogger()
+	}
+	return c.logger
+}
+
+// redactors returns redactors masking configured secrets followed by user redactors
+func (c *Config) redactors() []parser.Redactor {
+	secrets := []string{c.sudoPassword}
+	if c.auth != nil {
+		secrets = append(secrets, c.auth.password, c.auth.passphrase)
+	}
+	return append([]parser.Redactor{parser.RedactValues(secrets...)}, c.logRedactors...)
+}
+
 // validate ensures required Config fields are set correctly
 func (c *Config) validate() error {
 	if len(c.User) == 0 {
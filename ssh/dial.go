@@ -0,0 +1,184 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// NewClientContext dials the SSH server using cfg and starts a keepalive loop.
+// Failed attempts are retried with exponential backoff and jitter (see WithRetry and
+// WithMaxRetryDelay) unless the error is not retryable, such as an authentication or
+// host key failure. ctx bounds dialing, handshakes and waits between attempts.
+// The returned error reports every attempt
+func NewClientContext(ctx context.Context, cfg *Config) (cl *Client, err error) {
+	sshCfg, err := cfg.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("build client config: %w", err)
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	log := cfg.log().With(slog.String("host", addr))
+	ctx, span := cfg.telemetry.Start(ctx, "ssh.dial", rexec.AttrHost.String(addr))
+	defer func() { rexec.End(span, err) }()
+
+	var attemptErrs []error
+	for attempt := 1; ; attempt++ {
+		log.Debug("dialing", slog.Int("attempt", attempt))
+		conn, dialErr := cfg.dialOnce(ctx, addr, sshCfg)
+		if dialErr == nil {
+			log.Info("connected", slog.String("user", cfg.User), slog.Int("attempt", attempt))
+			return newClient(cfg, conn), nil
+		}
+
+		attemptErrs = append(attemptErrs, fmt.Errorf("attempt %d: %w", attempt, dialErr))
+		log.Warn("dial attempt failed", slog.Int("attempt", attempt), slog.String("error", dialErr.Error()))
+		span.AddEvent("dial attempt failed", trace.WithAttributes(
+			rexec.AttrAttempt.Int(attempt),
+			attribute.String("error", dialErr.Error()),
+		))
+
+		if attempt > cfg.retryCount || !isRetryable(dialErr) {
+			break
+		}
+		if waitErr := sleepContext(ctx, backoff(attempt, cfg.retryInterval, cfg.maxRetryDelay)); waitErr != nil {
+			attemptErrs = append(attemptErrs, waitErr)
+			break
+		}
+	}
+
+	err = fmt.Errorf("dial failed after %d attempt(s): %w", len(attemptErrs), errors.Join(attemptErrs...))
+	log.Error("dial failed", slog.Int("attempts", len(attemptErrs)), slog.String("error", err.Error()))
+	return nil, err
+}
+
+// dialOnce opens the transport connection with ctx and performs the SSH handshake.
+// The handshake is bounded by the dial timeout and aborted when ctx is done
+func (c *Config) dialOnce(ctx context.Context, addr string, sshCfg *gossh.ClientConfig) (*gossh.Client, error) {
+	dial := c.dialContext
+	if dial == nil {
+		d := &net.Dialer{Timeout: c.timeout}
+		dial = d.DialContext
+	} else if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return handshake(ctx, conn, addr, sshCfg, c.timeout)
+}
+
+// NewClientFromConn runs the SSH handshake over an already established conn
+// (a tunnel, a proxied connection or one end of net.Pipe) and returns a Client
+// with keepalive and session limiting as usual. cfg.Host and cfg.Port are used
+// for host key checks. conn is closed if the handshake fails
+func NewClientFromConn(conn net.Conn, cfg *Config) (*Client, error) {
+	sshCfg, err := cfg.ClientConfig()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("build client config: %w", err)
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	client, err := handshake(context.Background(), conn, addr, sshCfg, cfg.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("ssh handshake: %w", err)
+	}
+	cfg.log().Info("connected", slog.String("host", addr), slog.String("user", cfg.User))
+	return newClient(cfg, client), nil
+}
+
+// handshake runs the SSH handshake over conn, closing conn on failure
+func handshake(ctx context.Context, conn net.Conn, addr string, sshCfg *gossh.ClientConfig, timeout time.Duration) (*gossh.Client, error) {
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, chans, reqs, err := gossh.NewClientConn(conn, addr, sshCfg)
+	if err != nil {
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, classify(err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return gossh.NewClient(c, chans, reqs), nil
+}
+
+// classify wraps authentication failures with utils.ErrAuthFailed
+func classify(err error) error {
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return fmt.Errorf("%w: %w", utils.ErrAuthFailed, err)
+	}
+	return err
+}
+
+// isRetryable reports whether a dial error may succeed on another attempt.
+// Authentication, host key and context errors are final
+func isRetryable(err error) bool {
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	switch {
+	case errors.Is(err, utils.ErrAuthFailed),
+		errors.As(err, &keyErr),
+		errors.As(err, &revokedErr),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
+}
+
+// backoff returns the wait before retry number attempt: base doubled per attempt,
+// capped at maxDelay, with equal jitter (a random value in [d/2, d])
+func backoff(attempt int, base, maxDelay time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	if maxDelay > 0 && d > maxDelay {
+		d = maxDelay
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// sleepContext waits d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/ssh/sshtest"
+	"github.com/ngrsoftlab/rexec/utils"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempt  int
+		base     time.Duration
+		maxDelay time.Duration
+		min, max time.Duration
+	}{
+		{"first", 1, time.Second, time.Minute, 500 * time.Millisecond, time.Second},
+		{"third", 3, time.Second, time.Minute, 2 * time.Second, 4 * time.Second},
+		{"capped", 10, time.Second, 5 * time.Second, 2500 * time.Millisecond, 5 * time.Second},
+		{"huge_attempt", 200, time.Second, 5 * time.Second, 2500 * time.Millisecond, 5 * time.Second},
+		{"no_base", 3, 0, time.Minute, 0, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				if d := backoff(tc.attempt, tc.base, tc.maxDelay); d < tc.min || d > tc.max {
+					t.Fatalf("backoff = %v; want in [%v, %v]", d, tc.min, tc.max)
+				}
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"handshake_eof", io.EOF, true},
+		{"auth", classify(errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password]")), false},
+		{"host_key", fmt.Errorf("ssh: handshake failed: %w", &knownhosts.KeyError{}), false},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isRetryable(tc.err); got != tc.want {
+				t.Errorf("isRetryable(%v) = %v; want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewClientContextAuthNotRetried(t *testing.T) {
+	srv := sshtest.NewServer(t)
+	cfg, err := NewConfig(sshtest.DefaultUser, srv.Host, srv.Port, WithPasswordAuth("wrong"), WithRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewClientContext(context.Background(), cfg)
+	if !errors.Is(err, utils.ErrAuthFailed) {
+		t.Fatalf("err = %v; want ErrAuthFailed", err)
+	}
+	if srv.Connections() != 1 {
+		t.Errorf("connections = %d; want 1 (no retry on auth failure)", srv.Connections())
+	}
+}
+
+func TestNewClientContextReportsAllAttempts(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	cfg, err := NewConfig("u", "127.0.0.1", port, WithPasswordAuth("p"), WithRetry(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewClientContext(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("expected dial error")
+	}
+	for _, want := range []string{"after 3 attempt(s)", "attempt 1:", "attempt 2:", "attempt 3:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v; want containing %q", err, want)
+		}
+	}
+}
+
+func TestNewClientContextCanceled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	cfg, err := NewConfig("u", "127.0.0.1", port, WithPasswordAuth("p"), WithRetry(5, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = NewClientContext(ctx, cfg)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v; want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("NewClientContext took %v after the context expired", elapsed)
+	}
+}
+
+func TestNewClientContextHandshakeCanceled(t *testing.T) {
+	// a listener that accepts but never speaks SSH
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	cfg, err := NewConfig("u", "127.0.0.1", port, WithPasswordAuth("p"), WithRetry(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := NewClientContext(ctx, cfg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v; want DeadlineExceeded", err)
+	}
+}
+
+func TestNewClientFromConn(t *testing.T) {
+	srv := sshtest.NewServer(t)
+	clientEnd := srv.Pipe()
+
+	cfg, err := NewConfig(sshtest.DefaultUser, "pipe.invalid", 22,
+		WithPasswordAuth(sshtest.DefaultPassword),
+		WithKeepAlive(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := NewClientFromConn(clientEnd, cfg)
+	if err != nil {
+		t.Fatalf("NewClientFromConn: %v", err)
+	}
+	defer cl.Close()
+
+	stdout, _, _, err := rexec.RunRaw[RunOption](context.Background(), cl, command.New("echo over pipe"))
+	if err != nil || stdout != "over pipe\n" {
+		t.Errorf("run = %q, %v", stdout, err)
+	}
+
+	spec := &rexec.FileSpec{
+		TargetDir:  t.TempDir(),
+		Filename:   "f",
+		Mode:       0o600,
+		FolderMode: 0o700,
+		Content:    &rexec.FileContent{Data: []byte("x")},
+	}
+	if err := NewSFTPTransfer(cl).Copy(context.Background(), spec); err != nil {
+		t.Errorf("sftp copy: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Keepalives() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if srv.Keepalives() == 0 {
+		t.Error("no keepalive received over the pipe")
+	}
+}
+
+func TestWithDialContext(t *testing.T) {
+	srv := sshtest.NewServer(t)
+
+	var dialed []string
+	proxy := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		var d net.Dialer
+		return d.DialContext(ctx, network, srv.Addr())
+	}
+	cfg, err := NewConfig(sshtest.DefaultUser, "internal.invalid", 2222,
+		WithPasswordAuth(sshtest.DefaultPassword),
+		WithDialContext(proxy),
+		WithRetry(0, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := NewClientContext(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewClientContext: %v", err)
+	}
+	defer cl.Close()
+
+	if len(dialed) != 1 || dialed[0] != "internal.invalid:2222" {
+		t.Errorf("dialed = %v; want [internal.invalid:2222]", dialed)
+	}
+	if err := rexec.RunNoResult[RunOption](context.Background(), cl, command.New("true")); err != nil {
+		t.Errorf("run: %v", err)
+	}
+
+	if _, err := NewConfig("u", "h", 22, WithDialContext(nil)); err == nil {
+		t.Error("expected error for nil dial function")
+	}
+}
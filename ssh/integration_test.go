@@ -0,0 +1,1212 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/ssh/sshtest"
+	"github.com/ngrsoftlab/rexec/utils"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTestClient starts an sshtest server and connects a Client to it
+func newTestClient(t *testing.T, srvOpts []sshtest.Option, opts ...ConfigOption) (*Client, *sshtest.Server) {
+	t.Helper()
+	srv := sshtest.NewServer(t, srvOpts...)
+
+	opts = append([]ConfigOption{WithPasswordAuth(sshtest.DefaultPassword), WithRetry(0, 0)}, opts...)
+	cfg, err := NewConfig(sshtest.DefaultUser, srv.Host, srv.Port, opts...)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	cl, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return cl, srv
+}
+
+func TestIntegrationRun(t *testing.T) {
+	cl, srv := newTestClient(t, nil)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		cmd        string
+		wantStdout string
+		wantStderr string
+		wantCode   int
+		wantErr    bool
+	}{
+		{"stdout", "echo hello", "hello\n", "", 0, false},
+		{"stderr", "echo oops >&2", "", "oops\n", 0, false},
+		{"exit_code", "echo partial; exit 7", "partial\n", "", 7, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr, err := cl.Run(ctx, command.New(tc.cmd), nil)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tc.wantErr)
+			}
+			if rr.Stdout != tc.wantStdout || rr.Stderr != tc.wantStderr {
+				t.Errorf("stdout = %q, stderr = %q; want %q, %q", rr.Stdout, rr.Stderr, tc.wantStdout, tc.wantStderr)
+			}
+			if rr.ExitCode != tc.wantCode {
+				t.Errorf("exit code = %d; want %d", rr.ExitCode, tc.wantCode)
+			}
+			if rr.Host != srv.Host || rr.User != sshtest.DefaultUser {
+				t.Errorf("host/user = %s/%s", rr.Host, rr.User)
+			}
+		})
+	}
+
+	if got := len(srv.Commands()); got != len(tests) {
+		t.Errorf("server saw %d commands; want %d", got, len(tests))
+	}
+}
+
+func TestIntegrationRunMetadata(t *testing.T) {
+	cl, srv := newTestClient(t, nil, WithEnvVars(map[string]string{"B": "2"}))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	tests := []struct {
+		name        string
+		opts        []RunOption
+		wantWorkDir string
+		wantEnvKeys []string
+	}{
+		{"defaults", nil, "", []string{"B"}},
+		{"workdir_env", []RunOption{WithRunWorkdir(dir), WithEnvVar("A", "1")}, dir, []string{"A", "B"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr, err := cl.Run(ctx, command.New("true"), nil, tc.opts...)
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if rr.StartedAt.IsZero() || rr.FinishedAt.Before(rr.StartedAt) || rr.Duration != rr.FinishedAt.Sub(rr.StartedAt) {
+				t.Errorf("StartedAt = %v, FinishedAt = %v, Duration = %v; want ordered times and their difference",
+					rr.StartedAt, rr.FinishedAt, rr.Duration)
+			}
+			if rr.Host != srv.Host || rr.User != sshtest.DefaultUser {
+				t.Errorf("host/user = %s/%s; want %s/%s", rr.Host, rr.User, srv.Host, sshtest.DefaultUser)
+			}
+			if rr.WorkDir != tc.wantWorkDir {
+				t.Errorf("WorkDir = %q; want %q", rr.WorkDir, tc.wantWorkDir)
+			}
+			if !slices.Equal(rr.EnvKeys, tc.wantEnvKeys) {
+				t.Errorf("EnvKeys = %v; want %v", rr.EnvKeys, tc.wantEnvKeys)
+			}
+			if rr.PTY || rr.Attempt != 1 {
+				t.Errorf("PTY = %v, Attempt = %d; want false, 1", rr.PTY, rr.Attempt)
+			}
+		})
+	}
+}
+
+func TestIntegrationStreaming(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	events := make(chan rexec.StreamEvent, 8)
+
+	if _, err := cl.Run(context.Background(), command.New("echo out; echo err >&2; exit 3"), nil, WithStreaming(), WithEvents(events)); err == nil {
+		t.Fatal("want exit error")
+	}
+	close(events)
+
+	got := map[rexec.StreamKind]string{}
+	exitCode := -1
+	for ev := range events {
+		if ev.Stream == rexec.StreamExit {
+			exitCode = ev.ExitCode
+			continue
+		}
+		got[ev.Stream] += ev.Text()
+	}
+	if got[rexec.StreamStdout] != "out" || got[rexec.StreamStderr] != "err" || exitCode != 3 {
+		t.Errorf("events stdout %q, stderr %q, exit %d; want out, err, 3", got[rexec.StreamStdout], got[rexec.StreamStderr], exitCode)
+	}
+}
+
+func TestIntegrationSudoPrompt(t *testing.T) {
+	handler := func(ctx context.Context, req *sshtest.ExecRequest) int {
+		if !req.PTY {
+			io.WriteString(req.Stderr, "no tty\n")
+			return 1
+		}
+		io.WriteString(req.Stdout, "[sudo] password for test: ")
+		line, err := bufio.NewReader(req.Stdin).ReadString('\n')
+		if err != nil || line != "s3cret\n" {
+			io.WriteString(req.Stderr, "Sorry, try again.\n")
+			return 1
+		}
+		io.WriteString(req.Stdout, "\nroot\n")
+		return 0
+	}
+	cl, _ := newTestClient(t, []sshtest.Option{sshtest.WithExecHandler(handler)}, WithSudoPassword("s3cret"))
+
+	rr, err := cl.Run(context.Background(), command.New("sudo -S whoami"), nil)
+	if err != nil {
+		t.Fatalf("run: %v (stderr %q)", err, rr.Stderr)
+	}
+	if !rr.PTY || !strings.HasSuffix(rr.Stdout, "root\n") {
+		t.Errorf("pty = %v, stdout = %q", rr.PTY, rr.Stdout)
+	}
+}
+
+func TestIntegrationTransfer(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	ctx := context.Background()
+
+	transfers := []struct {
+		name string
+		copy func(spec *rexec.FileSpec) error
+	}{
+		{"scp", func(spec *rexec.FileSpec) error { return NewSCPTransfer(cl).Copy(ctx, spec) }},
+		{"sftp", func(spec *rexec.FileSpec) error { return NewSFTPTransfer(cl).Copy(ctx, spec) }},
+	}
+
+	for _, tc := range transfers {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "nested", "it's here")
+			spec := &rexec.FileSpec{
+				TargetDir:  dir,
+				Filename:   "data.bin",
+				Mode:       0o640,
+				FolderMode: 0o750,
+				Content:    &rexec.FileContent{Data: []byte("line\n\x00\xff")},
+			}
+			if err := tc.copy(spec); err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+
+			path := filepath.Join(dir, "data.bin")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(data) != "line\n\x00\xff" {
+				t.Errorf("content = %q", data)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0o640 {
+				t.Errorf("mode = %o; want 640", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestIntegrationKeepAlive(t *testing.T) {
+	_, srv := newTestClient(t, nil, WithKeepAlive(10*time.Millisecond))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Keepalives() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("keepalives = %d; want at least 2", srv.Keepalives())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestIntegrationKeyAuthKnownHosts(t *testing.T) {
+	signer, keyPEM, err := sshtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := sshtest.NewServer(t, sshtest.WithAuthorizedKey("deploy", signer.PublicKey()))
+	knownHosts, err := srv.WriteKnownHosts(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewConfig("deploy", srv.Host, srv.Port,
+		WithKeyBytesAuth(keyPEM, "sshtest"),
+		WithKnownHosts(knownHosts),
+		WithRetry(0, 0),
+	)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	cl, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cl.Close()
+
+	stdout, _, _, err := rexec.RunRaw[RunOption](context.Background(), cl, command.New("echo ok"))
+	if err != nil || stdout != "ok\n" {
+		t.Errorf("stdout = %q, err = %v", stdout, err)
+	}
+
+	// a server with another host key must be rejected
+	other := sshtest.NewServer(t, sshtest.WithAuthorizedKey("deploy", signer.PublicKey()))
+	cfg.Port = other.Port
+	if _, err := NewClient(cfg); err == nil {
+		t.Error("expected host key mismatch error")
+	}
+}
+
+func TestIntegrationTelemetry(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tel, err := rexec.NewTelemetry(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, _ := newTestClient(t, nil, WithTelemetry(tel), WithSudoPassword("s3cret"))
+	ctx := context.Background()
+
+	if _, err := cl.Run(ctx, command.New("echo s3cret >/dev/null; exit 4"), nil); err == nil {
+		t.Fatal("expected exit error")
+	}
+	spec := &rexec.FileSpec{
+		TargetDir:  t.TempDir(),
+		Filename:   "f.txt",
+		Mode:       0o644,
+		FolderMode: 0o755,
+		Content:    &rexec.FileContent{Data: []byte("12345")},
+	}
+	if err := NewSCPTransfer(cl).Copy(ctx, spec); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+
+	// keep the first span of each name: the copy runs mkdir through ssh.run as well
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans.Ended() {
+		if _, ok := byName[span.Name()]; !ok {
+			byName[span.Name()] = span
+		}
+	}
+	for _, name := range []string{"ssh.dial", "ssh.session.open", "ssh.session.wait", "ssh.run", "ssh.scp.copy"} {
+		if _, ok := byName[name]; !ok {
+			t.Fatalf("missing span %q", name)
+		}
+	}
+
+	attrs := func(span sdktrace.ReadOnlySpan) map[string]any {
+		m := make(map[string]any)
+		for _, kv := range span.Attributes() {
+			m[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		return m
+	}
+	run := byName["ssh.run"]
+	if got := attrs(run)[string(rexec.AttrExitCode)]; got != int64(4) {
+		t.Errorf("run exit code attr = %v; want 4", got)
+	}
+	// the sudo password is masked in spans even though the Telemetry has no redactors
+	if got := attrs(run)[string(rexec.AttrCommand)]; got != "echo *** >/dev/null; exit 4" {
+		t.Errorf("run command attr = %v; want the sudo password masked", got)
+	}
+	if byName["ssh.session.open"].Parent().SpanID() != run.SpanContext().SpanID() {
+		t.Error("session span is not a child of the run span")
+	}
+	if cp, ok := byName["ssh.scp.copy"]; ok {
+		if got := attrs(cp)[string(rexec.AttrBytes)]; got != int64(5) {
+			t.Errorf("copy bytes attr = %v; want 5", got)
+		}
+	}
+}
+
+func TestIntegrationLogging(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	handler := func(ctx context.Context, req *sshtest.ExecRequest) int {
+		io.WriteString(req.Stdout, "Password: ")
+		bufio.NewReader(req.Stdin).ReadString('\n')
+		return 0
+	}
+	cl, _ := newTestClient(t, []sshtest.Option{sshtest.WithExecHandler(handler)},
+		WithSudoPassword("sudo-s3cret"),
+		WithLogger(logger, parser.RedactValues("api-token")),
+	)
+
+	if _, err := cl.Run(context.Background(), command.New("sudo -S deploy --token api-token sudo-s3cret"), nil); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	out := logBuf.String()
+	for _, secret := range []string{"sudo-s3cret", sshtest.DefaultPassword, "api-token"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log leaks %q: %s", secret, out)
+		}
+	}
+	for _, want := range []string{`"msg":"connected"`, `"msg":"session opened"`, `"msg":"answering password prompt"`,
+		`"msg":"command finished"`, `"session":1`, `"exit_code":0`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %s: %s", want, out)
+		}
+	}
+}
+
+func TestIntegrationEnv(t *testing.T) {
+	tricky := "$HOME `id` \"q\" it's \\n"
+	line := `printf '%%s|%%s' "$V" "${HOME:+home}"`
+
+	tests := []struct {
+		name        string
+		srvOpts     []sshtest.Option
+		cfgOpts     []ConfigOption
+		runOpts     []RunOption
+		want        string
+		wantWrapped bool
+	}{
+		{"setenv", nil, nil, []RunOption{WithEnvVar("V", tricky)}, tricky + "|home", false},
+		{"fallback", []sshtest.Option{sshtest.WithoutEnv()}, nil, []RunOption{WithEnvVar("V", tricky)}, tricky + "|home", true},
+		{"clear_config", nil, []ConfigOption{WithEnvMode(rexec.EnvClear), WithEnvVars(map[string]string{"V": "x"})}, nil, "x|", true},
+		{"clear_run", nil, nil, []RunOption{WithRunEnvMode(rexec.EnvClear)}, "|", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cl, srv := newTestClient(t, tc.srvOpts, tc.cfgOpts...)
+			rr, err := cl.Run(context.Background(), command.New(line), nil, tc.runOpts...)
+			if err != nil {
+				t.Fatalf("run: %v (stderr %q)", err, rr.Stderr)
+			}
+			if rr.Stdout != tc.want {
+				t.Errorf("stdout = %q; want %q", rr.Stdout, tc.want)
+			}
+			sent := srv.Commands()[0]
+			if wrapped := strings.HasPrefix(sent, "env "); wrapped != tc.wantWrapped {
+				t.Errorf("sent %q; want wrapped = %v", sent, tc.wantWrapped)
+			}
+		})
+	}
+
+	cl, _ := newTestClient(t, nil)
+	if _, err := cl.Run(context.Background(), command.New("true"), nil, WithEnvVar("BAD-NAME", "x")); err == nil {
+		t.Error("expected error for an invalid variable name")
+	}
+}
+
+func TestIntegrationWorkdir(t *testing.T) {
+	base := t.TempDir()
+	cfgDir := filepath.Join(base, "it's cfg")
+	runDir := filepath.Join(base, "run $dir")
+	for _, d := range []string{cfgDir, runDir} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cl, _ := newTestClient(t, nil, WithWorkdir(cfgDir))
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		cmd     string
+		opts    []RunOption
+		want    string
+		wantDir string
+	}{
+		{"config", "pwd", nil, cfgDir + "\n", cfgDir},
+		{"run_override", "pwd", []RunOption{WithRunWorkdir(runDir)}, runDir + "\n", runDir},
+		{"operators_kept", "false && echo no || pwd", nil, cfgDir + "\n", cfgDir},
+		{"with_env", "printf '%%s' \"$V\"; pwd", []RunOption{WithRunEnvMode(rexec.EnvClear), WithEnvVar("V", "x")}, "x" + cfgDir + "\n", cfgDir},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr, err := cl.Run(ctx, command.New(tc.cmd), nil, tc.opts...)
+			if err != nil {
+				t.Fatalf("run: %v (stderr %q)", err, rr.Stderr)
+			}
+			if rr.Stdout != tc.want {
+				t.Errorf("stdout = %q; want %q", rr.Stdout, tc.want)
+			}
+			if rr.WorkDir != tc.wantDir {
+				t.Errorf("WorkDir = %q; want %q", rr.WorkDir, tc.wantDir)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		missing := filepath.Join(base, "missing")
+		created := filepath.Join(base, "created")
+		_, err := cl.Run(ctx, command.New("touch %s", command.WithArgs(command.Quote(created))), nil, WithRunWorkdir(missing))
+		if !errors.Is(err, utils.ErrWorkdirMissing) || !strings.Contains(err.Error(), missing) {
+			t.Errorf("err = %v; want ErrWorkdirMissing naming %s", err, missing)
+		}
+		if _, statErr := os.Stat(created); statErr == nil {
+			t.Error("command ran although cd failed")
+		}
+	})
+
+	t.Run("missing_pty", func(t *testing.T) {
+		// a PTY merges stderr into stdout, as a real terminal would
+		mergePTY := func(ctx context.Context, req *sshtest.ExecRequest) int {
+			r := *req
+			if r.PTY {
+				r.Stderr = r.Stdout
+			}
+			return sshtest.ShellHandler(ctx, &r)
+		}
+		ptyCl, _ := newTestClient(t, []sshtest.Option{sshtest.WithExecHandler(mergePTY)})
+		missing := filepath.Join(base, "missing")
+		rr, err := ptyCl.Run(ctx, command.New("sudo true"), nil, WithRunWorkdir(missing))
+		if !rr.PTY || !errors.Is(err, utils.ErrWorkdirMissing) {
+			t.Errorf("pty = %v, err = %v; want ErrWorkdirMissing under a PTY", rr.PTY, err)
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		cmd  string
+	}{
+		{"exit_97_not_workdir", "exit 97"},
+		{"exit_97_marker_and_output", "printf 'rexec: cannot change directory to %%s\\n' \"$PWD\"; echo more >&2; exit 97"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := cl.Run(ctx, command.New(tc.cmd), nil)
+			if err == nil || errors.Is(err, utils.ErrWorkdirMissing) {
+				t.Errorf("err = %v; want a plain command failure", err)
+			}
+		})
+	}
+
+	for _, tr := range []struct {
+		name string
+		copy func(spec *rexec.FileSpec) error
+	}{
+		{"scp_relative", func(spec *rexec.FileSpec) error { return NewSCPTransfer(cl).Copy(ctx, spec) }},
+		{"sftp_relative", func(spec *rexec.FileSpec) error { return NewSFTPTransfer(cl).Copy(ctx, spec) }},
+	} {
+		t.Run(tr.name, func(t *testing.T) {
+			spec := &rexec.FileSpec{
+				TargetDir:  tr.name,
+				Filename:   "f.txt",
+				Mode:       0o644,
+				FolderMode: 0o755,
+				Content:    &rexec.FileContent{Data: []byte("ok")},
+			}
+			if err := tr.copy(spec); err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+			checkData(t, filepath.Join(cfgDir, tr.name, "f.txt"), "ok")
+		})
+	}
+}
+
+// checkData fails t unless the file at path holds want
+func checkData(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if string(data) != want {
+		t.Errorf("%s = %q; want %q", path, data, want)
+	}
+}
+
+func TestIntegrationCopyDir(t *testing.T) {
+	cl, srv := newTestClient(t, nil)
+	ctx := context.Background()
+
+	src := t.TempDir()
+	for name, data := range map[string]string{
+		"app.conf":              "app",
+		"conf.d/a.conf":         "a",
+		"conf.d/deep/b.conf":    "b",
+		"conf.d/skip.bak":       "old",
+		"it's a dir/space file": "quoted",
+	} {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(src, "app.conf"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("app.conf", filepath.Join(src, "current 100%")); err != nil {
+		t.Fatal(err)
+	}
+
+	transfers := []struct {
+		name string
+		copy func(spec *rexec.DirSpec) error
+	}{
+		{"scp", func(spec *rexec.DirSpec) error { return NewSCPTransfer(cl).CopyDir(ctx, spec) }},
+		{"sftp", func(spec *rexec.DirSpec) error { return NewSFTPTransfer(cl).CopyDir(ctx, spec) }},
+	}
+
+	for _, tc := range transfers {
+		t.Run(tc.name, func(t *testing.T) {
+			before := srv.Sessions()
+			dst := filepath.Join(t.TempDir(), "deploy")
+			spec := &rexec.DirSpec{
+				SourceDir: src,
+				TargetDir: dst,
+				Symlinks:  rexec.SymlinkRecreate,
+				Exclude:   []string{"*.bak"},
+			}
+			if err := tc.copy(spec); err != nil {
+				t.Fatalf("copy dir: %v", err)
+			}
+
+			checkData(t, filepath.Join(dst, "app.conf"), "app")
+			checkData(t, filepath.Join(dst, "conf.d", "a.conf"), "a")
+			checkData(t, filepath.Join(dst, "conf.d", "deep", "b.conf"), "b")
+			checkData(t, filepath.Join(dst, "it's a dir", "space file"), "quoted")
+			if _, err := os.Stat(filepath.Join(dst, "conf.d", "skip.bak")); !os.IsNotExist(err) {
+				t.Errorf("excluded file uploaded: %v", err)
+			}
+			if target, err := os.Readlink(filepath.Join(dst, "current 100%")); err != nil || target != "app.conf" {
+				t.Errorf("symlink = %q, %v; want app.conf", target, err)
+			}
+			for name, want := range map[string]os.FileMode{"app.conf": 0o755, "conf.d": 0o750, "conf.d/a.conf": 0o600} {
+				info, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != want {
+					t.Errorf("%s mode = %o; want %o", name, info.Mode().Perm(), want)
+				}
+			}
+
+			// scp: mkdir, scp -r -t and ln; sftp: one subsystem session
+			wantSessions := map[string]int{"scp": 3, "sftp": 1}[tc.name]
+			if got := srv.Sessions() - before; got != wantSessions {
+				t.Errorf("sessions = %d; want %d", got, wantSessions)
+			}
+		})
+	}
+}
+
+func TestIntegrationFetch(t *testing.T) {
+	base := t.TempDir()
+	cl, _ := newTestClient(t, nil, WithWorkdir(base))
+	ctx := context.Background()
+
+	remote := filepath.Join(base, "logs", "it's app.log")
+	if err := os.MkdirAll(filepath.Dir(remote), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(remote, []byte("line 1\nline 2\n\x00"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1600000000, 0)
+	if err := os.Chtimes(remote, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	transfers := []struct {
+		name  string
+		fetch func(spec *rexec.FetchSpec) error
+	}{
+		{"scp", func(spec *rexec.FetchSpec) error { return NewSCPTransfer(cl).Fetch(ctx, spec) }},
+		{"sftp", func(spec *rexec.FetchSpec) error { return NewSFTPTransfer(cl).Fetch(ctx, spec) }},
+	}
+
+	for _, tc := range transfers {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tc.fetch(&rexec.FetchSpec{RemotePath: remote, Writer: &buf}); err != nil {
+				t.Fatalf("fetch to writer: %v", err)
+			}
+			if buf.String() != "line 1\nline 2\n\x00" {
+				t.Errorf("content = %q", buf.String())
+			}
+
+			local := filepath.Join(t.TempDir(), "app.log")
+			if err := tc.fetch(&rexec.FetchSpec{RemotePath: "logs/it's app.log", LocalPath: local, PreserveTimes: true}); err != nil {
+				t.Fatalf("fetch relative path: %v", err)
+			}
+			checkData(t, local, "line 1\nline 2\n\x00")
+			info, err := os.Stat(local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0o640 || !info.ModTime().Equal(mtime) {
+				t.Errorf("mode, mtime = %o, %v; want 640, %v", info.Mode().Perm(), info.ModTime(), mtime)
+			}
+
+			missing := filepath.Join(t.TempDir(), "missing.log")
+			if err := tc.fetch(&rexec.FetchSpec{RemotePath: filepath.Join(base, "nope"), LocalPath: missing}); err == nil {
+				t.Error("fetch of a missing file: want error")
+			}
+			if _, err := os.Stat(missing); !os.IsNotExist(err) {
+				t.Errorf("local file created for a failed fetch: %v", err)
+			}
+			if err := tc.fetch(&rexec.FetchSpec{RemotePath: base, Writer: io.Discard}); err == nil {
+				t.Error("fetch of a directory: want error")
+			}
+		})
+	}
+}
+
+func TestIntegrationUploadFS(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	ctx := context.Background()
+
+	// embed.FS reports 0444 files and 0555 directories
+	src := fstest.MapFS{
+		"scripts/install.sh":     {Data: []byte("#!/bin/sh\necho ok\n"), Mode: 0o444},
+		"scripts/lib/common.sh":  {Data: []byte("x=1\n"), Mode: 0o444},
+		"scripts/lib/README.txt": {Data: []byte("docs"), Mode: 0o444},
+	}
+	scripts, err := fs.Sub(src, "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfers := []struct {
+		name    string
+		copy    func(spec *rexec.FileSpec) error
+		copyDir func(spec *rexec.DirSpec) error
+	}{
+		{"scp",
+			func(spec *rexec.FileSpec) error { return NewSCPTransfer(cl).Copy(ctx, spec) },
+			func(spec *rexec.DirSpec) error { return NewSCPTransfer(cl).CopyDir(ctx, spec) }},
+		{"sftp",
+			func(spec *rexec.FileSpec) error { return NewSFTPTransfer(cl).Copy(ctx, spec) },
+			func(spec *rexec.DirSpec) error { return NewSFTPTransfer(cl).CopyDir(ctx, spec) }},
+	}
+
+	for _, tc := range transfers {
+		t.Run(tc.name+"/file_specs", func(t *testing.T) {
+			dst := t.TempDir()
+			specs, err := rexec.FileSpecsFromFS(scripts, dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, spec := range specs {
+				spec.Mode = 0o755
+				if err := tc.copy(spec); err != nil {
+					t.Fatalf("copy %s: %v", spec.Filename, err)
+				}
+			}
+			checkData(t, filepath.Join(dst, "install.sh"), "#!/bin/sh\necho ok\n")
+			checkData(t, filepath.Join(dst, "lib", "common.sh"), "x=1\n")
+		})
+
+		t.Run(tc.name+"/copy_dir", func(t *testing.T) {
+			dst := t.TempDir()
+			spec := &rexec.DirSpec{Source: scripts, TargetDir: dst, FileMode: 0o750, Include: []string{"*.sh"}}
+			if err := tc.copyDir(spec); err != nil {
+				t.Fatalf("copy dir: %v", err)
+			}
+			checkData(t, filepath.Join(dst, "lib", "common.sh"), "x=1\n")
+			if _, err := os.Stat(filepath.Join(dst, "lib", "README.txt")); !os.IsNotExist(err) {
+				t.Errorf("README.txt uploaded despite Include: %v", err)
+			}
+			for _, name := range []string{"install.sh", "lib/common.sh"} {
+				info, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != 0o750 {
+					t.Errorf("%s mode = %o; want 750", name, info.Mode().Perm())
+				}
+			}
+		})
+	}
+}
+
+func TestIntegrationStreamUpload(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	ctx := context.Background()
+	payload := strings.Repeat("streamed line\n", 1000)
+
+	// stream returns a non-seekable reader, like an HTTP body or a gzip pipe
+	stream := func() io.Reader { return bufio.NewReader(strings.NewReader(payload)) }
+
+	tests := []struct {
+		name    string
+		content func() *rexec.FileContent
+		copy    func(spec *rexec.FileSpec) error
+		wantErr error
+		errText string
+	}{
+		{"sftp_unknown_size",
+			func() *rexec.FileContent { return &rexec.FileContent{Reader: stream()} },
+			func(spec *rexec.FileSpec) error { return NewSFTPTransfer(cl).Copy(ctx, spec) }, nil, ""},
+		{"scp_unknown_size",
+			func() *rexec.FileContent { return &rexec.FileContent{Reader: stream()} },
+			func(spec *rexec.FileSpec) error { return NewSCPTransfer(cl).Copy(ctx, spec) }, utils.ErrUnknownSize, "WithSpool"},
+		{"scp_explicit_size",
+			func() *rexec.FileContent { return &rexec.FileContent{Reader: stream(), Size: int64(len(payload))} },
+			func(spec *rexec.FileSpec) error { return NewSCPTransfer(cl).Copy(ctx, spec) }, nil, ""},
+		{"scp_wrong_size",
+			func() *rexec.FileContent { return &rexec.FileContent{Reader: stream(), Size: int64(len(payload)) + 1} },
+			func(spec *rexec.FileSpec) error { return NewSCPTransfer(cl).Copy(ctx, spec) }, nil, "source shrank"},
+		{"scp_spool",
+			func() *rexec.FileContent { return &rexec.FileContent{Reader: stream()} },
+			func(spec *rexec.FileSpec) error {
+				return NewSCPTransfer(cl).Copy(ctx, spec, WithSpool(1<<20, spec.TargetDir+".spool"))
+			}, nil, ""},
+		{"scp_spool_limit",
+			func() *rexec.FileContent { return &rexec.FileContent{Reader: stream()} },
+			func(spec *rexec.FileSpec) error {
+				return NewSCPTransfer(cl).Copy(ctx, spec, WithSpool(100, spec.TargetDir+".spool"))
+			}, nil, "spool limit"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "dst")
+			if err := os.MkdirAll(dir+".spool", 0o700); err != nil {
+				t.Fatal(err)
+			}
+			spec := &rexec.FileSpec{TargetDir: dir, Filename: "data.txt", Mode: 0o644, FolderMode: 0o755, Content: tc.content()}
+			err := tc.copy(spec)
+
+			if spooled, _ := os.ReadDir(dir + ".spool"); len(spooled) != 0 {
+				t.Errorf("spool files left behind: %v", spooled)
+			}
+			if tc.wantErr != nil || tc.errText != "" {
+				if err == nil || (tc.wantErr != nil && !errors.Is(err, tc.wantErr)) || !strings.Contains(err.Error(), tc.errText) {
+					t.Errorf("err = %v; want %v containing %q", err, tc.wantErr, tc.errText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+			checkData(t, filepath.Join(dir, "data.txt"), payload)
+		})
+	}
+}
+
+// failingReader returns data and then err instead of io.EOF
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestIntegrationAtomicCopy(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	ctx := context.Background()
+	errBroken := errors.New("source broken")
+
+	transfers := []struct {
+		name string
+		copy func(spec *rexec.FileSpec) error
+	}{
+		{"scp", func(spec *rexec.FileSpec) error { return NewSCPTransfer(cl).Copy(ctx, spec) }},
+		{"sftp", func(spec *rexec.FileSpec) error { return NewSFTPTransfer(cl).Copy(ctx, spec) }},
+	}
+	tests := []struct {
+		name     string
+		content  func() *rexec.FileContent
+		wantErr  error
+		wantData string
+	}{
+		{"replace", func() *rexec.FileContent { return &rexec.FileContent{Data: []byte("new config")} }, nil, "new config"},
+		{"failed_source", func() *rexec.FileContent {
+			return &rexec.FileContent{Reader: &failingReader{data: "half", err: errBroken}, Size: 10}
+		}, errBroken, "old config"},
+	}
+
+	for _, tr := range transfers {
+		for _, tc := range tests {
+			t.Run(tr.name+"/"+tc.name, func(t *testing.T) {
+				dir := t.TempDir()
+				target := filepath.Join(dir, "app.conf")
+				if err := os.WriteFile(target, []byte("old config"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+
+				err := tr.copy(&rexec.FileSpec{TargetDir: dir, Filename: "app.conf", Mode: 0o640, FolderMode: 0o755,
+					Content: tc.content(), Atomic: true})
+				if tc.wantErr != nil {
+					if !errors.Is(err, tc.wantErr) {
+						t.Errorf("err = %v; want %v", err, tc.wantErr)
+					}
+				} else if err != nil {
+					t.Fatalf("copy: %v", err)
+				}
+
+				checkData(t, target, tc.wantData)
+				if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+					t.Errorf("temp files left behind: %v", entries)
+				}
+				if info, err := os.Stat(target); err == nil && tc.wantErr == nil && info.Mode().Perm() != 0o640 {
+					t.Errorf("mode = %o; want 640", info.Mode().Perm())
+				}
+			})
+		}
+	}
+}
+
+func TestIntegrationCopyChecked(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	ctx := context.Background()
+	old := time.Unix(1600000000, 0)
+
+	transfers := []struct {
+		name string
+		copy func(spec *rexec.FileSpec) (*rexec.CopyResult, error)
+	}{
+		{"scp", func(spec *rexec.FileSpec) (*rexec.CopyResult, error) {
+			return NewSCPTransfer(cl).CopyChecked(ctx, spec)
+		}},
+		{"sftp", func(spec *rexec.FileSpec) (*rexec.CopyResult, error) {
+			return NewSFTPTransfer(cl).CopyChecked(ctx, spec)
+		}},
+	}
+
+	for _, tr := range transfers {
+		t.Run(tr.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "dst")
+			target := filepath.Join(dir, "app.conf")
+			steps := []struct {
+				name        string
+				data        string
+				wantChanged bool
+			}{
+				{"new_file", "port=80\n", true},
+				{"unchanged", "port=80\n", false},
+				{"changed", "port=8080\n", true},
+			}
+
+			for _, step := range steps {
+				res, err := tr.copy(&rexec.FileSpec{TargetDir: dir, Filename: "app.conf", Mode: 0o644, FolderMode: 0o755,
+					Content: &rexec.FileContent{Data: []byte(step.data)}, SkipUnchanged: true, Verify: true})
+				if err != nil {
+					t.Fatalf("%s: %v", step.name, err)
+				}
+				if res.Changed != step.wantChanged || res.Verified != step.wantChanged || len(res.Checksum) != 64 {
+					t.Errorf("%s: result = %+v; want changed and verified %v", step.name, res, step.wantChanged)
+				}
+				if step.wantChanged && res.Bytes != int64(len(step.data)) {
+					t.Errorf("%s: bytes = %d; want %d", step.name, res.Bytes, len(step.data))
+				}
+				checkData(t, target, step.data)
+
+				info, err := os.Stat(target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !step.wantChanged && !info.ModTime().Equal(old) {
+					t.Errorf("%s: skipped file was rewritten (mtime %v)", step.name, info.ModTime())
+				}
+				if err := os.Chtimes(target, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestIntegrationFileAttrs(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	ctx := context.Background()
+	me, err := user.Current()
+	if err != nil {
+		t.Skipf("current user: %v", err)
+	}
+	oldTime, oldATime := time.Unix(1500000000, 0), time.Unix(1500000100, 0)
+	mtime, atime := time.Unix(1600000000, 0), time.Unix(1600000100, 0)
+
+	// backupATime is the access time each backup keeps: cp -p copies it, SFTP copies what the
+	// server reports, and the test server's SFTP subsystem reports the modification time
+	transfers := []struct {
+		name        string
+		copy        func(spec *rexec.FileSpec) error
+		backupATime time.Time
+	}{
+		{"scp", func(spec *rexec.FileSpec) error { return NewSCPTransfer(cl).Copy(ctx, spec) }, oldATime},
+		{"sftp", func(spec *rexec.FileSpec) error { return NewSFTPTransfer(cl).Copy(ctx, spec) }, oldTime},
+	}
+
+	for _, tr := range transfers {
+		for _, atomic := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/atomic_%v", tr.name, atomic), func(t *testing.T) {
+				dir := t.TempDir()
+				target := filepath.Join(dir, "app.conf")
+				if err := os.WriteFile(target, []byte("old"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(target, oldATime, oldTime); err != nil {
+					t.Fatal(err)
+				}
+				wantOwner := me.Uid + " " + me.Gid
+				if os.Geteuid() == 0 {
+					if err := os.Chown(target, 65534, 65534); err != nil {
+						t.Fatal(err)
+					}
+					wantOwner = "65534 65534"
+				}
+
+				err := tr.copy(&rexec.FileSpec{TargetDir: dir, Filename: "app.conf", Mode: 0o640, FolderMode: 0o755,
+					Content: &rexec.FileContent{Data: []byte("new")}, Atomic: atomic,
+					Owner: me.Username, Group: me.Gid, ModTime: mtime, AccessTime: atime, Backup: true})
+				if err != nil {
+					t.Fatalf("copy: %v", err)
+				}
+
+				checkData(t, target, "new")
+				info, err := os.Stat(target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !info.ModTime().Equal(mtime) {
+					t.Errorf("mtime = %v; want %v", info.ModTime(), mtime)
+				}
+				out, _, _, err := rexec.RunRaw[RunOption](ctx, cl, command.New("ls -nd %s", command.WithArgs(command.Quote(target))))
+				if fields := strings.Fields(out); err != nil || len(fields) < 4 || fields[2] != me.Uid || fields[3] != me.Gid {
+					t.Errorf("ls -n = %q, %v; want owner %s:%s", out, err, me.Uid, me.Gid)
+				}
+
+				backups, _ := filepath.Glob(filepath.Join(dir, "app.conf.bak.*"))
+				if len(backups) != 1 {
+					t.Fatalf("backups = %v; want one", backups)
+				}
+				if info, err := os.Stat(backups[0]); err != nil || info.Mode().Perm() != 0o600 || !info.ModTime().Equal(oldTime) {
+					t.Errorf("backup = %v, %v; want mode 600 and the old mtime", info, err)
+				}
+				// both transports keep the owner and the access time, as cp -p does
+				out, _, _, err = rexec.RunRaw[RunOption](ctx, cl, command.New("stat -c '%%u %%g %%X' %s", command.WithArgs(command.Quote(backups[0]))))
+				if err != nil {
+					t.Logf("stat -c is unavailable, owner and atime not checked: %v", err)
+				} else if want := fmt.Sprintf("%s %d", wantOwner, tr.backupATime.Unix()); strings.TrimSpace(out) != want {
+					t.Errorf("backup owner and atime = %q; want %q", strings.TrimSpace(out), want)
+				}
+				checkData(t, backups[0], "old")
+			})
+		}
+	}
+}
+
+func TestIntegrationProgress(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	ctx := context.Background()
+	payload := strings.Repeat("x", 150_000)
+	src := fstest.MapFS{
+		"a.bin":     {Data: []byte(strings.Repeat("a", 40_000)), Mode: 0o644},
+		"sub/b.bin": {Data: []byte(strings.Repeat("b", 60_000)), Mode: 0o644},
+	}
+
+	transfers := []struct {
+		name    string
+		copy    func(spec *rexec.FileSpec, fn rexec.ProgressFunc) error
+		copyDir func(spec *rexec.DirSpec, fn rexec.ProgressFunc) error
+	}{
+		{"scp",
+			func(spec *rexec.FileSpec, fn rexec.ProgressFunc) error {
+				return NewSCPTransfer(cl).Copy(ctx, spec, WithProgress(fn), WithBandwidthLimit(100_000))
+			},
+			func(spec *rexec.DirSpec, fn rexec.ProgressFunc) error {
+				return NewSCPTransfer(cl).CopyDir(ctx, spec, WithProgress(fn))
+			}},
+		{"sftp",
+			func(spec *rexec.FileSpec, fn rexec.ProgressFunc) error {
+				return NewSFTPTransfer(cl).Copy(ctx, spec, WithSFTPProgress(fn), WithSFTPBandwidthLimit(100_000))
+			},
+			func(spec *rexec.DirSpec, fn rexec.ProgressFunc) error {
+				return NewSFTPTransfer(cl).CopyDir(ctx, spec, WithSFTPProgress(fn))
+			}},
+	}
+
+	for _, tr := range transfers {
+		t.Run(tr.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var reports []rexec.Progress
+			record := func(p rexec.Progress) { reports = append(reports, p) }
+
+			start := time.Now()
+			err := tr.copy(&rexec.FileSpec{TargetDir: dir, Filename: "big.bin", Mode: 0o644,
+				Content: &rexec.FileContent{Data: []byte(payload)}}, record)
+			if err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+			// 100 KB/s with a one second burst: the last 50 KB take about half a second
+			if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+				t.Errorf("150 KB at 100 KB/s took %v; want at least 400ms", elapsed)
+			}
+			last := reports[len(reports)-1]
+			if !last.Done || last.Bytes != 150_000 || last.Total != 150_000 || last.Path != filepath.Join(dir, "big.bin") || last.Rate <= 0 {
+				t.Errorf("last progress = %+v; want done with 150000 bytes", last)
+			}
+			checkData(t, filepath.Join(dir, "big.bin"), payload)
+
+			reports = nil
+			if err := tr.copyDir(&rexec.DirSpec{Source: src, TargetDir: filepath.Join(dir, "tree")}, record); err != nil {
+				t.Fatalf("copy dir: %v", err)
+			}
+			var done []string
+			for _, p := range reports {
+				if p.Done {
+					done = append(done, fmt.Sprintf("%s %d/%d", filepath.Base(p.Path), p.Bytes, p.Total))
+				}
+			}
+			if want := []string{"a.bin 40000/100000", "b.bin 100000/100000"}; strings.Join(done, ",") != strings.Join(want, ",") {
+				t.Errorf("done reports = %v; want %v", done, want)
+			}
+		})
+	}
+}
+
+func TestIntegrationSFTPResume(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	ctx := context.Background()
+	tr := NewSFTPTransfer(cl)
+	const payload = "0123456789abcdef"
+
+	uploads := []struct {
+		name        string
+		partial     string
+		verifyHash  bool
+		wantResumed int64
+	}{
+		{"no_partial", "", false, 0},
+		{"prefix", "01234567", false, 8},
+		{"prefix_hash", "01234567", true, 8},
+		{"wrong_prefix_hash", "01234XXX", true, 0},
+		{"longer_than_source", payload + "tail", false, 0},
+	}
+
+	for _, tc := range uploads {
+		t.Run("upload/"+tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			partial := filepath.Join(dir, rexec.PartialName("app.bin"))
+			if tc.partial != "" {
+				if err := os.WriteFile(partial, []byte(tc.partial), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			res, err := tr.CopyChecked(ctx, &rexec.FileSpec{TargetDir: dir, Filename: "app.bin", Mode: 0o640,
+				Content: &rexec.FileContent{Reader: strings.NewReader(payload)}}, WithSFTPResume(tc.verifyHash))
+			if err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+			if res.Resumed != tc.wantResumed || res.Bytes != int64(len(payload))-tc.wantResumed {
+				t.Errorf("resumed, bytes = %d, %d; want %d, %d", res.Resumed, res.Bytes, tc.wantResumed, int64(len(payload))-tc.wantResumed)
+			}
+			checkData(t, filepath.Join(dir, "app.bin"), payload)
+			if _, err := os.Stat(partial); !os.IsNotExist(err) {
+				t.Errorf("partial file left behind: %v", err)
+			}
+		})
+	}
+
+	t.Run("upload/failed_keeps_partial", func(t *testing.T) {
+		dir := t.TempDir()
+		errBroken := errors.New("source broken")
+		_, err := tr.CopyChecked(ctx, &rexec.FileSpec{TargetDir: dir, Filename: "app.bin", Mode: 0o640,
+			Content: &rexec.FileContent{Reader: &failingReader{data: "0123", err: errBroken}, Size: 16}}, WithSFTPResume(true))
+		if !errors.Is(err, errBroken) {
+			t.Fatalf("err = %v; want %v", err, errBroken)
+		}
+		checkData(t, filepath.Join(dir, rexec.PartialName("app.bin")), "0123")
+
+		res, err := tr.CopyChecked(ctx, &rexec.FileSpec{TargetDir: dir, Filename: "app.bin", Mode: 0o640,
+			Content: &rexec.FileContent{Data: []byte(payload)}}, WithSFTPResume(true))
+		if err != nil || res.Resumed != 4 {
+			t.Fatalf("resume = %+v, %v; want 4 bytes resumed", res, err)
+		}
+		checkData(t, filepath.Join(dir, "app.bin"), payload)
+	})
+
+	remote := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(remote, []byte(payload), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	downloads := []struct {
+		name       string
+		partial    string
+		verifyHash bool
+	}{
+		{"no_partial", "", false},
+		{"prefix", "01234567", false},
+		{"prefix_hash", "01234567", true},
+		{"wrong_prefix_hash", "01234XXX", true},
+		{"longer_than_source", payload + "tail", false},
+	}
+
+	for _, tc := range downloads {
+		t.Run("download/"+tc.name, func(t *testing.T) {
+			spec := &rexec.FetchSpec{RemotePath: remote, LocalPath: filepath.Join(t.TempDir(), "app.log")}
+			if tc.partial != "" {
+				if err := os.WriteFile(spec.PartialPath(), []byte(tc.partial), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tr.Fetch(ctx, spec, WithSFTPResume(tc.verifyHash)); err != nil {
+				t.Fatalf("fetch: %v", err)
+			}
+			checkData(t, spec.LocalPath, payload)
+			if info, err := os.Stat(spec.LocalPath); err != nil || info.Mode().Perm() != 0o640 {
+				t.Errorf("stat = %v, %v; want mode 640", info, err)
+			}
+			if _, err := os.Stat(spec.PartialPath()); !os.IsNotExist(err) {
+				t.Errorf("partial file left behind: %v", err)
+			}
+		})
+	}
+
+	// hashes are read back over SFTP, so verifyHash works on a target without head or sha256sum
+	t.Run("hash_without_commands", func(t *testing.T) {
+		noCommands := func(ctx context.Context, req *sshtest.ExecRequest) int {
+			io.WriteString(req.Stderr, "sh: command not found\n")
+			return 127
+		}
+		sftpOnly, _ := newTestClient(t, []sshtest.Option{sshtest.WithExecHandler(noCommands)})
+		tr := NewSFTPTransfer(sftpOnly)
+
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, rexec.PartialName("app.bin")), []byte("01234567"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		res, err := tr.CopyChecked(ctx, &rexec.FileSpec{TargetDir: dir, Filename: "app.bin", Mode: 0o640,
+			Content: &rexec.FileContent{Data: []byte(payload)}}, WithSFTPResume(true))
+		if err != nil || res.Resumed != 8 {
+			t.Fatalf("upload = %+v, %v; want 8 bytes resumed", res, err)
+		}
+		checkData(t, filepath.Join(dir, "app.bin"), payload)
+
+		spec := &rexec.FetchSpec{RemotePath: remote, LocalPath: filepath.Join(t.TempDir(), "app.log")}
+		if err := os.WriteFile(spec.PartialPath(), []byte("01234567"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		var fetched int64
+		err = tr.Fetch(ctx, spec, WithSFTPResume(true), WithSFTPProgress(func(p rexec.Progress) {
+			if p.Done {
+				fetched = p.FileBytes
+			}
+		}))
+		if err != nil || fetched != 8 {
+			t.Fatalf("download = %d bytes, %v; want the last 8 bytes", fetched, err)
+		}
+		checkData(t, spec.LocalPath, payload)
+	})
+}
@@ -4,8 +4,13 @@ package ssh
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"sync"
+
+	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/parser"
 )
 
 // RunOption configures a single SSH command execution
@@ -13,43 +18,52 @@ type RunOption func(*runConfig)
 
 // bufPoolOut is a pool of buffers used to capture stdout
 var bufPoolOut = sync.Pool{
-	New: func() any { return new(bytes.Buffer) },
+	New: func() any { return rexec.NewCaptureBuffer(rexec.OutputLimit{}) },
 }
 
 // bufPoolErr is a pool of buffers used to capture stderr
 var bufPoolErr = sync.Pool{
-	New: func() any { return new(bytes.Buffer) },
+	New: func() any { return rexec.NewCaptureBuffer(rexec.OutputLimit{}) },
 }
 
 // runConfig holds settings and buffers for one SSH command run
 type runConfig struct {
-	env           map[string]string // environment variables for this run
-	stdin         io.Reader         // input for the command
-	stdout        io.Writer         // live stdout writer (wrapped to buffer by default)
-	stderr        io.Writer         // live stderr writer (wrapped to buffer by default)
-	bufOut        *bytes.Buffer     // internal buffer for stdout
-	bufErr        *bytes.Buffer     // internal buffer for stderr
-	usePTY        bool              // allocate a PTY for the session
-	stream        bool              // stream output in real time
-	disableBuffer bool              // disable internal buffering of output
+	dir           string               // remote working directory ("" means the login directory)
+	env           map[string]string    // environment variables for this run
+	envMode       rexec.EnvMode        // inherit or clear the remote environment
+	stdin         io.Reader            // input for the command
+	stdout        io.Writer            // live stdout writer (wrapped to buffer by default)
+	stderr        io.Writer            // live stderr writer (wrapped to buffer by default)
+	bufOut        *rexec.CaptureBuffer // internal buffer for stdout
+	bufErr        *rexec.CaptureBuffer // internal buffer for stderr
+	errTail       *rexec.RingBuffer    // last stderr bytes for error messages
+	outputLimit   rexec.OutputLimit    // in-memory capture limit per stream
+	usePTY        bool                 // allocate a PTY for the session
+	disableBuffer bool                 // disable internal buffering of output
+	stream        bool                 // set by the deprecated WithStreaming
+
+	events    chan<- rexec.StreamEvent // optional: receives output lines and the exit event
+	eventsOut *rexec.LineWriter        // line splitter for stdout events
+	eventsErr *rexec.LineWriter        // line splitter for stderr events
 }
 
 // newRunConfig creates a runConfig from base envVars and applies opts.
 // It initializes internal buffers and, unless buffering is disabled,
 // wraps stdout/stderr writers to also record output in bufOut/bufErr
 func newRunConfig(workDir string, envVars map[string]string, opts ...RunOption) *runConfig {
-	bufOut := bufPoolOut.Get().(*bytes.Buffer)
-	bufErr := bufPoolErr.Get().(*bytes.Buffer)
+	bufOut := bufPoolOut.Get().(*rexec.CaptureBuffer)
+	bufErr := bufPoolErr.Get().(*rexec.CaptureBuffer)
 	bufOut.Reset()
 	bufErr.Reset()
 
 	runConfig := &runConfig{
-		env:    make(map[string]string, len(envVars)),
-		stdout: bufOut, // default buffers
-		stderr: bufErr,
-		bufOut: bufOut,
-		bufErr: bufErr,
-		stream: false,
+		dir:     workDir,
+		env:     make(map[string]string, len(envVars)),
+		stdout:  bufOut, // default buffers
+		stderr:  bufErr,
+		bufOut:  bufOut,
+		bufErr:  bufErr,
+		errTail: rexec.NewRingBuffer(rexec.ErrTailSize),
 	}
 
 	for k, v := range envVars {
@@ -60,6 +74,9 @@ func newRunConfig(workDir string, envVars map[string]string, opts ...RunOption)
 		opt(runConfig)
 	}
 
+	bufOut.SetLimit(runConfig.outputLimit)
+	bufErr.SetLimit(runConfig.outputLimit)
+
 	if !runConfig.disableBuffer {
 		if runConfig.stdout != bufOut {
 			runConfig.stdout = io.MultiWriter(runConfig.stdout, bufOut)
@@ -68,9 +85,72 @@ func newRunConfig(workDir string, envVars map[string]string, opts ...RunOption)
 			runConfig.stderr = io.MultiWriter(runConfig.stderr, bufErr)
 		}
 	}
+	runConfig.stderr = io.MultiWriter(runConfig.stderr, runConfig.errTail)
 	return runConfig
 }
 
+// fillOutput copies captured output and its size accounting into result.
+// Returns an error if a spill file could not be written
+func (rc *runConfig) fillOutput(result *parser.RawResult) error {
+	result.StdoutBytes = bytes.Clone(rc.bufOut.Bytes())
+	result.StderrBytes = bytes.Clone(rc.bufErr.Bytes())
+	result.Stdout = string(result.StdoutBytes)
+	result.Stderr = string(result.StderrBytes)
+	result.StdoutTotal = rc.bufOut.Total()
+	result.StderrTotal = rc.bufErr.Total()
+	result.Truncated = rc.bufOut.Truncated() || rc.bufErr.Truncated()
+
+	outErr := rc.bufOut.Close()
+	errErr := rc.bufErr.Close()
+	result.StdoutSpill = rc.bufOut.SpillPath()
+	result.StderrSpill = rc.bufErr.SpillPath()
+	return errors.Join(outErr, errErr)
+}
+
+// runEvents returns the events channel set by opts, if any
+func runEvents(opts []RunOption) chan<- rexec.StreamEvent {
+	rc := &runConfig{env: make(map[string]string)}
+	for _, opt := range opts {
+		opt(rc)
+	}
+	return rc.events
+}
+
+// attachEvents tees stdout/stderr into line writers that publish to the events channel
+func (rc *runConfig) attachEvents(ctx context.Context) {
+	if rc.events == nil {
+		return
+	}
+	rc.eventsOut = rexec.NewLineWriter(ctx, rexec.StreamStdout, rc.events)
+	rc.eventsErr = rexec.NewLineWriter(ctx, rexec.StreamStderr, rc.events)
+	rc.stdout = io.MultiWriter(rc.stdout, rc.eventsOut)
+	rc.stderr = io.MultiWriter(rc.stderr, rc.eventsErr)
+}
+
+// emitExit flushes partial lines and sends the final exit event, if events are enabled
+func (rc *runConfig) emitExit(ctx context.Context, result *parser.RawResult, err error) {
+	if rc == nil || rc.events == nil {
+		return
+	}
+	if rc.eventsOut != nil {
+		_ = rc.eventsOut.Flush()
+	}
+	if rc.eventsErr != nil {
+		_ = rc.eventsErr.Flush()
+	}
+	rexec.EmitExit(ctx, rc.events, result, err)
+}
+
+// WithRunWorkdir sets the remote working directory for one Run ("" means the login directory).
+// If the directory is missing the command does not run and Run fails with utils.ErrWorkdirMissing.
+// This is detected by exit status 97 with a marker line as the only output, so a command that
+// exits 97 after printing "rexec: cannot change directory to <dir>" alone is reported the same way
+func WithRunWorkdir(dir string) RunOption {
+	return func(config *runConfig) {
+		config.dir = dir
+	}
+}
+
 // WithEnvVar adds or overrides an environment variable for this run
 func WithEnvVar(key, value string) RunOption {
 	return func(config *runConfig) {
@@ -78,6 +158,13 @@ func WithEnvVar(key, value string) RunOption {
 	}
 }
 
+// WithRunEnvMode overrides the environment mode of the Config for one Run
+func WithRunEnvMode(mode rexec.EnvMode) RunOption {
+	return func(config *runConfig) {
+		config.envMode = mode
+	}
+}
+
 // WithStdin sets the input reader for the command
 func WithStdin(stdin io.Reader) RunOption {
 	return func(config *runConfig) {
@@ -99,7 +186,10 @@ func WithStderr(stderr io.Writer) RunOption {
 	}
 }
 
-// WithStreaming enables real-time streaming of stdout and stderr as data arrives
+// WithStreaming enables real-time streaming of stdout and stderr as data arrives.
+// WithStdout and WithStderr writers always receive output as it arrives, so it has no effect.
+//
+// Deprecated: use WithEvents(ch) to receive output line by line
 func WithStreaming() RunOption {
 	return func(config *runConfig) {
 		config.stream = true
@@ -112,3 +202,20 @@ func WithoutBuffering() RunOption {
 		config.disableBuffer = true
 	}
 }
+
+// WithEvents publishes every output line and a final exit event to ch. Every Run sends
+// exactly one exit event, also when it fails before the command starts; under rexec.Retry
+// that is one per attempt. Sends block until ch is read, so the command is slowed down by a slow consumer
+func WithEvents(ch chan<- rexec.StreamEvent) RunOption {
+	return func(config *runConfig) {
+		config.events = ch
+	}
+}
+
+// WithOutputLimit bounds the stdout and stderr bytes kept in memory, each stream separately.
+// Output beyond limit.MaxBytes is dropped or spilled to a temp file according to limit.Policy
+func WithOutputLimit(limit rexec.OutputLimit) RunOption {
+	return func(config *runConfig) {
+		config.outputLimit = limit
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package ssh
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/ngrsoftlab/rexec"
+)
+
+// progressInterval is the minimum time between two progress reports of one file
+const progressInterval = 250 * time.Millisecond
+
+// transferMeter reports progress and applies the bandwidth limit of one transfer
+// operation. A nil meter passes readers through unchanged
+type transferMeter struct {
+	ctx      context.Context
+	progress rexec.ProgressFunc
+	limiter  *rexec.BandwidthLimiter
+	start    time.Time
+	single   bool // Total is the size of the only file
+	total    int64
+	bytes    int64
+	path     string
+	fileSize int64
+	fileRead int64
+	reported time.Time
+}
+
+// newTransferMeter returns a meter for a single-file operation, or nil when
+// there is neither a progress callback nor a limiter
+func newTransferMeter(ctx context.Context, progress rexec.ProgressFunc, limiter *rexec.BandwidthLimiter) *transferMeter {
+	if progress == nil && limiter == nil {
+		return nil
+	}
+	return &transferMeter{ctx: ctx, progress: progress, limiter: limiter, start: time.Now(), single: true, total: rexec.UnknownSize}
+}
+
+// expect sets the total bytes of a multi-file operation
+func (m *transferMeter) expect(total int64) *transferMeter {
+	if m != nil {
+		m.single, m.total = false, total
+	}
+	return m
+}
+
+// file starts metering the file at remotePath and returns r wrapped to count and limit its data
+func (m *transferMeter) file(remotePath string, size int64, r io.Reader) io.Reader {
+	if m == nil {
+		return r
+	}
+	m.path, m.fileSize, m.fileRead = remotePath, size, 0
+	if m.single {
+		m.total = size
+	}
+	m.report(false)
+	return &meteredReader{r: r, m: m}
+}
+
+// done reports the current file as complete
+func (m *transferMeter) done() {
+	if m != nil {
+		m.report(true)
+	}
+}
+
+// report calls the progress callback, at most every progressInterval unless final
+func (m *transferMeter) report(final bool) {
+	if m.progress == nil {
+		return
+	}
+	now := time.Now()
+	if !final && !m.reported.IsZero() && now.Sub(m.reported) < progressInterval {
+		return
+	}
+	m.reported = now
+
+	p := rexec.Progress{
+		Path:      m.path,
+		FileBytes: m.fileRead,
+		FileSize:  m.fileSize,
+		Bytes:     m.bytes,
+		Total:     m.total,
+		Elapsed:   now.Sub(m.start),
+		Done:      final,
+	}
+	if secs := p.Elapsed.Seconds(); secs > 0 {
+		p.Rate = float64(p.Bytes) / secs
+	}
+	if p.Total >= 0 && p.Rate > 0 && p.Total > p.Bytes {
+		p.ETA = time.Duration(float64(p.Total-p.Bytes) / p.Rate * float64(time.Second))
+	}
+	m.progress(p)
+}
+
+// meteredReader counts and rate-limits the data read through it
+type meteredReader struct {
+	r io.Reader
+	m *transferMeter
+}
+
+func (mr *meteredReader) Read(p []byte) (int, error) {
+	if burst := mr.m.limiter.Burst(); burst > 0 && len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := mr.r.Read(p)
+	if n > 0 {
+		if waitErr := mr.m.limiter.Wait(mr.m.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+		mr.m.bytes += int64(n)
+		mr.m.fileRead += int64(n)
+		mr.m.report(false)
+	}
+	return n, err
+}
@@ -0,0 +1,68 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package ssh
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ngrsoftlab/rexec"
+)
+
+func TestTransferMeter(t *testing.T) {
+	var nilMeter *transferMeter
+	r := strings.NewReader("x")
+	if nilMeter.file("/f", 1, r) != io.Reader(r) {
+		t.Error("nil meter should return the reader unchanged")
+	}
+	if newTransferMeter(context.Background(), nil, nil) != nil {
+		t.Error("meter without progress or limiter should be nil")
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		tree  bool
+	}{
+		{"single_file", map[string]string{"/etc/a.conf": strings.Repeat("a", 100)}, false},
+		{"tree", map[string]string{"/srv/a": "aaa", "/srv/b": "bbbbb"}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var reports []rexec.Progress
+			m := newTransferMeter(context.Background(), func(p rexec.Progress) { reports = append(reports, p) }, nil)
+			var total int64
+			for _, data := range tc.files {
+				total += int64(len(data))
+			}
+			if tc.tree {
+				m.expect(total)
+			}
+
+			for name, data := range tc.files {
+				if _, err := io.Copy(io.Discard, m.file(name, int64(len(data)), strings.NewReader(data))); err != nil {
+					t.Fatal(err)
+				}
+				m.done()
+			}
+
+			var done int
+			for _, p := range reports {
+				if !p.Done {
+					continue
+				}
+				done++
+				if p.FileBytes != p.FileSize || int(p.FileSize) != len(tc.files[p.Path]) {
+					t.Errorf("done %s: %d of %d bytes", p.Path, p.FileBytes, p.FileSize)
+				}
+			}
+			last := reports[len(reports)-1]
+			if done != len(tc.files) || last.Bytes != total || last.Total != total || last.ETA != 0 {
+				t.Errorf("last = %+v after %d done reports; want %d of %d bytes and %d done", last, done, total, total, len(tc.files))
+			}
+		})
+	}
+}
@@ -9,13 +9,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ngrsoftlab/rexec"
 	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/utils"
 )
 
 const (
@@ -31,6 +36,10 @@ type scpConfig struct {
 	scpBinPath string      // path to the scp executable
 	bufSize    int         // size for bufio reader/writer
 	folderMode os.FileMode // mode for intermediate directories
+	spoolLimit int64       // max bytes of a non-seekable reader spooled to a temp file (0 disables)
+	spoolDir   string      // directory for spool files ("" means os.TempDir)
+	progress   rexec.ProgressFunc
+	limiter    *rexec.BandwidthLimiter
 }
 
 // newScpConfig creates a config using spec.FolderMode (if >0) and applies opts
@@ -69,6 +78,44 @@ func WithBufferSize(bufSize int) SCPOption {
 	}
 }
 
+// WithProgress reports the progress of file data sent or received to fn
+func WithProgress(fn rexec.ProgressFunc) SCPOption {
+	return func(config *scpConfig) {
+		config.progress = fn
+	}
+}
+
+// WithBandwidthLimit caps the file data rate of one transfer call at bytesPerSec
+func WithBandwidthLimit(bytesPerSec int64) SCPOption {
+	return func(config *scpConfig) {
+		config.limiter = rexec.NewBandwidthLimiter(bytesPerSec)
+	}
+}
+
+// WithBandwidthLimiter shares l between transfers, capping their combined rate
+func WithBandwidthLimiter(l *rexec.BandwidthLimiter) SCPOption {
+	return func(config *scpConfig) {
+		config.limiter = l
+	}
+}
+
+// interface guards: ensure SCPTransfer satisfies rexec.FileTransfer, rexec.CheckedTransfer, rexec.DirTransfer and rexec.FileFetcher
+var (
+	_ rexec.FileTransfer[SCPOption]    = (*SCPTransfer)(nil)
+	_ rexec.CheckedTransfer[SCPOption] = (*SCPTransfer)(nil)
+	_ rexec.DirTransfer[SCPOption]     = (*SCPTransfer)(nil)
+	_ rexec.FileFetcher[SCPOption]     = (*SCPTransfer)(nil)
+)
+
+// WithSpool lets Copy upload a non-seekable FileContent.Reader without Size by
+// first copying it to a temp file in dir ("" means os.TempDir), up to maxBytes
+func WithSpool(maxBytes int64, dir string) SCPOption {
+	return func(config *scpConfig) {
+		config.spoolLimit = maxBytes
+		config.spoolDir = dir
+	}
+}
+
 // SCPTransfer implements FileTransfer by piping data through `scp -t`
 type SCPTransfer struct {
 	client *Client // underlying SSH client
@@ -81,15 +128,65 @@ func NewSCPTransfer(client *Client) *SCPTransfer {
 
 // Copy uploads spec.Content to the remote host via scp.
 // It ensures the remote directory exists, starts scp in "to" mode,
-// then sends file header, data, and handles acknowledgments
+// then sends file header, data, and handles acknowledgments.
+// With spec.Atomic the file is sent under a hidden temp name and moved over
+// the target by `mv -f`; the temp file is removed if the upload fails
 func (t *SCPTransfer) Copy(ctx context.Context, spec *rexec.FileSpec, opts ...SCPOption) error {
+	_, err := t.CopyChecked(ctx, spec, opts...)
+	return err
+}
+
+// CopyChecked is Copy that reports whether the file changed. Remote checksums are
+// computed by `sha256sum` in a separate command: with spec.SkipUnchanged before
+// the upload, with spec.Verify after it. Times are sent as a T record, the backup
+// is taken by `cp -p` and the owner is set by `chown` after the data is written
+func (t *SCPTransfer) CopyChecked(ctx context.Context, spec *rexec.FileSpec, opts ...SCPOption) (_ *rexec.CopyResult, err error) {
 	if err := spec.Validate(); err != nil {
-		return err
+		return nil, err
 	}
 
+	res := &rexec.CopyResult{Changed: true}
+	filePath := path.Join(spec.TargetDir, spec.Filename)
+	ctx, finishSpan := t.client.cfg.telemetry.StartTransfer(ctx, "ssh.scp.copy", t.client.cfg.Host, filePath)
+	defer func() {
+		finishSpan(res.Bytes, err)
+		logCopy(t.client, "scp", filePath, res.Bytes, err)
+	}()
+
 	cfg := newScpConfig(spec.FolderMode, opts...)
 	target := escapeShellPath(spec.TargetDir)
 
+	content, cleanup, err := cfg.sizedContent(ctx, spec.Content)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if content != spec.Content {
+		sized := *spec
+		sized.Content = content
+		spec = &sized
+	}
+
+	if spec.NeedsChecksum() {
+		if res.Checksum, err = spec.Content.SHA256(); err != nil {
+			return nil, err
+		}
+	}
+	if spec.SkipUnchanged {
+		current, err := t.remoteChecksum(ctx, filePath)
+		if err != nil {
+			return nil, err
+		}
+		if current == res.Checksum {
+			res.Changed = false
+			return res, nil
+		}
+	}
+	mtime, atime, err := spec.Times()
+	if err != nil {
+		return nil, err
+	}
+
 	mkdirCmd := command.New(
 		"mkdir -p -m %04o %s",
 		command.WithArgs(
@@ -98,111 +195,590 @@ func (t *SCPTransfer) Copy(ctx context.Context, spec *rexec.FileSpec, opts ...SC
 		),
 	)
 	if err := rexec.RunNoResult[RunOption](ctx, t.client, mkdirCmd); err != nil {
-		return fmt.Errorf("remote mkdir: %w", err)
+		return nil, fmt.Errorf("remote mkdir: %w", err)
+	}
+	if spec.Backup {
+		if err := t.backup(ctx, filePath, path.Join(spec.TargetDir, rexec.BackupName(spec.Filename, time.Now()))); err != nil {
+			return nil, err
+		}
 	}
 
-	sess, err := t.client.OpenSession(ctx)
-	if err != nil {
-		return fmt.Errorf("open ssh session: %w", err)
+	upload := spec
+	if spec.Atomic {
+		tmp := *spec
+		tmp.Filename = rexec.AtomicTempName(spec.Filename)
+		upload = &tmp
+		defer func() {
+			if err != nil {
+				err = errors.Join(err, t.removeTemp(ctx, path.Join(spec.TargetDir, tmp.Filename)))
+			}
+		}()
 	}
-	defer sess.Close()
 
-	stdinPipe, err := sess.StdinPipe()
+	args := "-t "
+	if !mtime.IsZero() {
+		args = "-p -t "
+	}
+	scp, err := t.start(ctx, cfg, args+target)
 	if err != nil {
-		return fmt.Errorf("get stdinPipe pipe: %w", err)
+		return nil, err
 	}
-	stdoutPipe, err := sess.StdoutPipe()
+	defer scp.sess.Close()
+
+	// init ACK
+	if err := readAck(ctx, scp.r); err != nil {
+		return nil, fmt.Errorf("initial ACK: %w", err)
+	}
+
+	if !mtime.IsZero() {
+		if err := sendRecord(ctx, scp.w, scp.r, fmt.Sprintf("T%d 0 %d 0\n", mtime.Unix(), atime.Unix())); err != nil {
+			return nil, fmt.Errorf("file times: %w", err)
+		}
+	}
+	meter := newTransferMeter(ctx, cfg.progress, cfg.limiter)
+	if res.Bytes, err = sendFile(ctx, upload, filePath, meter, scp.w, scp.r); err != nil {
+		return res, fmt.Errorf("send file %q: %w", spec.Filename, err)
+	}
+	if err := scp.finish(); err != nil {
+		return res, err
+	}
+	scp.sess.Close() // release the session slot before running chown, mv and sha256sum
+
+	if err := t.finalize(ctx, spec, path.Join(spec.TargetDir, upload.Filename), filePath); err != nil {
+		return res, err
+	}
+	if spec.Verify {
+		written, err := t.remoteChecksum(ctx, filePath)
+		if err != nil {
+			return res, err
+		}
+		if err := rexec.VerifyChecksum(filePath, res.Checksum, written); err != nil {
+			return res, err
+		}
+		res.Verified = true
+	}
+	return res, nil
+}
+
+// remoteChecksum returns the hex SHA-256 of the regular file at filePath computed
+// by `sha256sum` on the target, or "" if there is no such file
+func (t *SCPTransfer) remoteChecksum(ctx context.Context, filePath string) (string, error) {
+	quoted := escapeShellPath(filePath)
+	cmd := command.New("if [ -f %s ]; then sha256sum -- %s; fi", command.WithArgs(quoted, quoted))
+	stdout, _, _, err := rexec.RunRaw[RunOption](ctx, t.client, cmd)
 	if err != nil {
-		return fmt.Errorf("get stdoutPipe pipe: %w", err)
+		return "", fmt.Errorf("remote checksum: %w", err)
+	}
+	fields := strings.Fields(stdout)
+	if len(fields) == 0 {
+		return "", nil
 	}
+	sum := strings.TrimPrefix(fields[0], "\\") // GNU escapes names with newlines or backslashes
+	if len(sum) != 64 {
+		return "", fmt.Errorf("remote checksum: unexpected sha256sum output %q", stdout)
+	}
+	return sum, nil
+}
 
-	stderrPipe, err := sess.StderrPipe()
+// finalize runs one command that chowns the uploaded file to spec.Owner and spec.Group
+// and, for an atomic upload, flushes it to disk (sync with a file operand needs GNU
+// coreutils, otherwise the whole system is synced) and moves it over target
+func (t *SCPTransfer) finalize(ctx context.Context, spec *rexec.FileSpec, uploaded, target string) error {
+	var steps []string
+	quoted := escapeShellPath(uploaded)
+	if spec.Owner != "" || spec.Group != "" {
+		owner := spec.Owner
+		if spec.Group != "" {
+			owner += ":" + spec.Group
+		}
+		steps = append(steps, "chown -- "+escapeShellPath(owner)+" "+quoted)
+	}
+	if uploaded != target {
+		steps = append(steps, "{ sync -- "+quoted+" 2>/dev/null || sync; }", "mv -f -- "+quoted+" "+escapeShellPath(target))
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	cmd := command.New("%s", command.WithArgs(strings.Join(steps, " && ")))
+	if err := rexec.RunNoResult[RunOption](ctx, t.client, cmd); err != nil {
+		return fmt.Errorf("finalize %s: %w", target, err)
+	}
+	return nil
+}
+
+// backup copies an existing regular file with `cp -p`, keeping its mode, owner and times
+func (t *SCPTransfer) backup(ctx context.Context, filePath, backupPath string) error {
+	quoted := escapeShellPath(filePath)
+	cmd := command.New("if [ -f %s ]; then cp -p -- %s %s; fi", command.WithArgs(quoted, quoted, escapeShellPath(backupPath)))
+	if err := rexec.RunNoResult[RunOption](ctx, t.client, cmd); err != nil {
+		return fmt.Errorf("backup %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// removeTemp deletes the temp file of a failed atomic upload, even if ctx is cancelled
+func (t *SCPTransfer) removeTemp(ctx context.Context, tmp string) error {
+	cmd := command.New("rm -f -- %s", command.WithArgs(escapeShellPath(tmp)))
+	if err := rexec.RunNoResult[RunOption](context.WithoutCancel(ctx), t.client, cmd); err != nil {
+		return fmt.Errorf("remove temp file: %w", err)
+	}
+	return nil
+}
+
+// CopyDir uploads the tree described by spec via `scp -r -t` in one session:
+// directories are sent as D/E records and files as C records keeping their modes.
+// SCP cannot carry symlinks, so with SymlinkRecreate they are created afterwards
+// by a single `ln -sfn` command
+func (t *SCPTransfer) CopyDir(ctx context.Context, spec *rexec.DirSpec, opts ...SCPOption) (err error) {
+	entries, err := spec.Walk()
 	if err != nil {
-		return fmt.Errorf("get stderrPipe pipe: %w", err)
+		return err
 	}
 
-	var errBuf bytes.Buffer
-	errCh := make(chan error, 1)
-	go func() {
-		errCh <- copyWithContext(ctx, stderrPipe, &errBuf)
+	var sent int64
+	ctx, finishSpan := t.client.cfg.telemetry.StartTransfer(ctx, "ssh.scp.copy_dir", t.client.cfg.Host, spec.TargetDir)
+	defer func() {
+		finishSpan(sent, err)
+		logCopy(t.client, "scp", spec.TargetDir, sent, err)
 	}()
 
-	scpCmd := fmt.Sprintf("%s -t %s", cfg.scpBinPath, target)
-	if err := sess.Start(scpCmd); err != nil {
-		return fmt.Errorf("start scp [%s]: %w -- %s", scpCmd, err, errBuf.String())
+	cfg := newScpConfig(spec.FolderMode, opts...)
+	target := escapeShellPath(spec.TargetDir)
+
+	mkdirCmd := command.New("mkdir -p -m %04o %s", command.WithArgs(cfg.folderMode, target))
+	if err := rexec.RunNoResult[RunOption](ctx, t.client, mkdirCmd); err != nil {
+		return fmt.Errorf("remote mkdir: %w", err)
 	}
 
-	w := bufio.NewWriterSize(stdinPipe, cfg.bufSize)
-	r := bufio.NewReaderSize(stdoutPipe, cfg.bufSize)
+	scp, err := t.start(ctx, cfg, "-r -t "+target)
+	if err != nil {
+		return err
+	}
+	defer scp.sess.Close()
 
 	// init ACK
-	if err := readAck(ctx, r); err != nil {
+	if err := readAck(ctx, scp.r); err != nil {
 		return fmt.Errorf("initial ACK: %w", err)
 	}
 
-	if err := sendFile(ctx, spec, w, r); err != nil {
-		return fmt.Errorf("send file %q: %w", spec.Filename, err)
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	meter := newTransferMeter(ctx, cfg.progress, cfg.limiter).expect(total)
+
+	var links []*rexec.TreeEntry
+	var open []string // directories entered with D records
+	for _, e := range entries {
+		for len(open) > 0 && !strings.HasPrefix(e.Path, open[len(open)-1]+"/") {
+			if err := sendRecord(ctx, scp.w, scp.r, "E\n"); err != nil {
+				return fmt.Errorf("leave dir %q: %w", open[len(open)-1], err)
+			}
+			open = open[:len(open)-1]
+		}
+
+		switch {
+		case e.IsSymlink():
+			links = append(links, e)
+		case e.IsDir():
+			if err := sendRecord(ctx, scp.w, scp.r, fmt.Sprintf("D%04o 0 %s\n", e.Mode.Perm(), path.Base(e.Path))); err != nil {
+				return fmt.Errorf("enter dir %q: %w", e.Path, err)
+			}
+			open = append(open, e.Path)
+		default:
+			n, err := sendEntry(ctx, e, path.Join(spec.TargetDir, e.Path), meter, scp.w, scp.r)
+			sent += n
+			if err != nil {
+				return fmt.Errorf("send file %q: %w", e.Path, err)
+			}
+		}
+	}
+	for ; len(open) > 0; open = open[:len(open)-1] {
+		if err := sendRecord(ctx, scp.w, scp.r, "E\n"); err != nil {
+			return fmt.Errorf("leave dir %q: %w", open[len(open)-1], err)
+		}
+	}
+	if err := scp.finish(); err != nil {
+		return err
+	}
+	scp.sess.Close() // release the session slot before running ln
+
+	if len(links) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(links))
+	for _, e := range links {
+		lines = append(lines, "ln -sfn -- "+escapeShellPath(e.LinkTarget)+" "+escapeShellPath(path.Join(spec.TargetDir, e.Path)))
+	}
+	if err := rexec.RunNoResult[RunOption](ctx, t.client, command.New("%s", command.WithArgs(strings.Join(lines, " && ")))); err != nil {
+		return fmt.Errorf("create symlinks: %w", err)
+	}
+	return nil
+}
+
+// Fetch downloads spec.RemotePath via `scp -f` (source mode): it acknowledges the
+// T (with PreserveTimes) and C records and reads the file data into the destination
+func (t *SCPTransfer) Fetch(ctx context.Context, spec *rexec.FetchSpec, opts ...SCPOption) (err error) {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	var received int64
+	ctx, finishSpan := t.client.cfg.telemetry.StartTransfer(ctx, "ssh.scp.fetch", t.client.cfg.Host, spec.RemotePath)
+	defer func() {
+		finishSpan(received, err)
+		logFetch(t.client, "scp", spec.RemotePath, received, err)
+	}()
+
+	cfg := newScpConfig(0, opts...)
+	args := "-f "
+	if spec.PreserveTimes {
+		args = "-p -f "
+	}
+	scp, err := t.start(ctx, cfg, args+escapeShellPath(spec.RemotePath))
+	if err != nil {
+		return err
+	}
+	defer scp.sess.Close()
+
+	var mtime, atime time.Time
+	for {
+		// ready for the next record
+		if err := sendAck(scp.w); err != nil {
+			return err
+		}
+		line, err := readRecord(ctx, scp.r)
+		if err != nil {
+			return fmt.Errorf("fetch %q: %w", spec.RemotePath, err)
+		}
+
+		switch line[0] {
+		case 'T':
+			var mSec, mUsec, aSec, aUsec int64
+			if _, err := fmt.Sscanf(line[1:], "%d %d %d %d", &mSec, &mUsec, &aSec, &aUsec); err != nil {
+				return fmt.Errorf("bad T record %q: %w", line, err)
+			}
+			mtime, atime = time.Unix(mSec, mUsec*1000), time.Unix(aSec, aUsec*1000)
+			continue
+		case 'C':
+		case 'D':
+			return fmt.Errorf("fetch %q: is a directory", spec.RemotePath)
+		default:
+			return fmt.Errorf("fetch %q: unexpected record %q", spec.RemotePath, line)
+		}
+
+		mode, size, err := parseFileRecord(line[1:])
+		if err != nil {
+			return fmt.Errorf("bad C record %q: %w", line, err)
+		}
+		if err := sendAck(scp.w); err != nil {
+			return err
+		}
+
+		meter := newTransferMeter(ctx, cfg.progress, cfg.limiter)
+		received, err = spec.Write(mode, mtime, atime, func(w io.Writer) (int64, error) {
+			return copyWithContext(ctx, meter.file(spec.RemotePath, size, io.LimitReader(scp.r, size)), w)
+		})
+		if err != nil {
+			return fmt.Errorf("fetch %q: %w", spec.RemotePath, err)
+		}
+		if received != size {
+			return fmt.Errorf("fetch %q: got %d of %d bytes", spec.RemotePath, received, size)
+		}
+		if err := readAck(ctx, scp.r); err != nil {
+			return fmt.Errorf("fetch %q: after data: %w", spec.RemotePath, err)
+		}
+		meter.done()
+		if err := sendAck(scp.w); err != nil {
+			return err
+		}
+		return scp.finish()
+	}
+}
+
+// sizedContent returns content whose size is known upfront, as the C record needs it.
+// A non-seekable Reader without Size is spooled to a temp file if WithSpool allows it;
+// cleanup removes that file
+func (c *scpConfig) sizedContent(ctx context.Context, content *rexec.FileContent) (*rexec.FileContent, func(), error) {
+	noop := func() {}
+	if content == nil || content.Reader == nil {
+		return content, noop, nil
+	}
+	reader, size, err := content.Stream()
+	if err != nil {
+		return nil, noop, err
+	}
+	if size != rexec.UnknownSize {
+		return content, noop, nil
+	}
+	if c.spoolLimit <= 0 {
+		return nil, noop, fmt.Errorf("scp needs the file size before sending: %w "+
+			"(set FileContent.Size, use WithSpool, or upload with SFTP)", utils.ErrUnknownSize)
+	}
+
+	tmp, err := os.CreateTemp(c.spoolDir, "rexec-scp-*")
+	if err != nil {
+		return nil, noop, fmt.Errorf("create spool file: %w", err)
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	n, err := copyWithContext(ctx, io.LimitReader(reader, c.spoolLimit+1), tmp)
+	if err != nil {
+		cleanup()
+		return nil, noop, fmt.Errorf("spool reader: %w", err)
+	}
+	if n > c.spoolLimit {
+		cleanup()
+		return nil, noop, fmt.Errorf("spool reader: stream exceeds the %d byte spool limit", c.spoolLimit)
 	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return nil, noop, fmt.Errorf("close spool file: %w", err)
+	}
+	return &rexec.FileContent{SourcePath: tmp.Name()}, cleanup, nil
+}
+
+// scpSession is a running remote scp
+type scpSession struct {
+	client *Client
+	sess   *Session
+	stdin  io.WriteCloser
+	w      *bufio.Writer
+	r      *bufio.Reader
+	errBuf *bytes.Buffer
+	errCh  chan error
+}
 
-	if err := stdinPipe.Close(); err != nil {
+// start opens a session and runs `scp <args>` in the remote workdir
+func (t *SCPTransfer) start(ctx context.Context, cfg *scpConfig, args string) (*scpSession, error) {
+	sess, err := t.client.OpenSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open ssh session: %w", err)
+	}
+
+	stdinPipe, err := sess.StdinPipe()
+	if err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("get stdinPipe pipe: %w", err)
+	}
+	stdoutPipe, err := sess.StdoutPipe()
+	if err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("get stdoutPipe pipe: %w", err)
+	}
+
+	stderrPipe, err := sess.StderrPipe()
+	if err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("get stderrPipe pipe: %w", err)
+	}
+
+	scp := &scpSession{
+		client: t.client,
+		sess:   sess,
+		stdin:  stdinPipe,
+		w:      bufio.NewWriterSize(stdinPipe, cfg.bufSize),
+		r:      bufio.NewReaderSize(stdoutPipe, cfg.bufSize),
+		errBuf: new(bytes.Buffer),
+		errCh:  make(chan error, 1),
+	}
+	go func() {
+		_, err := copyWithContext(ctx, stderrPipe, scp.errBuf)
+		scp.errCh <- err
+	}()
+
+	scpCmd := cfg.scpBinPath + " " + args
+	if err := sess.Start(workdirCommand(scpCmd, t.client.cfg.remoteWorkdir)); err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("start scp [%s]: %w -- %s", scpCmd, err, scp.errBuf.String())
+	}
+	return scp, nil
+}
+
+// finish closes stdin and waits for scp to exit
+func (s *scpSession) finish() error {
+	if err := s.stdin.Close(); err != nil {
 		return fmt.Errorf("close stdinPipe: %w", err)
 	}
 
-	if waitErr := sess.Wait(); waitErr != nil {
+	if waitErr := s.sess.Wait(); waitErr != nil {
 		var exitErr *exec.ExitError
 		if errors.As(waitErr, &exitErr) {
 			code := exitErr.ExitCode()
-			msg := t.client.mapper.Lookup(code)
+			msg := s.client.mapper.Lookup(code)
 			return fmt.Errorf("scp failed (%s): %w", msg, waitErr)
 		}
-		if drainErr := <-errCh; drainErr != nil {
-			return fmt.Errorf("scp failed: %w -- %s", drainErr, errBuf.String())
+		if drainErr := <-s.errCh; drainErr != nil {
+			return fmt.Errorf("scp failed: %w -- %s", drainErr, s.errBuf.String())
 		}
-		return fmt.Errorf("scp failed: %w -- %s", waitErr, errBuf.String())
+		return fmt.Errorf("scp failed: %w -- %s", waitErr, s.errBuf.String())
+	}
+	if drainErr := <-s.errCh; drainErr != nil {
+		s.client.cfg.log().Warn("scp stderr drain failed", slog.String("host", s.client.cfg.Host), slog.String("error", drainErr.Error()))
 	}
-	<-errCh
 	return nil
 }
 
-// sendFile follows SCP protocol: header → ACK → data → EOF byte → ACK
-func sendFile(ctx context.Context, spec *rexec.FileSpec, w *bufio.Writer, r *bufio.Reader) error {
+// logCopy logs the outcome of a file transfer: Debug on success, Warn on failure
+func logCopy(cl *Client, proto, remotePath string, sent int64, err error) {
+	attrs := []any{
+		slog.String("host", cl.cfg.Host),
+		slog.String("proto", proto),
+		slog.String("path", remotePath),
+		slog.Int64("bytes", sent),
+	}
+	if err != nil {
+		cl.cfg.log().Warn("file copy failed", append(attrs, slog.String("error", err.Error()))...)
+		return
+	}
+	cl.cfg.log().Debug("file copied", attrs...)
+}
+
+// logFetch logs the outcome of a download: Debug on success, Warn on failure
+func logFetch(cl *Client, proto, remotePath string, received int64, err error) {
+	attrs := []any{
+		slog.String("host", cl.cfg.Host),
+		slog.String("proto", proto),
+		slog.String("path", remotePath),
+		slog.Int64("bytes", received),
+	}
+	if err != nil {
+		cl.cfg.log().Warn("file fetch failed", append(attrs, slog.String("error", err.Error()))...)
+		return
+	}
+	cl.cfg.log().Debug("file fetched", attrs...)
+}
+
+// sendFile follows SCP protocol: header → ACK → data → EOF byte → ACK.
+// The data is metered as remotePath. It returns the number of data bytes sent
+func sendFile(ctx context.Context, spec *rexec.FileSpec, remotePath string, meter *transferMeter, w *bufio.Writer, r *bufio.Reader) (int64, error) {
 	reader, size, err := spec.Content.ReaderAndSize()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer reader.Close()
+	n, err := sendContent(ctx, w, r, spec.Mode, spec.Filename, meter.file(remotePath, size, reader), size)
+	if err == nil {
+		meter.done()
+	}
+	return n, err
+}
 
-	header := fmt.Sprintf("C%04o %d %s\n", spec.Mode.Perm(), size, spec.Filename)
-	if _, err := w.WriteString(header); err != nil {
-		return fmt.Errorf("write file header: %w", err)
+// sendEntry sends a regular file of a walked tree as a C record, metered as remotePath
+func sendEntry(ctx context.Context, e *rexec.TreeEntry, remotePath string, meter *transferMeter, w *bufio.Writer, r *bufio.Reader) (int64, error) {
+	reader, err := e.Open()
+	if err != nil {
+		return 0, err
 	}
-	if err := w.Flush(); err != nil {
-		return fmt.Errorf("flush file header: %w", err)
+	defer reader.Close()
+	n, err := sendContent(ctx, w, r, e.Mode, path.Base(e.Path), meter.file(remotePath, e.Size, reader), e.Size)
+	if err == nil {
+		meter.done()
 	}
-	if err := readAck(ctx, r); err != nil {
-		return fmt.Errorf("ACK after header: %w", err)
+	return n, err
+}
+
+// sendContent sends one C record with size bytes of reader as name
+func sendContent(ctx context.Context, w *bufio.Writer, r *bufio.Reader, mode os.FileMode, name string, reader io.Reader, size int64) (int64, error) {
+	header := fmt.Sprintf("C%04o %d %s\n", mode.Perm(), size, name)
+	if err := sendRecord(ctx, w, r, header); err != nil {
+		return 0, fmt.Errorf("file header: %w", err)
 	}
 
 	// data
-	if err := copyWithContext(ctx, reader, w); err != nil {
-		return fmt.Errorf("send file data: %w", err)
+	n, err := copyWithContext(ctx, io.LimitReader(reader, size), w)
+	if err != nil {
+		return n, fmt.Errorf("send file data: %w", err)
+	}
+	if n != size {
+		return n, fmt.Errorf("send file data: source shrank to %d of %d bytes", n, size)
+	}
+	// the record promised size bytes, so a longer source must not end as a truncated file
+	var extra [1]byte
+	if m, _ := io.ReadFull(reader, extra[:]); m > 0 {
+		return n, fmt.Errorf("send file data: source is longer than its size of %d bytes", size)
 	}
 
 	// EOF-byte
 	if err := w.WriteByte(0); err != nil {
-		return fmt.Errorf("write EOF byte: %w", err)
+		return n, fmt.Errorf("write EOF byte: %w", err)
 	}
 
 	if err := w.Flush(); err != nil {
-		return fmt.Errorf("flush file data: %w", err)
+		return n, fmt.Errorf("flush file data: %w", err)
 	}
 
 	if err := readAck(ctx, r); err != nil {
-		return fmt.Errorf("final ACK: %w", err)
+		return n, fmt.Errorf("final ACK: %w", err)
+	}
+	return n, nil
+}
+
+// sendRecord writes one protocol record and waits for its ACK
+func sendRecord(ctx context.Context, w *bufio.Writer, r *bufio.Reader, record string) error {
+	if _, err := w.WriteString(record); err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush record: %w", err)
+	}
+	if err := readAck(ctx, r); err != nil {
+		return fmt.Errorf("ACK after record: %w", err)
+	}
+	return nil
+}
+
+// sendAck writes a zero status byte
+func sendAck(w *bufio.Writer) error {
+	if err := w.WriteByte(0); err != nil {
+		return fmt.Errorf("write ack: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush ack: %w", err)
 	}
 	return nil
 }
 
+// readRecord reads one source-mode record line. Warning (1) and error (2)
+// status bytes are returned as errors carrying the remote message
+func readRecord(ctx context.Context, r *bufio.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("read record: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	if line == "" {
+		return "", fmt.Errorf("empty record")
+	}
+	if line[0] == 1 || line[0] == 2 {
+		return "", fmt.Errorf("scp error: %s", strings.TrimSpace(line[1:]))
+	}
+	return line, nil
+}
+
+// parseFileRecord parses "<mode> <size> <name>" of a C record
+func parseFileRecord(s string) (os.FileMode, int64, error) {
+	fields := strings.SplitN(s, " ", 3)
+	if len(fields) != 3 || fields[2] == "" {
+		return 0, 0, fmt.Errorf("want mode, size and name")
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("mode: %w", err)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, fmt.Errorf("size %q", fields[1])
+	}
+	return os.FileMode(mode).Perm(), size, nil
+}
+
 // readAck reads one status byte and returns error if non-zero
 func readAck(ctx context.Context, r *bufio.Reader) error {
 	if err := ctx.Err(); err != nil {
@@ -225,30 +801,33 @@ var bufPool = sync.Pool{
 	},
 }
 
-// copyWithContext copies from src to dst in chunks, aborting on context cancel
-func copyWithContext(ctx context.Context, src io.Reader, dst io.Writer) error {
+// copyWithContext copies from src to dst in chunks, aborting on context cancel.
+// It returns the number of bytes written
+func copyWithContext(ctx context.Context, src io.Reader, dst io.Writer) (int64, error) {
 	buf := bufPool.Get().([]byte)
 	defer bufPool.Put(buf)
+	var total int64
 	for {
 		if err := ctx.Err(); err != nil {
-			return err
+			return total, err
 		}
 		n, rerr := src.Read(buf)
 		if n > 0 {
 			if _, werr := dst.Write(buf[:n]); werr != nil {
-				return werr
+				return total, werr
 			}
+			total += int64(n)
 		}
 		if rerr != nil {
 			if errors.Is(rerr, io.EOF) {
-				return nil
+				return total, nil
 			}
-			return rerr
+			return total, rerr
 		}
 	}
 }
 
 // escapeShellPath safely quotes a path for sh single-quoted strings
 func escapeShellPath(path string) string {
-	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+	return command.Quote(path)
 }
@@ -58,6 +58,16 @@ func TestSendSingleFile(t *testing.T) {
 			&rexec.FileContent{SourcePath: tempFile},
 			"",
 		},
+		{
+			"empty stream",
+			&rexec.FileContent{Reader: bufio.NewReader(strings.NewReader("")), SizeKnown: true},
+			"",
+		},
+		{
+			"stream longer than size",
+			&rexec.FileContent{Reader: bufio.NewReader(strings.NewReader("too long")), Size: 3},
+			"source is longer than its size of 3 bytes",
+		},
 	}
 
 	for _, tc := range tests {
@@ -72,7 +82,7 @@ func TestSendSingleFile(t *testing.T) {
 				Content:   tc.content,
 			}
 
-			err := sendFile(context.Background(), spec, w, bufio.NewReader(ackBuf))
+			_, err := sendFile(context.Background(), spec, spec.Filename, nil, w, bufio.NewReader(ackBuf))
 
 			if tc.expectErr != "" {
 				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
@@ -4,13 +4,20 @@ package ssh
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ngrsoftlab/rexec"
+	"github.com/ngrsoftlab/rexec/command"
 	"github.com/pkg/sftp"
 )
 
@@ -25,6 +32,10 @@ type SFTPOption func(*sftpConfig)
 type sftpConfig struct {
 	bufferSize int         // size for copy buffer
 	folderMode os.FileMode // mode for directories
+	progress   rexec.ProgressFunc
+	limiter    *rexec.BandwidthLimiter
+	resume     bool // continue partial files instead of starting over
+	resumeHash bool // compare the SHA-256 of the kept prefix before resuming
 }
 
 // newSFTPConfig builds a config using spec.FolderMode (if non-zero) and opts
@@ -54,6 +65,48 @@ func WithSFTPBufferSize(n int) SFTPOption {
 	}
 }
 
+// WithSFTPProgress reports the progress of file data sent or received to fn
+func WithSFTPProgress(fn rexec.ProgressFunc) SFTPOption {
+	return func(c *sftpConfig) {
+		c.progress = fn
+	}
+}
+
+// WithSFTPBandwidthLimit caps the file data rate of one transfer call at bytesPerSec
+func WithSFTPBandwidthLimit(bytesPerSec int64) SFTPOption {
+	return func(c *sftpConfig) {
+		c.limiter = rexec.NewBandwidthLimiter(bytesPerSec)
+	}
+}
+
+// WithSFTPBandwidthLimiter shares l between transfers, capping their combined rate
+func WithSFTPBandwidthLimiter(l *rexec.BandwidthLimiter) SFTPOption {
+	return func(c *sftpConfig) {
+		c.limiter = l
+	}
+}
+
+// WithSFTPResume makes Copy and Fetch (to LocalPath) resumable. Data is written to a
+// partial file next to the target (rexec.PartialName), which is kept when a transfer
+// fails and renamed over the target once complete. A later call keeps as many bytes of
+// the partial file as it holds, if it is not longer than the source, and sends the
+// rest. With verifyHash the kept prefix must also match the source by SHA-256
+// (the remote side is read back over the same SFTP session); otherwise the transfer starts over
+func WithSFTPResume(verifyHash bool) SFTPOption {
+	return func(c *sftpConfig) {
+		c.resume = true
+		c.resumeHash = verifyHash
+	}
+}
+
+// interface guards: ensure SFTPTransfer satisfies rexec.FileTransfer, rexec.CheckedTransfer, rexec.DirTransfer and rexec.FileFetcher
+var (
+	_ rexec.FileTransfer[SFTPOption]    = (*SFTPTransfer)(nil)
+	_ rexec.CheckedTransfer[SFTPOption] = (*SFTPTransfer)(nil)
+	_ rexec.DirTransfer[SFTPOption]     = (*SFTPTransfer)(nil)
+	_ rexec.FileFetcher[SFTPOption]     = (*SFTPTransfer)(nil)
+)
+
 // SFTPTransfer implements FileTransfer over SSH using the SFTP subsystem
 type SFTPTransfer struct {
 	client *Client
@@ -65,12 +118,164 @@ func NewSFTPTransfer(client *Client) *SFTPTransfer {
 }
 
 // Copy uploads spec.Content to spec.TargetDir on the remote host via SFTP.
-// It creates directories, writes the file, and applies permissions
+// It creates directories, writes the file (through a renamed temp file with
+// spec.Atomic), and applies permissions
 func (t *SFTPTransfer) Copy(ctx context.Context, spec *rexec.FileSpec, opts ...SFTPOption) error {
+	_, err := t.CopyChecked(ctx, spec, opts...)
+	return err
+}
+
+// CopyChecked is Copy that reports whether the file changed. Remote checksums are
+// computed by reading the file back over the same SFTP session: with
+// spec.SkipUnchanged before the upload, with spec.Verify after it.
+// spec.Backup copies the existing file over the session as well; owner and group
+// names are resolved to ids by `id -u` and `getent group` on the target.
+// With WithSFTPResume, CopyResult.Resumed counts the bytes kept from a partial upload
+func (t *SFTPTransfer) CopyChecked(ctx context.Context, spec *rexec.FileSpec, opts ...SFTPOption) (_ *rexec.CopyResult, err error) {
 	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	res := &rexec.CopyResult{Changed: true}
+	ctx, finishSpan := t.client.cfg.telemetry.StartTransfer(ctx, "ssh.sftp.copy", t.client.cfg.Host,
+		path.Join(spec.TargetDir, spec.Filename))
+	defer func() {
+		finishSpan(res.Bytes, err)
+		logCopy(t.client, "sftp", path.Join(spec.TargetDir, spec.Filename), res.Bytes, err)
+	}()
+
+	if spec.NeedsChecksum() {
+		if res.Checksum, err = spec.Content.SHA256(); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := newSFTPConfig(spec.FolderMode, opts...)
+
+	// names are resolved with separate commands before the SFTP session takes a session slot
+	attrs, err := t.fileAttrs(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	sftpCli, sess, err := t.openSFTPSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		sftpCli.Close()
+		sess.Close()
+		sess.Wait()
+	}()
+
+	targetDir := t.client.resolvePath(spec.TargetDir)
+	remotePath := path.Join(targetDir, spec.Filename)
+	partialPath := path.Join(targetDir, rexec.PartialName(spec.Filename))
+	if spec.SkipUnchanged {
+		current, err := remoteChecksumSFTP(ctx, sftpCli, remotePath, cfg.bufferSize)
+		if err != nil {
+			return nil, err
+		}
+		if current == res.Checksum {
+			res.Changed = false
+			return res, nil
+		}
+	}
+
+	if err := sftpCli.MkdirAll(targetDir); err != nil {
+		return nil, fmt.Errorf("sftp create target dir: %w", err)
+	}
+	if err := sftpCli.Chmod(targetDir, cfg.folderMode); err != nil {
+		return nil, fmt.Errorf("sftp chmod dir: %w", err)
+	}
+
+	if spec.Backup {
+		backupPath := path.Join(targetDir, rexec.BackupName(spec.Filename, time.Now()))
+		if err := backupRemote(ctx, sftpCli, remotePath, backupPath, cfg.bufferSize); err != nil {
+			return nil, err
+		}
+	}
+
+	source, size, err := spec.Content.Stream()
+	if err != nil {
+		return nil, fmt.Errorf("sftp read source data: %w", err)
+	}
+	defer source.Close()
+
+	if cfg.resume {
+		if res.Resumed, err = resumeOffset(ctx, sftpCli, partialPath, spec.Content, size, cfg.resumeHash, cfg.bufferSize); err != nil {
+			return nil, err
+		}
+		if err := skipSource(spec.Content, source, res.Resumed); err != nil {
+			return nil, err
+		}
+		if size != rexec.UnknownSize {
+			size -= res.Resumed
+		}
+	}
+
+	meter := newTransferMeter(ctx, cfg.progress, cfg.limiter)
+	reader := meter.file(path.Join(spec.TargetDir, spec.Filename), size, source)
+	switch {
+	case cfg.resume:
+		res.Bytes, err = writeRemoteResume(ctx, sftpCli, partialPath, remotePath, res.Resumed, reader, spec.Mode, cfg.bufferSize, attrs)
+	case spec.Atomic:
+		res.Bytes, err = writeRemoteAtomic(ctx, sftpCli, remotePath, reader, spec.Mode, cfg.bufferSize, attrs)
+	default:
+		if res.Bytes, err = writeRemote(ctx, sftpCli, remotePath, reader, spec.Mode, cfg.bufferSize); err == nil {
+			err = attrs.apply(sftpCli, remotePath)
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+	meter.done()
+
+	if spec.Verify {
+		written, err := remoteChecksumSFTP(ctx, sftpCli, remotePath, cfg.bufferSize)
+		if err != nil {
+			return res, err
+		}
+		if err := rexec.VerifyChecksum(remotePath, res.Checksum, written); err != nil {
+			return res, err
+		}
+		res.Verified = true
+	}
+	return res, nil
+}
+
+// remoteChecksumSFTP reads remotePath back and returns its hex SHA-256, or "" if it does not exist
+func remoteChecksumSFTP(ctx context.Context, cli *sftp.Client, remotePath string, bufSize int) (string, error) {
+	f, err := cli.Open(remotePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("sftp open remote file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := copyBuffer(ctx, h, f, bufSize); err != nil {
+		return "", fmt.Errorf("sftp checksum remote file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CopyDir uploads the tree described by spec over one SFTP session,
+// keeping file and directory modes and recreating symlinks if requested
+func (t *SFTPTransfer) CopyDir(ctx context.Context, spec *rexec.DirSpec, opts ...SFTPOption) (err error) {
+	entries, err := spec.Walk()
+	if err != nil {
 		return err
 	}
 
+	var sent int64
+	ctx, finishSpan := t.client.cfg.telemetry.StartTransfer(ctx, "ssh.sftp.copy_dir", t.client.cfg.Host, spec.TargetDir)
+	defer func() {
+		finishSpan(sent, err)
+		logCopy(t.client, "sftp", spec.TargetDir, sent, err)
+	}()
+
 	cfg := newSFTPConfig(spec.FolderMode, opts...)
 
 	sftpCli, sess, err := t.openSFTPSession(ctx)
@@ -83,50 +288,482 @@ func (t *SFTPTransfer) Copy(ctx context.Context, spec *rexec.FileSpec, opts ...S
 		sess.Wait()
 	}()
 
-	if err := sftpCli.MkdirAll(spec.TargetDir); err != nil {
+	targetDir := t.client.resolvePath(spec.TargetDir)
+	if err := sftpCli.MkdirAll(targetDir); err != nil {
 		return fmt.Errorf("sftp create target dir: %w", err)
 	}
-	if err := sftpCli.Chmod(spec.TargetDir, cfg.folderMode); err != nil {
+	if err := sftpCli.Chmod(targetDir, cfg.folderMode); err != nil {
 		return fmt.Errorf("sftp chmod dir: %w", err)
 	}
 
-	remotePath := path.Join(spec.TargetDir, spec.Filename)
-	f, err := sftpCli.OpenFile(remotePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	meter := newTransferMeter(ctx, cfg.progress, cfg.limiter).expect(total)
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		remotePath := path.Join(targetDir, e.Path)
+
+		switch {
+		case e.IsSymlink():
+			if err := sftpCli.Remove(remotePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("sftp replace %s: %w", remotePath, err)
+			}
+			if err := sftpCli.Symlink(e.LinkTarget, remotePath); err != nil {
+				return fmt.Errorf("sftp symlink %s: %w", remotePath, err)
+			}
+		case e.IsDir():
+			if err := sftpCli.MkdirAll(remotePath); err != nil {
+				return fmt.Errorf("sftp create dir %s: %w", remotePath, err)
+			}
+			if err := sftpCli.Chmod(remotePath, e.Mode.Perm()); err != nil {
+				return fmt.Errorf("sftp chmod dir %s: %w", remotePath, err)
+			}
+		default:
+			reader, err := e.Open()
+			if err != nil {
+				return err
+			}
+			n, err := writeRemote(ctx, sftpCli, remotePath, meter.file(path.Join(spec.TargetDir, e.Path), e.Size, reader), e.Mode.Perm(), cfg.bufferSize)
+			reader.Close()
+			sent += n
+			if err != nil {
+				return fmt.Errorf("%s: %w", e.Path, err)
+			}
+			meter.done()
+		}
+	}
+	return nil
+}
+
+// Fetch downloads spec.RemotePath over SFTP into the destination of spec,
+// using the remote mode and, with PreserveTimes, the remote access and modification times.
+// With WithSFTPResume a LocalPath download continues spec.PartialPath
+func (t *SFTPTransfer) Fetch(ctx context.Context, spec *rexec.FetchSpec, opts ...SFTPOption) (err error) {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	var received int64
+	ctx, finishSpan := t.client.cfg.telemetry.StartTransfer(ctx, "ssh.sftp.fetch", t.client.cfg.Host, spec.RemotePath)
+	defer func() {
+		finishSpan(received, err)
+		logFetch(t.client, "sftp", spec.RemotePath, received, err)
+	}()
+
+	cfg := newSFTPConfig(0, opts...)
+	remotePath := t.client.resolvePath(spec.RemotePath)
+
+	resume := cfg.resume && spec.LocalPath != ""
+	sftpCli, sess, err := t.openSFTPSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		sftpCli.Close()
+		sess.Close()
+		sess.Wait()
+	}()
+
+	f, err := sftpCli.Open(remotePath)
 	if err != nil {
-		return fmt.Errorf("sftp open file: %w", err)
+		return fmt.Errorf("sftp open remote file: %w", err)
 	}
 	defer f.Close()
 
-	reader, _, err := spec.Content.ReaderAndSize()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("sftp stat remote file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("sftp fetch %q: not a regular file", spec.RemotePath)
+	}
+	var atime time.Time
+	if st, ok := info.Sys().(*sftp.FileStat); ok {
+		atime = time.Unix(int64(st.Atime), 0)
+	}
+
+	meter := newTransferMeter(ctx, cfg.progress, cfg.limiter)
+	if resume {
+		offset, err := fetchOffset(ctx, spec, f, info.Size(), cfg.resumeHash, cfg.bufferSize)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("sftp seek remote file: %w", err)
+		}
+		received, err = spec.WriteFrom(offset, info.Mode(), info.ModTime(), atime, func(w io.Writer) (int64, error) {
+			return copyBuffer(ctx, w, meter.file(spec.RemotePath, info.Size()-offset, f), cfg.bufferSize)
+		})
+	} else {
+		received, err = spec.Write(info.Mode(), info.ModTime(), atime, func(w io.Writer) (int64, error) {
+			return copyBuffer(ctx, w, meter.file(spec.RemotePath, info.Size(), f), cfg.bufferSize)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("sftp fetch %q: %w", spec.RemotePath, err)
+	}
+	meter.done()
+	return nil
+}
+
+// fetchOffset returns how many bytes of spec.PartialPath a resumed download of the remote
+// file remote (of size remoteSize) keeps: all of them, unless they are more than the remote file
+// holds or verifyHash is set and they differ from the start of remote, read over the SFTP session
+func fetchOffset(ctx context.Context, spec *rexec.FetchSpec, remote *sftp.File, remoteSize int64, verifyHash bool, bufSize int) (int64, error) {
+	offset, err := spec.PartialSize()
+	if err != nil || offset > remoteSize {
+		return 0, err
+	}
+	if offset == 0 || !verifyHash {
+		return offset, nil
+	}
+
+	f, err := os.Open(spec.PartialPath())
+	if err != nil {
+		return 0, fmt.Errorf("open partial file: %w", err)
+	}
+	defer f.Close()
+	local, err := rexec.SumSHA256(f)
 	if err != nil {
-		return fmt.Errorf("sftp read source data: %w", err)
+		return 0, fmt.Errorf("checksum partial file: %w", err)
 	}
-	defer reader.Close()
+	h := sha256.New()
+	if _, err := copyBuffer(ctx, h, io.LimitReader(remote, offset), bufSize); err != nil {
+		return 0, fmt.Errorf("sftp checksum remote file: %w", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != local {
+		return 0, nil
+	}
+	return offset, nil
+}
 
-	buf := make([]byte, cfg.bufferSize)
+// copyBuffer copies src to dst through a bufSize buffer, checking ctx between chunks
+func copyBuffer(ctx context.Context, dst io.Writer, src io.Reader, bufSize int) (int64, error) {
+	var n int64
+	buf := make([]byte, bufSize)
 	for {
 		if err := ctx.Err(); err != nil {
-			return err
+			return n, err
 		}
-		n, rErr := reader.Read(buf)
-		if n > 0 {
-			if _, err := f.Write(buf[:n]); err != nil {
-				return fmt.Errorf("sftp write remote data: %w", err)
+		nr, rErr := src.Read(buf)
+		if nr > 0 {
+			nw, wErr := dst.Write(buf[:nr])
+			n += int64(nw)
+			if wErr != nil {
+				return n, wErr
 			}
 		}
 		if rErr != nil {
 			if errors.Is(rErr, io.EOF) {
-				break
+				return n, nil
 			}
-			return fmt.Errorf("sftp read source data: %w", rErr)
+			return n, rErr
+		}
+	}
+}
+
+// writeRemote writes reader to remotePath (created or truncated) and applies mode.
+// It returns the number of bytes written
+func writeRemote(ctx context.Context, cli *sftp.Client, remotePath string, reader io.Reader, mode os.FileMode, bufSize int) (int64, error) {
+	f, err := cli.OpenFile(remotePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return 0, fmt.Errorf("sftp open file: %w", err)
+	}
+	defer f.Close()
+
+	sent, err := fillRemote(ctx, f, reader, bufSize)
+	if err != nil {
+		return sent, err
+	}
+	if err := f.Chmod(mode); err != nil {
+		return sent, fmt.Errorf("sftp chmod file: %w", err)
+	}
+	return sent, nil
+}
+
+// writeRemoteAtomic writes reader to a hidden temp file next to remotePath, applies
+// mode and attrs, syncs it (if the server supports fsync@openssh.com) and renames it over
+// remotePath. Without posix-rename@openssh.com the target is removed first, leaving
+// a short window without it. The temp file is removed if any step fails
+func writeRemoteAtomic(ctx context.Context, cli *sftp.Client, remotePath string, reader io.Reader, mode os.FileMode, bufSize int, attrs *remoteAttrs) (sent int64, err error) {
+	tmpPath := path.Join(path.Dir(remotePath), rexec.AtomicTempName(path.Base(remotePath)))
+	f, err := cli.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	if err != nil {
+		return 0, fmt.Errorf("sftp create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			if rmErr := cli.Remove(tmpPath); rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+				err = errors.Join(err, fmt.Errorf("sftp remove temp file: %w", rmErr))
+			}
+		}
+	}()
+
+	if sent, err = fillRemote(ctx, f, reader, bufSize); err != nil {
+		return sent, err
+	}
+	return sent, commitRemote(cli, f, tmpPath, remotePath, mode, attrs)
+}
+
+// writeRemoteResume continues the partial upload at partialPath, which already holds
+// offset bytes of the file, with reader and commits it to remotePath. Unlike
+// writeRemoteAtomic, the partial file is kept on failure so that it can be resumed
+func writeRemoteResume(ctx context.Context, cli *sftp.Client, partialPath, remotePath string, offset int64, reader io.Reader, mode os.FileMode, bufSize int, attrs *remoteAttrs) (int64, error) {
+	f, err := cli.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return 0, fmt.Errorf("sftp open partial file: %w", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return 0, fmt.Errorf("sftp truncate partial file: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("sftp seek partial file: %w", err)
+	}
+
+	sent, err := fillRemote(ctx, f, reader, bufSize)
+	if err != nil {
+		return sent, err
+	}
+	return sent, commitRemote(cli, f, partialPath, remotePath, mode, attrs)
+}
+
+// commitRemote applies mode and attrs to the complete file f at tmpPath, syncs it
+// (if the server supports fsync@openssh.com), closes it and renames it over remotePath.
+// Without posix-rename@openssh.com the target is removed first, leaving a short
+// window without it
+func commitRemote(cli *sftp.Client, f *sftp.File, tmpPath, remotePath string, mode os.FileMode, attrs *remoteAttrs) error {
+	if err := f.Chmod(mode); err != nil {
+		return fmt.Errorf("sftp chmod temp file: %w", err)
+	}
+	if _, ok := cli.HasExtension("fsync@openssh.com"); ok {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("sftp sync temp file: %w", err)
 		}
 	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("sftp close temp file: %w", err)
+	}
+	if err := attrs.apply(cli, tmpPath); err != nil {
+		return err
+	}
 
-	if err := f.Chmod(spec.Mode); err != nil {
-		return fmt.Errorf("sftp chmod file: %w", err)
+	var err error
+	if _, ok := cli.HasExtension("posix-rename@openssh.com"); ok {
+		err = cli.PosixRename(tmpPath, remotePath)
+	} else {
+		if err = cli.Remove(remotePath); err == nil || errors.Is(err, fs.ErrNotExist) {
+			err = cli.Rename(tmpPath, remotePath)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("sftp rename temp file: %w", err)
 	}
 	return nil
+}
+
+// resumeOffset returns how many bytes of the partial upload at partialPath can be kept:
+// its size if it is not longer than the content (of the given size) and, when verify is
+// set, the partial file read back over cli has the SHA-256 of that many content bytes. Otherwise 0
+func resumeOffset(ctx context.Context, cli *sftp.Client, partialPath string, content *rexec.FileContent, size int64, verify bool, bufSize int) (int64, error) {
+	info, err := cli.Stat(partialPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("sftp stat partial file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("sftp partial file %s is not a regular file", partialPath)
+	}
+	n := info.Size()
+	if size != rexec.UnknownSize && n > size {
+		return 0, nil
+	}
+	if verify && n > 0 {
+		local, err := content.PrefixSHA256(n)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		partialSum, err := remoteChecksumSFTP(ctx, cli, partialPath, bufSize)
+		if err != nil {
+			return 0, err
+		}
+		if local != partialSum {
+			return 0, nil
+		}
+	}
+	return n, nil
+}
 
+// skipSource advances source, opened from content, past n bytes that were already
+// uploaded: by seeking when the source allows it, otherwise by discarding them
+func skipSource(content *rexec.FileContent, source io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	seeker, ok := source.(io.Seeker)
+	if !ok && len(content.Data) == 0 && content.SourcePath == "" && content.FS == nil {
+		seeker, ok = content.Reader.(io.Seeker)
+	}
+	if ok {
+		if _, err := seeker.Seek(n, io.SeekCurrent); err != nil {
+			return fmt.Errorf("seek source past resumed bytes: %w", err)
+		}
+		return nil
+	}
+	if skipped, err := io.CopyN(io.Discard, source, n); err != nil {
+		return fmt.Errorf("skip resumed bytes: source has %d of %d: %w", skipped, n, err)
+	}
+	return nil
+}
+
+// remoteAttrs are the owner and times applied to an uploaded file
+type remoteAttrs struct {
+	uid, gid     int // -1 keeps the current id
+	mtime, atime time.Time
+}
+
+// fileAttrs resolves the owner, group and times of spec; nil means nothing to apply
+func (t *SFTPTransfer) fileAttrs(ctx context.Context, spec *rexec.FileSpec) (*remoteAttrs, error) {
+	mtime, atime, err := spec.Times()
+	if err != nil {
+		return nil, err
+	}
+	if spec.Owner == "" && spec.Group == "" && mtime.IsZero() {
+		return nil, nil
+	}
+
+	attrs := &remoteAttrs{uid: -1, gid: -1, mtime: mtime, atime: atime}
+	if spec.Owner != "" {
+		if attrs.uid, err = t.lookupID(ctx, spec.Owner, "id -u -- %s"); err != nil {
+			return nil, fmt.Errorf("lookup owner %s: %w", spec.Owner, err)
+		}
+	}
+	if spec.Group != "" {
+		if attrs.gid, err = t.lookupID(ctx, spec.Group, "getent group -- %s | cut -d: -f3"); err != nil {
+			return nil, fmt.Errorf("lookup group %s: %w", spec.Group, err)
+		}
+	}
+	return attrs, nil
+}
+
+// lookupID returns name if it is numeric, otherwise the id printed by the lookup command
+func (t *SFTPTransfer) lookupID(ctx context.Context, name, lookup string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	stdout, _, _, err := rexec.RunRaw[RunOption](ctx, t.client, command.New(lookup, command.WithArgs(escapeShellPath(name))))
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(stdout))
+	if err != nil {
+		return 0, fmt.Errorf("no such user or group")
+	}
+	return id, nil
+}
+
+// apply chowns and sets the times of remotePath; a nil receiver does nothing
+func (a *remoteAttrs) apply(cli *sftp.Client, remotePath string) error {
+	if a == nil {
+		return nil
+	}
+	if a.uid >= 0 || a.gid >= 0 {
+		uid, gid := a.uid, a.gid
+		if uid < 0 || gid < 0 {
+			info, err := cli.Stat(remotePath)
+			if err != nil {
+				return fmt.Errorf("sftp stat file: %w", err)
+			}
+			st, ok := info.Sys().(*sftp.FileStat)
+			if !ok {
+				return fmt.Errorf("sftp stat file: no owner information")
+			}
+			if uid < 0 {
+				uid = int(st.UID)
+			}
+			if gid < 0 {
+				gid = int(st.GID)
+			}
+		}
+		if err := cli.Chown(remotePath, uid, gid); err != nil {
+			return fmt.Errorf("sftp chown file: %w", err)
+		}
+	}
+	if !a.mtime.IsZero() {
+		if err := cli.Chtimes(remotePath, a.atime, a.mtime); err != nil {
+			return fmt.Errorf("sftp set file times: %w", err)
+		}
+	}
+	return nil
+}
+
+// backupRemote copies the regular file remotePath to backupPath over the SFTP session,
+// keeping its mode, times and, where the server allows it, its owner; a missing remotePath is not an error
+func backupRemote(ctx context.Context, cli *sftp.Client, remotePath, backupPath string, bufSize int) error {
+	src, err := cli.Open(remotePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("sftp open file to back up: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("sftp stat file to back up: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("sftp back up %s: not a regular file", remotePath)
+	}
+	if _, err := writeRemote(ctx, cli, backupPath, src, info.Mode().Perm(), bufSize); err != nil {
+		return fmt.Errorf("sftp backup: %w", err)
+	}
+	atime := info.ModTime()
+	if st, ok := info.Sys().(*sftp.FileStat); ok {
+		atime = time.Unix(int64(st.Atime), 0)
+		// like cp -p, keep the owner only where the server allows it
+		_ = cli.Chown(backupPath, int(st.UID), int(st.GID))
+	}
+	if err := cli.Chtimes(backupPath, atime, info.ModTime()); err != nil {
+		return fmt.Errorf("sftp set backup times: %w", err)
+	}
+	return nil
+}
+
+// fillRemote copies reader into f through a bufSize buffer, checking ctx between chunks
+func fillRemote(ctx context.Context, f *sftp.File, reader io.Reader, bufSize int) (int64, error) {
+	var sent int64
+	buf := make([]byte, bufSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		n, rErr := reader.Read(buf)
+		if n > 0 {
+			if _, err := f.Write(buf[:n]); err != nil {
+				return sent, fmt.Errorf("sftp write remote data: %w", err)
+			}
+			sent += int64(n)
+		}
+		if rErr != nil {
+			if errors.Is(rErr, io.EOF) {
+				return sent, nil
+			}
+			return sent, fmt.Errorf("sftp read source data: %w", rErr)
+		}
+	}
 }
 
 // openSFTPSession starts an SSH session, requests the "sftp" subsystem,
@@ -0,0 +1,134 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package sshtest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Pipe returns the client end of an in-memory connection served by s.
+// Unlike net.Pipe its writes are buffered, so both SSH peers can send
+// their version banners at the same time without deadlocking
+func (s *Server) Pipe() net.Conn {
+	client, server := newBufferedPipe()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.ServeConn(server)
+	}()
+	return client
+}
+
+// newBufferedPipe creates two connected in-memory net.Conns with unbounded buffers
+func newBufferedPipe() (net.Conn, net.Conn) {
+	ab, ba := newPipeBuffer(), newPipeBuffer()
+	return &pipeConn{r: ba, w: ab, name: "sshtest-client"}, &pipeConn{r: ab, w: ba, name: "sshtest-server"}
+}
+
+// pipeBuffer is one direction of a buffered pipe
+type pipeBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	data     []byte
+	closed   bool
+	deadline time.Time // read deadline
+	timer    *time.Timer
+}
+
+func newPipeBuffer() *pipeBuffer {
+	b := &pipeBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// read blocks until data is available, the buffer is closed or the deadline passes
+func (b *pipeBuffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.data) == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		b.cond.Wait()
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+// write appends p to the buffer
+func (b *pipeBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// close wakes up readers; buffered data can still be read
+func (b *pipeBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// setDeadline sets the read deadline and wakes readers when it passes
+func (b *pipeBuffer) setDeadline(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadline = t
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if !t.IsZero() {
+		b.timer = time.AfterFunc(time.Until(t), func() {
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		})
+	}
+	b.cond.Broadcast()
+}
+
+// pipeConn is one end of a buffered pipe
+type pipeConn struct {
+	r, w *pipeBuffer
+	name string
+	once sync.Once
+}
+
+func (c *pipeConn) Read(p []byte) (int, error)  { return c.r.read(p) }
+func (c *pipeConn) Write(p []byte) (int, error) { return c.w.write(p) }
+func (c *pipeConn) LocalAddr() net.Addr         { return pipeAddr(c.name) }
+func (c *pipeConn) RemoteAddr() net.Addr        { return pipeAddr(c.name) }
+
+// Close closes both directions
+func (c *pipeConn) Close() error {
+	c.once.Do(func() {
+		c.r.close()
+		c.w.close()
+	})
+	return nil
+}
+
+// SetDeadline sets the read deadline; writes never block
+func (c *pipeConn) SetDeadline(t time.Time) error      { c.r.setDeadline(t); return nil }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { c.r.setDeadline(t); return nil }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// pipeAddr is the net.Addr of a buffered pipe end
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
@@ -0,0 +1,302 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package sshtest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scpArgs is a parsed `scp -t`/`scp -f` command line
+type scpArgs struct {
+	sink      bool   // -t: receive files
+	source    bool   // -f: send files
+	recursive bool   // -r
+	preserve  bool   // -p
+	path      string // target (sink) or source path
+}
+
+// parseSCP recognises scp remote-side command lines such as `scp -r -t '/tmp/dir'`
+func parseSCP(cmdline string) (scpArgs, bool) {
+	words, ok := splitWords(cmdline)
+	if !ok || len(words) < 2 || path.Base(words[0]) != "scp" {
+		return scpArgs{}, false
+	}
+
+	var args scpArgs
+	rest := words[1:]
+	for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+		for _, f := range rest[0][1:] {
+			switch f {
+			case 't':
+				args.sink = true
+			case 'f':
+				args.source = true
+			case 'r':
+				args.recursive = true
+			case 'p':
+				args.preserve = true
+			case 'd', 'v', 'q':
+			default:
+				return scpArgs{}, false
+			}
+		}
+		rest = rest[1:]
+	}
+	if len(rest) != 1 || args.sink == args.source {
+		return scpArgs{}, false
+	}
+	args.path = rest[0]
+	return args, true
+}
+
+// splitWords splits a command line into words following POSIX sh quoting rules
+// for single quotes, double quotes and backslashes
+func splitWords(s string) ([]string, bool) {
+	var words []string
+	var cur strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, false
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+				}
+				cur.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, false
+			}
+			inWord = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, true
+}
+
+// runSCP serves the scp protocol for a parsed command
+func runSCP(args scpArgs, req *ExecRequest) int {
+	if args.sink {
+		return scpSink(args, req)
+	}
+	return scpSource(args, req)
+}
+
+// scpSource implements `scp -f` for a single regular file: it waits for the client's
+// ready byte, sends a T record (with -p) and a C record, then the data and a status byte
+func scpSource(args scpArgs, req *ExecRequest) int {
+	r := bufio.NewReader(req.Stdin)
+	w := req.Stdout
+
+	fail := func(format string, a ...any) int {
+		msg := fmt.Sprintf(format, a...)
+		_, _ = fmt.Fprintf(w, "\x01scp: %s\n", msg)
+		fmt.Fprintln(req.Stderr, "scp: "+msg)
+		return 1
+	}
+	waitAck := func() bool {
+		b, err := r.ReadByte()
+		return err == nil && b == 0
+	}
+
+	if !waitAck() {
+		return 1
+	}
+	info, err := os.Stat(args.path)
+	if err != nil {
+		return fail("%s: No such file or directory", args.path)
+	}
+	if !info.Mode().IsRegular() {
+		return fail("%s: not a regular file", args.path)
+	}
+	f, err := os.Open(args.path)
+	if err != nil {
+		return fail("%s: %v", args.path, err)
+	}
+	defer f.Close()
+
+	if args.preserve {
+		mtime := info.ModTime().Unix()
+		if _, err := fmt.Fprintf(w, "T%d 0 %d 0\n", mtime, mtime); err != nil || !waitAck() {
+			return 1
+		}
+	}
+	if _, err := fmt.Fprintf(w, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), filepath.Base(args.path)); err != nil || !waitAck() {
+		return 1
+	}
+	if _, err := io.CopyN(w, f, info.Size()); err != nil {
+		return 1
+	}
+	if _, err := w.Write([]byte{0}); err != nil || !waitAck() {
+		return 1
+	}
+	return 0
+}
+
+// scpSink implements `scp -t`: it receives C (file), D/E (directory) and T (times) records
+func scpSink(args scpArgs, req *ExecRequest) int {
+	r := bufio.NewReader(req.Stdin)
+	w := req.Stdout
+
+	ack := func() { _, _ = w.Write([]byte{0}) }
+	fail := func(format string, a ...any) int {
+		msg := fmt.Sprintf(format, a...)
+		_, _ = fmt.Fprintf(w, "\x02%s\n", msg)
+		fmt.Fprintln(req.Stderr, "scp: "+msg)
+		return 1
+	}
+
+	dirs := []string{args.path}
+	var times *[2]time.Time
+	var dirTimes []*[2]time.Time
+
+	ack()
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return 0
+		}
+		if err != nil {
+			return fail("read record: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return fail("empty record")
+		}
+		cur := dirs[len(dirs)-1]
+
+		switch line[0] {
+		case 'T':
+			var mtime, atime int64
+			var mus, aus int64
+			if _, err := fmt.Sscanf(line[1:], "%d %d %d %d", &mtime, &mus, &atime, &aus); err != nil {
+				return fail("bad T record %q", line)
+			}
+			times = &[2]time.Time{time.Unix(atime, aus*1000), time.Unix(mtime, mus*1000)}
+			ack()
+
+		case 'C', 'D':
+			mode, size, name, err := parseRecord(line[1:])
+			if err != nil {
+				return fail("bad record %q: %v", line, err)
+			}
+			dest := cur
+			if info, err := os.Stat(cur); err == nil && info.IsDir() {
+				dest = filepath.Join(cur, name)
+			}
+
+			if line[0] == 'D' {
+				if err := os.MkdirAll(dest, mode); err != nil {
+					return fail("mkdir %s: %v", dest, err)
+				}
+				if err := os.Chmod(dest, mode); err != nil {
+					return fail("chmod %s: %v", dest, err)
+				}
+				dirs = append(dirs, dest)
+				dirTimes = append(dirTimes, times)
+				times = nil
+				ack()
+				continue
+			}
+
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return fail("open %s: %v", dest, err)
+			}
+			ack()
+			_, copyErr := io.CopyN(f, r, size)
+			closeErr := f.Close()
+			if copyErr != nil {
+				return fail("write %s: %v", dest, copyErr)
+			}
+			if b, err := r.ReadByte(); err != nil || b != 0 {
+				return fail("missing end of file marker for %s", dest)
+			}
+			if closeErr != nil {
+				return fail("close %s: %v", dest, closeErr)
+			}
+			if err := os.Chmod(dest, mode); err != nil {
+				return fail("chmod %s: %v", dest, err)
+			}
+			if times != nil {
+				if err := os.Chtimes(dest, times[0], times[1]); err != nil {
+					return fail("chtimes %s: %v", dest, err)
+				}
+				times = nil
+			}
+			ack()
+
+		case 'E':
+			if len(dirs) == 1 {
+				return fail("unexpected E record")
+			}
+			done := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			if t := dirTimes[len(dirTimes)-1]; t != nil {
+				if err := os.Chtimes(done, t[0], t[1]); err != nil {
+					return fail("chtimes %s: %v", done, err)
+				}
+			}
+			dirTimes = dirTimes[:len(dirTimes)-1]
+			ack()
+
+		default:
+			return fail("unknown record %q", line)
+		}
+	}
+}
+
+// parseRecord parses "<mode> <size> <name>" of C and D records
+func parseRecord(s string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(s, " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("want 3 fields")
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("mode: %w", err)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("size: %q", parts[1])
+	}
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", fmt.Errorf("invalid name %q", name)
+	}
+	return os.FileMode(mode), size, name, nil
+}
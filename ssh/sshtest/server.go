@@ -0,0 +1,492 @@
+// Copyright © NGRSoftlab 2020-2025
+
+// Package sshtest provides an in-process SSH server for integration tests.
+// It listens on 127.0.0.1, supports password and public key auth, exec
+// requests (pluggable handler or the local shell), the sftp subsystem,
+// `scp -t` sink and `scp -f` source modes and keepalive requests
+package sshtest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultUser     = "test"   // user accepted when no auth option is given
+	DefaultPassword = "secret" // password accepted when no auth option is given
+)
+
+// ExecRequest describes one exec request received by the server
+type ExecRequest struct {
+	User    string            // authenticated user
+	Command string            // command line as sent by the client
+	Env     map[string]string // variables from "env" requests
+	PTY     bool              // a pty-req was accepted for the session
+	Stdin   io.Reader         // session stdin
+	Stdout  io.Writer         // session stdout
+	Stderr  io.Writer         // session stderr
+}
+
+// ExecHandler runs an exec request and returns its exit status.
+// ctx is canceled when the server is closed
+type ExecHandler func(ctx context.Context, req *ExecRequest) int
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithPassword accepts password (and keyboard-interactive) auth for user
+func WithPassword(user, password string) Option {
+	return func(s *Server) {
+		s.passwords[user] = password
+	}
+}
+
+// WithAuthorizedKey accepts public key auth for user with key
+func WithAuthorizedKey(user string, key gossh.PublicKey) Option {
+	return func(s *Server) {
+		s.keys[user] = append(s.keys[user], key)
+	}
+}
+
+// WithExecHandler replaces the local shell with h for exec requests
+func WithExecHandler(h ExecHandler) Option {
+	return func(s *Server) {
+		s.handler = h
+	}
+}
+
+// WithoutEnv rejects "env" requests, like sshd without AcceptEnv
+func WithoutEnv() Option {
+	return func(s *Server) {
+		s.rejectEnv = true
+	}
+}
+
+// Server is an in-process SSH server
+type Server struct {
+	Host string // listen host (127.0.0.1)
+	Port int    // listen port
+
+	passwords map[string]string
+	keys      map[string][]gossh.PublicKey
+	handler   ExecHandler
+	rejectEnv bool
+	hostKey   gossh.Signer
+	listener  net.Listener
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu         sync.Mutex
+	conns      map[net.Conn]struct{}
+	commands   []string
+	keepalives int
+	sessions   int
+	accepted   int
+}
+
+// NewServer starts a Server on 127.0.0.1 with a random port and registers
+// its shutdown with t.Cleanup. Without auth options it accepts
+// DefaultUser/DefaultPassword
+func NewServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	s := &Server{
+		passwords: make(map[string]string),
+		keys:      make(map[string][]gossh.PublicKey),
+		handler:   ShellHandler,
+		conns:     make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if len(s.passwords) == 0 && len(s.keys) == 0 {
+		s.passwords[DefaultUser] = DefaultPassword
+	}
+
+	signer, _, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("sshtest: generate host key: %v", err)
+	}
+	s.hostKey = signer
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("sshtest: listen: %v", err)
+	}
+	s.listener = ln
+	addr := ln.Addr().(*net.TCPAddr)
+	s.Host, s.Port = addr.IP.String(), addr.Port
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns host:port of the server
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// HostKey returns the public host key of the server
+func (s *Server) HostKey() gossh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// WriteKnownHosts writes a known_hosts file trusting the server into dir and returns its path
+func (s *Server) WriteKnownHosts(dir string) (string, error) {
+	path := filepath.Join(dir, "known_hosts")
+	line := fmt.Sprintf("[%s]:%d %s", s.Host, s.Port, gossh.MarshalAuthorizedKey(s.HostKey()))
+	return path, os.WriteFile(path, []byte(line), 0o600)
+}
+
+// Commands returns the exec commands received so far
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Keepalives returns the number of keepalive@openssh.com requests received
+func (s *Server) Keepalives() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keepalives
+}
+
+// Sessions returns the number of session channels opened so far
+func (s *Server) Sessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions
+}
+
+// Connections returns the number of connections served so far
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// DropConnections closes every open client connection, keeping the listener
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Close stops the listener and drops all connections
+func (s *Server) Close() {
+	s.cancel()
+	s.listener.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+// serve accepts TCP connections until the listener is closed
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn serves one client connection, e.g. one end of net.Pipe,
+// and returns when it is closed
+func (s *Server) ServeConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.accepted++
+	s.mu.Unlock()
+
+	s.handleConn(conn)
+
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+// serverConfig builds the x/crypto server config with the configured auth methods
+func (s *Server) serverConfig() *gossh.ServerConfig {
+	cfg := &gossh.ServerConfig{}
+	if len(s.passwords) > 0 {
+		cfg.PasswordCallback = func(meta gossh.ConnMetadata, password []byte) (*gossh.Permissions, error) {
+			if want, ok := s.passwords[meta.User()]; ok && want == string(password) {
+				return nil, nil
+			}
+			return nil, errors.New("password rejected")
+		}
+		cfg.KeyboardInteractiveCallback = func(meta gossh.ConnMetadata, challenge gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+			answers, err := challenge(meta.User(), "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if want, ok := s.passwords[meta.User()]; ok && len(answers) == 1 && answers[0] == want {
+				return nil, nil
+			}
+			return nil, errors.New("keyboard-interactive rejected")
+		}
+	}
+	if len(s.keys) > 0 {
+		cfg.PublicKeyCallback = func(meta gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			for _, k := range s.keys[meta.User()] {
+				if string(k.Marshal()) == string(key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("public key rejected")
+		}
+	}
+	cfg.AddHostKey(s.hostKey)
+	return cfg
+}
+
+// handleConn performs the SSH handshake and serves channels and global requests
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	sconn, chans, reqs, err := gossh.NewServerConn(conn, s.serverConfig())
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+
+	go func() {
+		for req := range reqs {
+			if req.Type == "keepalive@openssh.com" {
+				s.mu.Lock()
+				s.keepalives++
+				s.mu.Unlock()
+			}
+			if req.WantReply {
+				req.Reply(req.Type == "keepalive@openssh.com", nil)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(gossh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.sessions++
+		s.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleSession(sconn.User(), ch, chReqs)
+		}()
+	}
+	wg.Wait()
+}
+
+// handleSession serves requests of one session channel
+func (s *Server) handleSession(user string, ch gossh.Channel, reqs <-chan *gossh.Request) {
+	defer ch.Close()
+
+	env := make(map[string]string)
+	pty := false
+
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			pty = true
+			req.Reply(true, nil)
+
+		case "env":
+			var kv struct{ Name, Value string }
+			if s.rejectEnv || gossh.Unmarshal(req.Payload, &kv) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			env[kv.Name] = kv.Value
+			req.Reply(true, nil)
+
+		case "exec":
+			var payload struct{ Command string }
+			if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			s.mu.Lock()
+			s.commands = append(s.commands, payload.Command)
+			s.mu.Unlock()
+			go gossh.DiscardRequests(reqs)
+
+			code := s.exec(&ExecRequest{
+				User:    user,
+				Command: payload.Command,
+				Env:     env,
+				PTY:     pty,
+				Stdin:   ch,
+				Stdout:  ch,
+				Stderr:  ch.Stderr(),
+			})
+			sendExitStatus(ch, code)
+			return
+
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := gossh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go gossh.DiscardRequests(reqs)
+			srv, err := sftp.NewServer(ch)
+			if err != nil {
+				return
+			}
+			_ = srv.Serve()
+			srv.Close()
+			return
+
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// exec dispatches scp sink/source commands to the built-in scp and everything else to the handler.
+// Lines before an scp command on the last line (such as a remote workdir prologue) run
+// with `sh -c` first: a non-zero exit ends the command, otherwise relative scp paths
+// resolve against the directory the lines leave the shell in
+func (s *Server) exec(req *ExecRequest) int {
+	if args, ok := parseSCP(req.Command); ok {
+		return runSCP(args, req)
+	}
+	if i := strings.LastIndex(req.Command, "\n"); i >= 0 {
+		if args, ok := parseSCP(req.Command[i+1:]); ok {
+			dir, code := runPrologue(s.ctx, req, req.Command[:i])
+			if code != 0 {
+				return code
+			}
+			if !filepath.IsAbs(args.path) && dir != "" {
+				args.path = filepath.Join(dir, args.path)
+			}
+			return runSCP(args, req)
+		}
+	}
+	return s.handler(s.ctx, req)
+}
+
+// runPrologue runs script with `sh -c` and the request environment, forwarding its
+// stderr. It returns the final working directory of the shell and its exit code
+func runPrologue(ctx context.Context, req *ExecRequest, script string) (string, int) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", script+"\npwd")
+	cmd.Env = os.Environ()
+	for k, v := range req.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = req.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", exitErr.ExitCode()
+		}
+		fmt.Fprintf(req.Stderr, "sshtest: prologue: %v\n", err)
+		return "", 255
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	return lines[len(lines)-1], 0
+}
+
+// sendExitStatus reports the exit code to the client
+func sendExitStatus(ch gossh.Channel, code int) {
+	status := struct{ Status uint32 }{uint32(code)}
+	_, _ = ch.SendRequest("exit-status", false, gossh.Marshal(&status))
+}
+
+// ShellHandler runs the command with `sh -c` on the local machine,
+// adding variables from "env" requests to the process environment
+func ShellHandler(ctx context.Context, req *ExecRequest) int {
+	cmd := exec.CommandContext(ctx, "sh", "-c", req.Command)
+	cmd.Env = os.Environ()
+	for k, v := range req.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdout = req.Stdout
+	cmd.Stderr = req.Stderr
+
+	// stdin is copied without waiting for EOF: the client may keep it open until exit
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		fmt.Fprintf(req.Stderr, "sshtest: stdin pipe: %v\n", err)
+		return 255
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(req.Stderr, "sshtest: start: %v\n", err)
+		return 127
+	}
+	go func() {
+		_, _ = io.Copy(stdin, req.Stdin)
+		stdin.Close()
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		return 255
+	}
+	return 0
+}
+
+// GenerateKey creates an ed25519 key pair, returning the signer and the
+// OpenSSH PEM encoding of the private key (for ssh.WithKeyBytesAuth)
+func GenerateKey() (gossh.Signer, []byte, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := gossh.MarshalPrivateKey(priv, "sshtest")
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, pem.EncodeToMemory(block), nil
+}
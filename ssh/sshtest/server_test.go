@@ -0,0 +1,295 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package sshtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestParseSCP(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want scpArgs
+		ok   bool
+	}{
+		{"sink", "scp -t '/tmp/a b'", scpArgs{sink: true, path: "/tmp/a b"}, true},
+		{"sink_recursive", "/usr/bin/scp -r -t /tmp/x", scpArgs{sink: true, recursive: true, path: "/tmp/x"}, true},
+		{"combined_flags", "scp -prt dir", scpArgs{sink: true, recursive: true, preserve: true, path: "dir"}, true},
+		{"source", `scp -f "/tmp/\"q\""`, scpArgs{source: true, path: `/tmp/"q"`}, true},
+		{"escaped_quote", `scp -t '/tmp/it'\''s'`, scpArgs{sink: true, path: "/tmp/it's"}, true},
+		{"not_scp", "ls -t /tmp", scpArgs{}, false},
+		{"no_mode", "scp /tmp/x", scpArgs{}, false},
+		{"both_modes", "scp -t -f /tmp/x", scpArgs{}, false},
+		{"unknown_flag", "scp -z -t /tmp/x", scpArgs{}, false},
+		{"unterminated_quote", "scp -t '/tmp/x", scpArgs{}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := parseSCP(tc.line)
+			if ok != tc.ok || !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseSCP(%q) = %+v, %v; want %+v, %v", tc.line, got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestSCPSink(t *testing.T) {
+	dir := t.TempDir()
+	input := "T1600000000 0 1600000000 0\n" +
+		"C0640 5 a.txt\nhello\x00" +
+		"D0750 0 sub\n" +
+		"C0600 3 b.bin\n\x00\xff\n\x00" +
+		"E\n"
+
+	var stdout, stderr bytes.Buffer
+	code := runSCP(scpArgs{sink: true, recursive: true, path: dir}, &ExecRequest{
+		Stdin:  strings.NewReader(input),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr %q", code, stderr.String())
+	}
+	if want := strings.Repeat("\x00", 8); stdout.String() != want {
+		t.Errorf("acks = %q; want %q", stdout.String(), want)
+	}
+
+	checkFile(t, filepath.Join(dir, "a.txt"), "hello", 0o640)
+	checkFile(t, filepath.Join(dir, "sub", "b.bin"), "\x00\xff\n", 0o600)
+
+	info, err := os.Stat(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ModTime().Unix() != 1600000000 {
+		t.Errorf("mtime = %v; want 1600000000", info.ModTime().Unix())
+	}
+}
+
+func TestSCPSinkErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"bad_record", "X\n"},
+		{"path_in_name", "C0644 1 ../x\n"},
+		{"short_data", "C0644 10 a\nabc"},
+		{"unbalanced_e", "E\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			code := runSCP(scpArgs{sink: true, path: t.TempDir()}, &ExecRequest{
+				Stdin:  strings.NewReader(tc.input),
+				Stdout: &stdout,
+				Stderr: io.Discard,
+			})
+			if code == 0 {
+				t.Fatal("expected non-zero exit code")
+			}
+			if !bytes.Contains(stdout.Bytes(), []byte{2}) {
+				t.Errorf("stdout %q has no error record", stdout.String())
+			}
+		})
+	}
+}
+
+func TestServerExec(t *testing.T) {
+	srv := NewServer(t, WithExecHandler(func(ctx context.Context, req *ExecRequest) int {
+		io.WriteString(req.Stdout, req.User+":"+req.Command+":"+req.Env["FOO"])
+		return 3
+	}))
+
+	conn, err := gossh.Dial("tcp", srv.Addr(), &gossh.ClientConfig{
+		User:            DefaultUser,
+		Auth:            []gossh.AuthMethod{gossh.Password(DefaultPassword)},
+		HostKeyCallback: gossh.FixedHostKey(srv.HostKey()),
+	})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	sess, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	defer sess.Close()
+	if err := sess.Setenv("FOO", "bar"); err != nil {
+		t.Fatalf("setenv: %v", err)
+	}
+
+	out, err := sess.Output("whoami")
+	var exitErr *gossh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Fatalf("err = %v; want exit status 3", err)
+	}
+	if string(out) != "test:whoami:bar" {
+		t.Errorf("output = %q", out)
+	}
+	if got := srv.Commands(); !reflect.DeepEqual(got, []string{"whoami"}) {
+		t.Errorf("Commands() = %v", got)
+	}
+	if srv.Sessions() != 1 || srv.Connections() != 1 {
+		t.Errorf("sessions = %d, connections = %d; want 1, 1", srv.Sessions(), srv.Connections())
+	}
+}
+
+func TestServerExecSCPPrologue(t *testing.T) {
+	srv := NewServer(t)
+	base := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(base, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		prologue string
+		wantCode int
+		wantFile string
+	}{
+		{"cd_chain", "cd " + base + " && cd a\ncd b", 0, filepath.Join(base, "a", "b", "f.txt")},
+		{"env", "cd \"$TARGET\"", 0, filepath.Join(base, "a", "f.txt")},
+		{"failing", "cd " + base + "/missing 2>/dev/null || { echo no dir >&2; exit 42; }", 42, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := srv.exec(&ExecRequest{
+				Command: tc.prologue + "\nscp -t .",
+				Env:     map[string]string{"TARGET": filepath.Join(base, "a")},
+				Stdin:   strings.NewReader("C0644 2 f.txt\nhi\x00"),
+				Stdout:  &stdout,
+				Stderr:  &stderr,
+			})
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d; want %d (stderr %q)", code, tc.wantCode, stderr.String())
+			}
+			if tc.wantFile == "" {
+				if !strings.Contains(stderr.String(), "no dir") {
+					t.Errorf("stderr = %q; want the prologue message", stderr.String())
+				}
+				return
+			}
+			checkFile(t, tc.wantFile, "hi", 0o644)
+			if err := os.Remove(tc.wantFile); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	signer, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(t, WithAuthorizedKey("alice", signer.PublicKey()))
+
+	tests := []struct {
+		name    string
+		user    string
+		auth    gossh.AuthMethod
+		wantErr bool
+	}{
+		{"authorized_key", "alice", gossh.PublicKeys(signer), false},
+		{"unknown_key", "alice", gossh.PublicKeys(other), true},
+		{"wrong_user", "bob", gossh.PublicKeys(signer), true},
+		{"password_not_enabled", DefaultUser, gossh.Password(DefaultPassword), true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := gossh.Dial("tcp", srv.Addr(), &gossh.ClientConfig{
+				User:            tc.user,
+				Auth:            []gossh.AuthMethod{tc.auth},
+				HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("dial err = %v; wantErr %v", err, tc.wantErr)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+		})
+	}
+}
+
+func checkFile(t *testing.T, path, want string, mode os.FileMode) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if string(data) != want {
+		t.Errorf("%s = %q; want %q", path, data, want)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("%s mode = %o; want %o", path, info.Mode().Perm(), mode)
+	}
+}
+
+func TestSCPSource(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "dump.sql")
+	if err := os.WriteFile(file, []byte("data"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1600000000, 0)
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     scpArgs
+		stdin    string
+		wantCode int
+		want     string
+	}{
+		{"file", scpArgs{source: true, path: file}, "\x00\x00\x00", 0, "C0640 4 dump.sql\ndata\x00"},
+		{"preserve", scpArgs{source: true, preserve: true, path: file}, "\x00\x00\x00\x00", 0,
+			"T1600000000 0 1600000000 0\nC0640 4 dump.sql\ndata\x00"},
+		{"missing", scpArgs{source: true, path: filepath.Join(dir, "nope")}, "\x00", 1,
+			"\x01scp: " + filepath.Join(dir, "nope") + ": No such file or directory\n"},
+		{"directory", scpArgs{source: true, path: dir}, "\x00", 1, "\x01scp: " + dir + ": not a regular file\n"},
+		{"no_ready_byte", scpArgs{source: true, path: file}, "", 1, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			code := runSCP(tc.args, &ExecRequest{
+				Stdin:  strings.NewReader(tc.stdin),
+				Stdout: &stdout,
+				Stderr: io.Discard,
+			})
+			if code != tc.wantCode || stdout.String() != tc.want {
+				t.Errorf("runSCP = %d, %q; want %d, %q", code, stdout.String(), tc.wantCode, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+	"github.com/ngrsoftlab/rexec/utils"
+)
+
+const defaultMaxLineSize = 64 << 10 // default 64 KB limit for a single line event
+
+// StreamKind identifies the origin of a StreamEvent
+type StreamKind int
+
+const (
+	StreamStdout StreamKind = iota + 1 // line from standard output
+	StreamStderr                       // line from standard error
+	StreamExit                         // final event carrying exit code and error
+)
+
+// String returns a short name of the stream kind
+func (k StreamKind) String() string {
+	switch k {
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	case StreamExit:
+		return "exit"
+	default:
+		return "unknown"
+	}
+}
+
+// StreamEvent is one line of live command output or the final exit notification
+type StreamEvent struct {
+	Stream    StreamKind // stdout, stderr or exit
+	Line      []byte     // line content without the trailing newline (empty for exit)
+	Partial   bool       // line was not terminated by a newline (EOF or max line size reached)
+	Timestamp time.Time  // time the event was produced
+	ExitCode  int        // process exit code, set for StreamExit only
+	Err       error      // run error, set for StreamExit only
+}
+
+// Text returns Line as a string
+func (e StreamEvent) Text() string {
+	return string(e.Line)
+}
+
+// LineWriter is an io.Writer that splits written data into lines and sends
+// each of them to a channel as a StreamEvent. Sends block until the receiver
+// is ready or ctx is done, so a slow consumer slows down the producer
+type LineWriter struct {
+	ctx     context.Context    // cancels blocked sends
+	kind    StreamKind         // stream kind stamped on every event
+	ch      chan<- StreamEvent // destination channel
+	maxLine int                // max bytes in one event before a partial flush
+
+	mu  sync.Mutex // guards buf
+	buf []byte     // incomplete line waiting for a newline
+}
+
+// NewLineWriter creates a LineWriter that delivers lines of kind to ch
+func NewLineWriter(ctx context.Context, kind StreamKind, ch chan<- StreamEvent) *LineWriter {
+	return &LineWriter{
+		ctx:     ctx,
+		kind:    kind,
+		ch:      ch,
+		maxLine: defaultMaxLineSize,
+	}
+}
+
+// Write splits p into lines and sends every complete one. A trailing
+// incomplete line is kept until the next Write or Flush
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(w.buf[:i], []byte{'\r'})
+		if err := w.send(line, false); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[i+1:]
+	}
+
+	for len(w.buf) >= w.maxLine {
+		if err := w.send(w.buf[:w.maxLine], true); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[w.maxLine:]
+	}
+
+	// compact so the backing array does not grow without bound
+	w.buf = append([]byte(nil), w.buf...)
+	return len(p), nil
+}
+
+// Flush sends a pending incomplete line, if any, as a partial event
+func (w *LineWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.send(w.buf, true)
+	w.buf = nil
+	return err
+}
+
+// send copies line into a new event and delivers it to the channel
+func (w *LineWriter) send(line []byte, partial bool) error {
+	ev := StreamEvent{
+		Stream:    w.kind,
+		Line:      bytes.Clone(line),
+		Partial:   partial,
+		Timestamp: time.Now(),
+	}
+	select {
+	case w.ch <- ev:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// EmitExit sends the final StreamExit event built from rr and err to ch.
+// It gives up if ctx is done before the receiver is ready
+func EmitExit(ctx context.Context, ch chan<- StreamEvent, rr *parser.RawResult, err error) {
+	ev := StreamEvent{
+		Stream:    StreamExit,
+		Timestamp: time.Now(),
+		ExitCode:  -1,
+		Err:       err,
+	}
+	if rr != nil {
+		ev.ExitCode = rr.ExitCode
+	}
+
+	select {
+	case ch <- ev:
+	case <-ctx.Done():
+	}
+}
+
+// Stream runs cmd on client in the background and returns a channel of its output lines
+// followed by a single StreamExit event, after which the channel is closed.
+// withEvents is the events option of the client package (ssh.WithEvents or local.WithEvents).
+// Exit events sent by the client are dropped and the final one is built from what Run
+// returns, so a client wrapped in Retry still ends the stream once, with the lines of every
+// attempt before it. The channel is unbuffered: stop reading only after cancelling ctx
+func Stream[O any](ctx context.Context, client Client[O], cmd *command.Command,
+	withEvents func(chan<- StreamEvent) O, opts ...O) <-chan StreamEvent {
+	ch := make(chan StreamEvent)
+
+	go func() {
+		defer close(ch)
+		if client == nil {
+			EmitExit(ctx, ch, nil, utils.ErrClientNil)
+			return
+		}
+
+		lines := make(chan StreamEvent)
+		stop := make(chan struct{})
+		forwarded := make(chan struct{})
+		go func() {
+			defer close(forwarded)
+			for {
+				select {
+				case ev := <-lines:
+					if ev.Stream == StreamExit {
+						continue
+					}
+					select {
+					case ch <- ev:
+					case <-ctx.Done():
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+
+		runOpts := append(append([]O(nil), opts...), withEvents(lines))
+		rr, err := client.Run(ctx, cmd, nil, runOpts...)
+		close(stop)
+		<-forwarded
+		EmitExit(ctx, ch, rr, err)
+	}()
+
+	return ch
+}
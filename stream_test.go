@@ -0,0 +1,204 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+)
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		writes      []string
+		wantLines   []string
+		wantPartial []bool
+	}{
+		{"single_line", []string{"hello\n"}, []string{"hello"}, []bool{false}},
+		{"split_across_writes", []string{"hel", "lo\nwor", "ld\n"}, []string{"hello", "world"}, []bool{false, false}},
+		{"crlf", []string{"a\r\nb\r\n"}, []string{"a", "b"}, []bool{false, false}},
+		{"trailing_partial", []string{"a\nb"}, []string{"a", "b"}, []bool{false, true}},
+		{"empty_lines", []string{"\n\n"}, []string{"", ""}, []bool{false, false}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := make(chan StreamEvent, 16)
+			w := NewLineWriter(context.Background(), StreamStdout, ch)
+			for _, s := range tc.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			close(ch)
+
+			var i int
+			for ev := range ch {
+				if i >= len(tc.wantLines) {
+					t.Fatalf("unexpected extra event %q", ev.Text())
+				}
+				if ev.Stream != StreamStdout {
+					t.Errorf("Stream = %v; want stdout", ev.Stream)
+				}
+				if ev.Text() != tc.wantLines[i] || ev.Partial != tc.wantPartial[i] {
+					t.Errorf("event %d = (%q, %v); want (%q, %v)", i, ev.Text(), ev.Partial, tc.wantLines[i], tc.wantPartial[i])
+				}
+				i++
+			}
+			if i != len(tc.wantLines) {
+				t.Errorf("got %d events; want %d", i, len(tc.wantLines))
+			}
+		})
+	}
+}
+
+func TestLineWriter_LongLine(t *testing.T) {
+	ch := make(chan StreamEvent, 4)
+	w := NewLineWriter(context.Background(), StreamStderr, ch)
+	w.maxLine = 4
+
+	if _, err := w.Write([]byte("abcdefghij\n")); err != nil {
+		t.Fatalf("Write error = %v", err)
+	}
+	close(ch)
+
+	var got []string
+	for ev := range ch {
+		got = append(got, ev.Text())
+	}
+	if strings.Join(got, "|") != "abcdefghij" {
+		t.Errorf("events = %q; want one complete line", got)
+	}
+
+	ch = make(chan StreamEvent, 4)
+	w = NewLineWriter(context.Background(), StreamStderr, ch)
+	w.maxLine = 4
+	if _, err := w.Write([]byte("abcdefghij")); err != nil {
+		t.Fatalf("Write error = %v", err)
+	}
+	_ = w.Flush()
+	close(ch)
+
+	got = got[:0]
+	for ev := range ch {
+		if !ev.Partial {
+			t.Errorf("event %q: Partial = false; want true", ev.Text())
+		}
+		got = append(got, ev.Text())
+	}
+	if strings.Join(got, "|") != "abcd|efgh|ij" {
+		t.Errorf("events = %q; want [abcd efgh ij]", got)
+	}
+}
+
+func TestLineWriter_Backpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan StreamEvent)
+	w := NewLineWriter(ctx, StreamStdout, ch)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("blocked\n"))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Write returned %v before the line was received", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Write error = %v; want context.Canceled", err)
+	}
+}
+
+func TestEmitExit(t *testing.T) {
+	ch := make(chan StreamEvent, 1)
+	wantErr := errors.New("boom")
+	EmitExit(context.Background(), ch, nil, wantErr)
+
+	ev := <-ch
+	if ev.Stream != StreamExit || ev.ExitCode != -1 || !errors.Is(ev.Err, wantErr) {
+		t.Errorf("event = %+v; want exit with code -1 and err %v", ev, wantErr)
+	}
+}
+
+// eventClient fails its first failures runs and reports every run to the events option
+type eventClient struct {
+	failures int
+	calls    int
+}
+
+func (c *eventClient) Run(ctx context.Context, cmd *command.Command, _ any,
+	opts ...chan<- StreamEvent) (*parser.RawResult, error) {
+	c.calls++
+	rr := parser.NewRawResult(cmd)
+	if c.calls <= c.failures {
+		rr.ExitCode = 1
+		rr.Err = errors.New("temporary")
+	}
+	for _, ch := range opts {
+		select {
+		case ch <- StreamEvent{Stream: StreamStdout, Line: []byte("attempt")}:
+		case <-ctx.Done():
+		}
+		EmitExit(ctx, ch, rr, rr.Err)
+	}
+	return rr, rr.Err
+}
+
+func (c *eventClient) Close() error { return nil }
+
+func TestStream_Retry(t *testing.T) {
+	withEvents := func(ch chan<- StreamEvent) chan<- StreamEvent { return ch }
+	retry := Retry[chan<- StreamEvent](3, time.Millisecond, nil)
+
+	t.Run("stream_single_exit", func(t *testing.T) {
+		cl := Wrap[chan<- StreamEvent](&eventClient{failures: 2}, retry)
+		var lines, exits int
+		var last StreamEvent
+		for ev := range Stream(context.Background(), cl, command.New("flaky"), withEvents) {
+			if ev.Stream == StreamExit {
+				exits++
+				last = ev
+				continue
+			}
+			lines++
+		}
+		if lines != 3 || exits != 1 {
+			t.Errorf("lines = %d, exits = %d; want 3 and 1", lines, exits)
+		}
+		if last.ExitCode != 0 || last.Err != nil {
+			t.Errorf("exit = %+v; want code 0 and no error", last)
+		}
+	})
+
+	t.Run("run_exit_per_attempt", func(t *testing.T) {
+		cl := Wrap[chan<- StreamEvent](&eventClient{failures: 2}, retry)
+		ch := make(chan StreamEvent, 16)
+		if _, err := cl.Run(context.Background(), command.New("flaky"), nil, ch); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		close(ch)
+		exits := 0
+		for ev := range ch {
+			if ev.Stream == StreamExit {
+				exits++
+			}
+		}
+		if exits != 3 {
+			t.Errorf("exits = %d; want 3", exits)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+)
+
+// instrumentationName identifies rexec tracers and meters
+const instrumentationName = "github.com/ngrsoftlab/rexec"
+
+// span attribute keys set by clients and transfers
+const (
+	AttrHost     = attribute.Key("rexec.host")      // target host
+	AttrCommand  = attribute.Key("rexec.command")   // redacted command line
+	AttrExitCode = attribute.Key("rexec.exit_code") // command exit code
+	AttrBytes    = attribute.Key("rexec.bytes")     // bytes transferred
+	AttrDuration = attribute.Key("rexec.duration")  // duration in seconds
+	AttrPath     = attribute.Key("rexec.path")      // remote file path
+	AttrAttempt  = attribute.Key("rexec.attempt")   // dial attempt number
+)
+
+// Telemetry emits OpenTelemetry spans and metrics for clients and transfers.
+// It only depends on the OTel API; a nil *Telemetry disables instrumentation
+type Telemetry struct {
+	tracer    trace.Tracer
+	redactors []parser.Redactor
+
+	runDuration    metric.Float64Histogram   // rexec.run.duration
+	runFailures    metric.Int64Counter       // rexec.run.failures
+	activeSessions metric.Int64UpDownCounter // rexec.sessions.active
+	transferBytes  metric.Int64Counter       // rexec.transfer.bytes
+}
+
+// NewTelemetry creates a Telemetry using tp and mp (the global providers when nil).
+// Command lines pass through redactors before they are attached to spans
+func NewTelemetry(tp trace.TracerProvider, mp metric.MeterProvider, redactors ...parser.Redactor) (*Telemetry, error) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	t := &Telemetry{tracer: tp.Tracer(instrumentationName), redactors: redactors}
+	var err error
+	if t.runDuration, err = meter.Float64Histogram("rexec.run.duration",
+		metric.WithDescription("Duration of command runs"), metric.WithUnit("s")); err != nil {
+		return nil, fmt.Errorf("create run duration histogram: %w", err)
+	}
+	if t.runFailures, err = meter.Int64Counter("rexec.run.failures",
+		metric.WithDescription("Failed command runs by exit code")); err != nil {
+		return nil, fmt.Errorf("create run failures counter: %w", err)
+	}
+	if t.activeSessions, err = meter.Int64UpDownCounter("rexec.sessions.active",
+		metric.WithDescription("Open sessions")); err != nil {
+		return nil, fmt.Errorf("create active sessions counter: %w", err)
+	}
+	if t.transferBytes, err = meter.Int64Counter("rexec.transfer.bytes",
+		metric.WithDescription("Bytes uploaded by file transfers"), metric.WithUnit("By")); err != nil {
+		return nil, fmt.Errorf("create transfer bytes counter: %w", err)
+	}
+	return t, nil
+}
+
+// Start opens a span named name. With a nil Telemetry it returns ctx and a no-op span
+func (t *Telemetry) Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, noop.Span{}
+	}
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span (if any) and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartRun opens the span of one Run. The command line passes through redactors (the
+// secrets of the client) and then those of t. The returned function ends the span with
+// the result attributes and records run metrics; it accepts a nil result
+func (t *Telemetry) StartRun(ctx context.Context, name string, cmd *command.Command, host string, redactors ...parser.Redactor) (context.Context, func(rr *parser.RawResult, err error)) {
+	if t == nil {
+		return ctx, func(*parser.RawResult, error) {}
+	}
+
+	start := time.Now()
+	ctx, span := t.Start(ctx, name, AttrHost.String(host), AttrCommand.String(redact(cmd, slices.Concat(redactors, t.redactors))))
+	return ctx, func(rr *parser.RawResult, err error) {
+		d := time.Since(start)
+		code := -1
+		if rr != nil {
+			code = rr.ExitCode
+			if rr.Duration > 0 {
+				d = rr.Duration
+			}
+			span.SetAttributes(AttrBytes.Int64(int64(len(rr.StdoutBytes) + len(rr.StderrBytes))))
+		}
+		span.SetAttributes(AttrExitCode.Int(code), AttrDuration.Float64(d.Seconds()))
+
+		hostAttr := metric.WithAttributes(AttrHost.String(host))
+		t.runDuration.Record(ctx, d.Seconds(), hostAttr)
+		if err != nil {
+			t.runFailures.Add(ctx, 1, metric.WithAttributes(AttrHost.String(host), AttrExitCode.Int(code)))
+		}
+		End(span, err)
+	}
+}
+
+// SessionOpened counts an open session; the returned function must be called when it closes
+func (t *Telemetry) SessionOpened(ctx context.Context, host string) func() {
+	if t == nil {
+		return func() {}
+	}
+	attrs := metric.WithAttributes(AttrHost.String(host))
+	t.activeSessions.Add(ctx, 1, attrs)
+	return func() {
+		t.activeSessions.Add(context.WithoutCancel(ctx), -1, attrs)
+	}
+}
+
+// StartTransfer opens the span of one file transfer. The returned function ends it
+// with the number of bytes sent and counts them in rexec.transfer.bytes
+func (t *Telemetry) StartTransfer(ctx context.Context, name, host, path string) (context.Context, func(n int64, err error)) {
+	if t == nil {
+		return ctx, func(int64, error) {}
+	}
+
+	start := time.Now()
+	ctx, span := t.Start(ctx, name, AttrHost.String(host), AttrPath.String(path))
+	return ctx, func(n int64, err error) {
+		span.SetAttributes(AttrBytes.Int64(n), AttrDuration.Float64(time.Since(start).Seconds()))
+		t.transferBytes.Add(ctx, n, metric.WithAttributes(AttrHost.String(host)))
+		End(span, err)
+	}
+}
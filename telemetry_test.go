@@ -0,0 +1,167 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package rexec
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ngrsoftlab/rexec/command"
+	"github.com/ngrsoftlab/rexec/parser"
+)
+
+// newTestTelemetry returns a Telemetry backed by in-memory span and metric readers
+func newTestTelemetry(t *testing.T) (*Telemetry, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	t.Helper()
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	tel, err := NewTelemetry(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+		parser.RedactPattern(regexp.MustCompile(`--password=(\S+)`)),
+	)
+	if err != nil {
+		t.Fatalf("NewTelemetry: %v", err)
+	}
+	return tel, spans, reader
+}
+
+// spanAttr returns the value of key on span
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// collect returns the collected metrics by name
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Metrics {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	out := make(map[string]metricdata.Metrics)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			out[m.Name] = m
+		}
+	}
+	return out
+}
+
+func TestTelemetry_StartRun(t *testing.T) {
+	tel, spans, reader := newTestTelemetry(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		exitCode int
+		err      error
+	}{
+		{"success", 0, nil},
+		{"failure", 2, errors.New("exit 2")},
+	}
+	for _, tc := range tests {
+		cmd := command.New("deploy --password=hunter2")
+		_, finish := tel.StartRun(ctx, "test.run", cmd, "web-1")
+		rr := parser.NewRawResult(cmd)
+		rr.ExitCode = tc.exitCode
+		rr.StdoutBytes = []byte("abc")
+		rr.Duration = 1500 * time.Millisecond
+		finish(rr, tc.err)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("ended %d spans; want 2", len(ended))
+	}
+	for i, tc := range tests {
+		span := ended[i]
+		if got := spanAttr(span, AttrCommand).AsString(); got != "deploy --password=***" {
+			t.Errorf("%s: command attr = %q", tc.name, got)
+		}
+		if got := spanAttr(span, AttrExitCode).AsInt64(); got != int64(tc.exitCode) {
+			t.Errorf("%s: exit code attr = %d", tc.name, got)
+		}
+		if got := spanAttr(span, AttrBytes).AsInt64(); got != 3 {
+			t.Errorf("%s: bytes attr = %d", tc.name, got)
+		}
+		if got := spanAttr(span, AttrDuration).AsFloat64(); got != 1.5 {
+			t.Errorf("%s: duration attr = %v", tc.name, got)
+		}
+		if wantErr := tc.err != nil; (span.Status().Code == codes.Error) != wantErr {
+			t.Errorf("%s: status = %v", tc.name, span.Status())
+		}
+	}
+
+	metrics := collect(t, reader)
+	hist, ok := metrics["rexec.run.duration"].Data.(metricdata.Histogram[float64])
+	if !ok || len(hist.DataPoints) != 1 || hist.DataPoints[0].Count != 2 {
+		t.Errorf("run duration = %+v; want 2 observations", metrics["rexec.run.duration"].Data)
+	}
+	failures, ok := metrics["rexec.run.failures"].Data.(metricdata.Sum[int64])
+	if !ok || len(failures.DataPoints) != 1 || failures.DataPoints[0].Value != 1 {
+		t.Fatalf("run failures = %+v; want one failure", metrics["rexec.run.failures"].Data)
+	}
+	if code, _ := failures.DataPoints[0].Attributes.Value(AttrExitCode); code.AsInt64() != 2 {
+		t.Errorf("failure exit code = %v; want 2", code.AsInt64())
+	}
+}
+
+func TestTelemetry_SessionsAndTransfers(t *testing.T) {
+	tel, spans, reader := newTestTelemetry(t)
+	ctx := context.Background()
+
+	done1 := tel.SessionOpened(ctx, "web-1")
+	done2 := tel.SessionOpened(ctx, "web-1")
+	done1()
+
+	_, finish := tel.StartTransfer(ctx, "test.copy", "web-1", "/etc/app.conf")
+	finish(42, nil)
+
+	metrics := collect(t, reader)
+	active, ok := metrics["rexec.sessions.active"].Data.(metricdata.Sum[int64])
+	if !ok || len(active.DataPoints) != 1 || active.DataPoints[0].Value != 1 {
+		t.Errorf("active sessions = %+v; want 1", metrics["rexec.sessions.active"].Data)
+	}
+	transferred, ok := metrics["rexec.transfer.bytes"].Data.(metricdata.Sum[int64])
+	if !ok || len(transferred.DataPoints) != 1 || transferred.DataPoints[0].Value != 42 {
+		t.Errorf("transfer bytes = %+v; want 42", metrics["rexec.transfer.bytes"].Data)
+	}
+	done2()
+
+	ended := spans.Ended()
+	if len(ended) != 1 || ended[0].Name() != "test.copy" || spanAttr(ended[0], AttrPath).AsString() != "/etc/app.conf" {
+		t.Errorf("spans = %v; want one test.copy span with path", ended)
+	}
+}
+
+func TestTelemetry_Nil(t *testing.T) {
+	var tel *Telemetry
+	ctx := context.Background()
+
+	gotCtx, span := tel.Start(ctx, "x")
+	if gotCtx != ctx || span.IsRecording() {
+		t.Error("nil Telemetry must return ctx and a non-recording span")
+	}
+	End(span, errors.New("ignored"))
+
+	_, finishRun := tel.StartRun(ctx, "x", command.New("true"), "h")
+	finishRun(nil, nil)
+	tel.SessionOpened(ctx, "h")()
+	_, finishCopy := tel.StartTransfer(ctx, "x", "h", "/p")
+	finishCopy(1, nil)
+}
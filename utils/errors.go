@@ -8,8 +8,15 @@ import (
 )
 
 var (
-	ErrSessionNotOpen = errors.New("session not open")
-	ErrClientNil      = errors.New("client is nil")
+	ErrSessionNotOpen   = errors.New("session not open")
+	ErrClientNil        = errors.New("client is nil")
+	ErrCommandNil       = errors.New("command is nil")
+	ErrCommandDenied    = errors.New("command denied by policy")
+	ErrPanicRecovered   = errors.New("recovered from panic on run")
+	ErrAuthFailed       = errors.New("ssh authentication failed")
+	ErrWorkdirMissing   = errors.New("working directory does not exist")
+	ErrUnknownSize      = errors.New("content size unknown")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
 )
 
 // ExitCodeMapper translates process exit codes into human-readable messages
@@ -0,0 +1,21 @@
+// Copyright © NGRSoftlab 2020-2025
+
+package utils
+
+import (
+	"context"
+	"log/slog"
+)
+
+// nopHandler is a slog.Handler that drops every record
+type nopHandler struct{}
+
+func (nopHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (nopHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h nopHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h nopHandler) WithGroup(string) slog.Handler           { return h }
+
+// NopLogger returns a logger that discards everything, used when no logger is configured
+func NopLogger() *slog.Logger {
+	return slog.New(nopHandler{})
+}